@@ -0,0 +1,146 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+/*
+ * Handles for connections that have no descriptor of their own. They start well
+ * above anything the kernel will hand out so the two can never collide.
+ */
+var next_stream_handle int64 = 1 << 24
+
+//========================================================================================
+
+/*
+ * Dial connects to a running neovim and returns a handle for the connection that
+ * can be passed as the fd argument of any api function. The address may be
+ *
+ *   - a path to a unix socket (anything containing a '/'),
+ *   - "host:port" for tcp, as given to `nvim --listen 127.0.0.1:6666`,
+ *   - "@name" for a linux abstract socket, or
+ *   - a bare name, which is looked for in $XDG_RUNTIME_DIR and then the
+ *     temporary directory.
+ *
+ * The handle is the descriptor of the underlying socket, so it can never clash
+ * with a connection made directly on a file descriptor.
+ */
+func Dial(addr string) (int, error) {
+	network, address, err := parse_address(addr)
+	if err != nil {
+		return (-1), err
+	}
+
+	c, err := net.Dial(network, address)
+	if err != nil {
+		return (-1), fmt.Errorf("Failed to connect to neovim at '%s': %s", addr, err)
+	}
+
+	fd, err := conn_fd(c)
+	if err != nil {
+		c.Close()
+		return (-1), err
+	}
+
+	conns_mutex.Lock()
+	conns[fd] = new_conn(addr, c, c)
+	conns_mutex.Unlock()
+
+	return fd, nil
+}
+
+// Server_Address returns the address of the neovim instance this process was
+// started from, as advertised in $NVIM (or $NVIM_LISTEN_ADDRESS for older
+// versions). It returns an empty string if there is none.
+func Server_Address() string {
+	for _, name := range []string{"NVIM", "NVIM_LISTEN_ADDRESS"} {
+		if addr := os.Getenv(name); addr != "" {
+			return addr
+		}
+	}
+	return ""
+}
+
+/*
+ * Connect_Stream makes a connection over an arbitrary reader and writer, such
+ * as one end of a net.Pipe, and returns a handle for it that works like one
+ * returned by Dial.
+ */
+func Connect_Stream(name string, r io.Reader, w io.Writer) int {
+	fd := int(atomic.AddInt64(&next_stream_handle, 1))
+
+	conns_mutex.Lock()
+	conns[fd] = new_conn(name, r, w)
+	conns_mutex.Unlock()
+
+	return fd
+}
+
+// Disconnect forgets the connection with the given handle. Whoever owns the
+// underlying stream is responsible for closing it; losing it afterwards is not
+// reported as an error.
+func Disconnect(fd int) {
+	remove_conn(fd)
+}
+
+//========================================================================================
+
+func parse_address(addr string) (network, address string, err error) {
+	switch {
+	case addr == "":
+		return "", "", errors.New("Empty neovim address")
+	case strings.ContainsRune(addr, '/'):
+		return "unix", addr, nil
+	case addr[0] == '@':
+		return "unix", addr, nil
+	}
+
+	if host, port, e := net.SplitHostPort(addr); e == nil && port != "" {
+		if host == "" {
+			host = "localhost"
+		}
+		return "tcp", net.JoinHostPort(host, port), nil
+	}
+
+	for _, dir := range []string{os.Getenv("XDG_RUNTIME_DIR"), os.TempDir()} {
+		if dir == "" {
+			continue
+		}
+		path := filepath.Join(dir, addr)
+		if st, e := os.Stat(path); e == nil && st.Mode()&os.ModeSocket != 0 {
+			return "unix", path, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("Cannot interpret '%s' as a neovim address", addr)
+}
+
+/*
+ * Get at the descriptor without dup'ing it (which is what File() would do), so
+ * that it stays valid exactly as long as the connection does.
+ */
+func conn_fd(c net.Conn) (int, error) {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return (-1), fmt.Errorf("Connection of type %T has no file descriptor", c)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return (-1), err
+	}
+
+	fd := (-1)
+	if err = raw.Control(func(sfd uintptr) { fd = int(sfd) }); err != nil {
+		return (-1), err
+	}
+
+	return fd, nil
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// An Embedded is a neovim process started by us and driven over its stdin and
+// stdout, as with `nvim --embed`. Fd is the handle to pass to the api
+// functions.
+type Embedded struct {
+	Fd     int
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *os.File
+	once   sync.Once
+}
+
+// Binary used by Embed. It can be overridden with $NVIM_BIN.
+var Nvim_Binary = "nvim"
+
+//========================================================================================
+
+/*
+ * Embed starts `nvim --embed --headless` with any extra arguments appended and
+ * connects to it. The child gets neither a user config nor shada unless the
+ * arguments ask for them, so it behaves the same on every machine.
+ */
+func Embed(args ...string) (*Embedded, error) {
+	bin := Nvim_Binary
+	if env := os.Getenv("NVIM_BIN"); env != "" {
+		bin = env
+	}
+
+	argv := append([]string{"--embed", "--headless", "-u", "NONE", "-i", "NONE", "-n"}, args...)
+	cmd := exec.Command(bin, argv...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	pipe, err := cmd.StdoutPipe()
+	if err != nil {
+		stdin.Close()
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		stdin.Close()
+		return nil, fmt.Errorf("Failed to start %s: %s", bin, err)
+	}
+
+	/* The read end of the child's stdout is a descriptor of our own that lives
+	 * exactly as long as the connection, so it makes a unique handle. */
+	stdout := pipe.(*os.File)
+	emb := &Embedded{
+		Fd:     int(stdout.Fd()),
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: stdout,
+	}
+
+	conns_mutex.Lock()
+	conns[emb.Fd] = new_conn(fmt.Sprintf("embedded nvim (pid %d)", cmd.Process.Pid), stdout, stdin)
+	conns_mutex.Unlock()
+
+	/* Make sure it really came up before handing it out. */
+	if _, err = New_Client(emb.Fd).Nvim_get_api_info(); err != nil {
+		emb.Close()
+		return nil, fmt.Errorf("Embedded nvim is not responding: %s", err)
+	}
+
+	return emb, nil
+}
+
+// Client returns a Client for the embedded instance.
+func (emb *Embedded) Client() *Client {
+	return New_Client(emb.Fd)
+}
+
+/*
+ * Close asks neovim to quit and waits for it, killing it if it hasn't gone
+ * within a few seconds.
+ */
+func (emb *Embedded) Close() (err error) {
+	emb.once.Do(func() {
+		if conn := remove_conn(emb.Fd); conn != nil {
+			conn.send_request(false, []byte("nvim_command"), "s", "qa!")
+		}
+		emb.stdin.Close()
+
+		done := make(chan error, 1)
+		go func() { done <- emb.cmd.Wait() }()
+
+		select {
+		case err = <-done:
+		case <-time.After(5 * time.Second):
+			emb.cmd.Process.Kill()
+			err = <-done
+		}
+	})
+
+	return err
+}
@@ -0,0 +1,360 @@
+/*
+ * Generate typed wrappers for every neovim api function from the metadata
+ * printed by `nvim --api-info`. Run through `go generate` in the api package:
+ *
+ *     go run tag_highlight/api/gen_api [-i dump.mpack] [-o nvim_api_gen.go]
+ *
+ * Without -i, nvim is run to produce the dump. The header of the output names
+ * the dump it was generated from and the neovim version that dump describes.
+ */
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"tag_highlight/mpack"
+)
+
+type param struct {
+	name, ntype string
+}
+
+type function struct {
+	name       string
+	params     []param
+	ret        string
+	deprecated bool
+}
+
+type type_info struct {
+	gotype string
+	format string
+	call   string
+}
+
+var go_keywords = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true, "continue": true,
+	"default": true, "defer": true, "else": true, "fallthrough": true, "for": true,
+	"func": true, "go": true, "goto": true, "if": true, "import": true,
+	"interface": true, "map": true, "package": true, "range": true, "return": true,
+	"select": true, "struct": true, "switch": true, "type": true, "var": true,
+}
+
+//========================================================================================
+
+func main() {
+	var (
+		infile  = flag.String("i", "", "file containing the output of `nvim --api-info`")
+		outfile = flag.String("o", "", "file to write (default: standard output)")
+		nvim    = flag.String("nvim", "nvim", "neovim binary to run when no input file is given")
+	)
+	flag.Parse()
+
+	var (
+		dump   []byte
+		source string
+		err    error
+	)
+	if *infile != "" {
+		dump, err = ioutil.ReadFile(*infile)
+		source = *infile
+	} else {
+		dump, err = exec.Command(*nvim, "--api-info").Output()
+		source = "`" + *nvim + " --api-info`"
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gen_api: %s\n", err)
+		os.Exit(1)
+	}
+
+	info, err := mpack.Decode_Bytes(dump)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gen_api: failed to decode api info: %s\n", err)
+		os.Exit(1)
+	}
+	code, err := generate(info, source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gen_api: %s\n", err)
+		os.Exit(1)
+	}
+	src, err := format.Source(code)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gen_api: generated invalid code: %s\n", err)
+		os.Exit(1)
+	}
+
+	if *outfile == "" {
+		os.Stdout.Write(src)
+	} else if err = ioutil.WriteFile(*outfile, src, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "gen_api: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+//========================================================================================
+
+/* Source says where the dump came from, for the header. */
+func generate(info *mpack.Object, source string) ([]byte, error) {
+	var (
+		buf   bytes.Buffer
+		funcs []function
+		ver   map[string]*mpack.Object
+	)
+
+	ents, err := expect_map(info)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ents {
+		key, err := expect_string(&ents[i].Key)
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case "version":
+			if ver, err = get_dict(&ents[i].Value); err != nil {
+				return nil, err
+			}
+		case "functions":
+			lst, err := expect_array(&ents[i].Value)
+			if err != nil {
+				return nil, err
+			}
+			for i := range lst {
+				fn, err := get_function(&lst[i])
+				if err != nil {
+					return nil, err
+				}
+				funcs = append(funcs, fn)
+			}
+		}
+	}
+	sort.Slice(funcs, func(i, x int) bool { return funcs[i].name < funcs[x].name })
+
+	fmt.Fprintf(&buf, "// Code generated by gen_api from %s; DO NOT EDIT.\n", source)
+	if ver != nil {
+		fmt.Fprintf(&buf, "// Neovim v%d.%d.%d, api level %d.\n",
+			ver["major"].Get_Int(), ver["minor"].Get_Int(), ver["patch"].Get_Int(),
+			ver["api_level"].Get_Int())
+	}
+	fmt.Fprintf(&buf, "\npackage api\n\nimport \"tag_highlight/mpack\"\n")
+
+	for _, fn := range funcs {
+		if !fn.deprecated {
+			write_function(&buf, &fn)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func write_function(buf *bytes.Buffer, fn *function) {
+	var (
+		ret     = map_type(fn.ret)
+		names   = make([]string, len(fn.params))
+		decls   = make([]string, len(fn.params))
+		formats = make([]string, len(fn.params))
+		recv    = "c"
+	)
+
+	for i, p := range fn.params {
+		names[i] = p.name
+		if go_keywords[p.name] || p.name == recv || p.name == "mpack" {
+			names[i] += "_"
+		}
+		t := map_type(p.ntype)
+		if t.gotype == "*mpack.Object" {
+			t.gotype = param_type(p.ntype)
+		}
+		decls[i] = names[i] + " " + t.gotype
+		formats[i] = t.format
+	}
+
+	var (
+		gname   = "N" + fn.name[1:]
+		args    = fmt.Sprintf("c.fd, %q, %q", fn.name, strings.Join(formats, ","))
+		retdecl string
+		body    string
+	)
+	if len(names) > 0 {
+		args += ", " + strings.Join(names, ", ")
+	}
+
+	switch {
+	case fn.ret == "void":
+		retdecl = "error"
+		body = fmt.Sprintf("return verify_only_call(%s)", args)
+	case ret.call == "":
+		retdecl = "(*mpack.Object, error)"
+		body = fmt.Sprintf("return object_call(%s)", args)
+	default:
+		retdecl = "(" + ret.gotype + ", error)"
+		body = fmt.Sprintf("return %s(%s)", ret.call, args)
+	}
+
+	fmt.Fprintf(buf, "\n// %s calls %s(%s) -> %s.\n", gname, fn.name, param_doc(fn), fn.ret)
+	fmt.Fprintf(buf, "func (c *Client) %s(%s) %s {\n\t%s\n}\n",
+		gname, strings.Join(decls, ", "), retdecl, body)
+}
+
+func param_doc(fn *function) string {
+	strs := make([]string, len(fn.params))
+	for i, p := range fn.params {
+		strs[i] = p.ntype + " " + p.name
+	}
+	return strings.Join(strs, ", ")
+}
+
+//========================================================================================
+
+/*
+ * Everything that doesn't have an obvious go equivalent is passed in as a
+ * generic value (encoded with mpack.Encode_Value) and handed back as the raw
+ * *mpack.Object neovim returned.
+ */
+func map_type(ntype string) type_info {
+	switch ntype {
+	case "Buffer", "Window", "Tabpage":
+		return type_info{"int", "d", "int_call"}
+	case "Integer":
+		return type_info{"int64", "l", "int64_call"}
+	case "Boolean":
+		return type_info{"bool", "B", "bool_call"}
+	case "String":
+		return type_info{"string", "s", "string_call"}
+	case "Float":
+		return type_info{"float64", "f", "float_call"}
+	case "ArrayOf(String)":
+		return type_info{"[]string", "v", "strlist_call"}
+	}
+
+	if strings.HasPrefix(ntype, "ArrayOf(") {
+		elem := strings.TrimSuffix(ntype[8:], ")")
+		if i := strings.IndexByte(elem, ','); i != (-1) {
+			elem = elem[:i]
+		}
+		switch elem {
+		case "Integer", "Buffer", "Window", "Tabpage":
+			return type_info{"[]int", "v", "intlist_call"}
+		}
+	}
+
+	return type_info{"*mpack.Object", "v", ""}
+}
+
+/*
+ * Typed nil maps and slices still encode as an empty dictionary or array, which
+ * is what neovim wants for the ubiquitous `opts` parameters.
+ */
+func param_type(ntype string) string {
+	switch {
+	case ntype == "Dictionary":
+		return "map[string]interface{}"
+	case ntype == "Array" || strings.HasPrefix(ntype, "ArrayOf("):
+		return "[]interface{}"
+	default:
+		return "interface{}"
+	}
+}
+
+//========================================================================================
+
+func expect_map(obj *mpack.Object) ([]mpack.Map_Entry, error) {
+	val, err := obj.Expect(mpack.T_MAP)
+	if err != nil {
+		return nil, err
+	}
+	ents, _ := val.([]mpack.Map_Entry)
+	return ents, nil
+}
+
+func expect_array(obj *mpack.Object) ([]mpack.Object, error) {
+	val, err := obj.Expect(mpack.T_ARRAY)
+	if err != nil {
+		return nil, err
+	}
+	lst, _ := val.([]mpack.Object)
+	return lst, nil
+}
+
+func expect_string(obj *mpack.Object) (string, error) {
+	if obj == nil {
+		return "", errors.New("missing string field")
+	}
+	val, err := obj.Expect(mpack.E_STRING)
+	if err != nil {
+		return "", err
+	}
+	str, ok := val.(string)
+	if !ok {
+		return "", errors.New("expected a string but got nil")
+	}
+	return str, nil
+}
+
+func get_dict(obj *mpack.Object) (map[string]*mpack.Object, error) {
+	ents, err := expect_map(obj)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]*mpack.Object, len(ents))
+
+	for i := range ents {
+		key, err := expect_string(&ents[i].Key)
+		if err != nil {
+			return nil, err
+		}
+		ret[key] = &ents[i].Value
+	}
+
+	return ret, nil
+}
+
+func get_function(obj *mpack.Object) (fn function, err error) {
+	dict, err := get_dict(obj)
+	if err != nil {
+		return
+	}
+	if fn.name, err = expect_string(dict["name"]); err != nil {
+		return
+	}
+	if fn.ret, err = expect_string(dict["return_type"]); err != nil {
+		return
+	}
+
+	if dep, ok := dict["deprecated_since"]; ok && dep.Mtype != mpack.T_NIL {
+		fn.deprecated = true
+	}
+
+	params, ok := dict["parameters"]
+	if !ok {
+		return fn, fmt.Errorf("function %s has no parameter list", fn.name)
+	}
+	lst, err := expect_array(params)
+	if err != nil {
+		return
+	}
+	for i := range lst {
+		var p param
+		if lst[i].Len() != 2 {
+			return fn, fmt.Errorf("function %s has a malformed parameter", fn.name)
+		}
+		if p.ntype, err = expect_string(lst[i].Index(0)); err != nil {
+			return
+		}
+		if p.name, err = expect_string(lst[i].Index(1)); err != nil {
+			return
+		}
+		fn.params = append(fn.params, p)
+	}
+
+	return fn, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"tag_highlight/mpack"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	dump, err := ioutil.ReadFile("testdata/api_info.mpack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := mpack.Decode_Bytes(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := generate(info, "testdata/api_info.mpack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := string(code)
+
+	for _, want := range []string{
+		"// Code generated by gen_api from testdata/api_info.mpack; DO NOT EDIT.\n// Neovim v0.9.5, api level 11.\n",
+
+		/* Scalars, and a list of strings. */
+		"func (c *Client) Nvim_buf_get_lines(buffer int, start int64, end int64, strict_indexing bool) ([]string, error) {\n" +
+			"\treturn strlist_call(c.fd, \"nvim_buf_get_lines\", \"d,l,l,B\", buffer, start, end, strict_indexing)\n}",
+
+		/* The ext types (Buffer, Window, Tabpage) are plain ints both ways. */
+		"func (c *Client) Nvim_win_get_tabpage(window int) (int, error) {\n" +
+			"\treturn int_call(c.fd, \"nvim_win_get_tabpage\", \"d\", window)\n}",
+		"func (c *Client) Nvim_get_current_buf() (int, error) {",
+
+		/* Dictionaries are passed as generic values. */
+		"func (c *Client) Nvim_buf_set_extmark(buffer int, ns_id int64, line int64, col int64, opts map[string]interface{}) (int64, error) {",
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("generated code is missing\n%s\n\ngot:\n%s", want, src)
+		}
+	}
+
+	if strings.Contains(src, "Nvim_command_output") {
+		t.Error("a deprecated function was generated")
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"tag_highlight/mpack"
+	"tag_highlight/util"
+)
+
+// A Request_Handler answers one rpc method. It receives the (array) parameter
+// object exactly as neovim sent it. A non-nil error is sent back to neovim as
+// the error field of the response, and the result is otherwise encoded with
+// mpack.Encode_Value.
+type Request_Handler func(args *mpack.Object) (interface{}, error)
+
+var (
+	handlers       = make(map[string]Request_Handler, 16)
+	handlers_mutex sync.RWMutex
+)
+
+//========================================================================================
+
+// Register installs fn as the handler for requests named method, replacing any
+// handler previously registered under that name.
+func Register(method string, fn Request_Handler) {
+	handlers_mutex.Lock()
+	defer handlers_mutex.Unlock()
+	handlers[method] = fn
+}
+
+func find_handler(method string) Request_Handler {
+	handlers_mutex.RLock()
+	defer handlers_mutex.RUnlock()
+	return handlers[method]
+}
+
+//========================================================================================
+
+/*
+ * Run in its own goroutine for every request neovim sends, so that a handler is
+ * free to make api calls of its own over the same connection. Neovim blocks
+ * until it gets an answer, so a response is sent no matter what happens,
+ * including when the handler panics.
+ */
+func (conn *rpc_conn) handle_request(obj *mpack.Object) {
+	if obj.Len() != 4 {
+		util.Warn("Ignoring malformed request from neovim.\n")
+		return
+	}
+	var (
+		id     = uint32(obj.Index(1).Get_Int64())
+		method = obj.Index(2).Get_String()
+		args   = obj.Index(3)
+		result interface{}
+		err    error
+	)
+
+	defer func() {
+		if r := recover(); r != nil {
+			util.Warn("Request '%s' panicked: %v\n", method, r)
+			conn.send_response(id, fmt.Errorf("%v", r), nil)
+		}
+	}()
+
+	if fn := find_handler(method); fn == nil {
+		err = fmt.Errorf("No handler registered for method '%s'", method)
+	} else {
+		result, err = fn(args)
+	}
+	if err != nil {
+		util.Warn("Request '%s' failed: %s\n", method, err)
+	}
+
+	conn.send_response(id, err, result)
+}
+
+func (conn *rpc_conn) send_response(id uint32, err error, result interface{}) {
+	if e := conn.write(true, encode_response(id, err, result)); e != nil {
+		util.Warn("Failed to send response: %s\n", e)
+	}
+}
+
+func encode_response(id uint32, err error, result interface{}) *mpack.Object {
+	var (
+		pack = mpack.Make_New(4, true)
+		cur  = pack.Index(0)
+	)
+
+	pack.Encode_Integer(&cur, MES_RESPONSE)
+	cur = pack.Index(1)
+	pack.Encode_Integer(&cur, int64(id))
+
+	cur = pack.Index(2)
+	if err != nil {
+		pack.Encode_String(&cur, []byte(err.Error()))
+		cur = pack.Index(3)
+		pack.Encode_Nil(&cur)
+	} else {
+		pack.Encode_Nil(&cur)
+		cur = pack.Index(3)
+		pack.Encode_Value(&cur, result)
+	}
+
+	return pack
+}
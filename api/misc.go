@@ -11,3 +11,19 @@ func (list *Atomic_list) Nvim_command(cmd []byte) {
 
 	list.Calls = append(list.Calls, call)
 }
+
+func (list *Atomic_list) Nvim_buf_add_highlight(bufnum int, ns_id int64, group []byte, line, col_start, col_end int) {
+	call := Atomic_call{}
+	call.Args = []interface{}{[]byte("nvim_buf_add_highlight"), bufnum, ns_id, group, line, col_start, col_end}
+	call.Fmt = "c[d,l,c,d,d,d]"
+
+	list.Calls = append(list.Calls, call)
+}
+
+func (list *Atomic_list) Nvim_buf_clear_namespace(bufnum int, ns_id int64, line_start, line_end int) {
+	call := Atomic_call{}
+	call.Args = []interface{}{[]byte("nvim_buf_clear_namespace"), bufnum, ns_id, line_start, line_end}
+	call.Fmt = "c[d,l,d,d]"
+
+	list.Calls = append(list.Calls, call)
+}
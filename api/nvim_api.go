@@ -1,18 +1,30 @@
 package api
 
+/*
+ * The typed wrappers in nvim_api_gen.go are generated from api_info.mpack, the
+ * output of `nvim --api-info`. The dump checked in was not taken from a real
+ * nvim but put together by hand from the 0.9.5 api function list, so it covers
+ * only the functions and types listed there. To refresh it:
+ *
+ *     nvim --api-info > api_info.mpack && go generate
+ */
+//go:generate go run tag_highlight/api/gen_api -i api_info.mpack -o nvim_api_gen.go
+
 import (
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
-	"strings"
-	"sync"
-	"syscall"
 	"tag_highlight/mpack"
 	"tag_highlight/util"
 )
 
+// A Client is a handle on one connection to neovim. The methods generated from
+// the api metadata (see nvim_api_gen.go) all hang off of it.
+type Client struct {
+	fd int
+}
+
 type Atomic_call struct {
 	Fmt  string
 	Args []interface{}
@@ -35,197 +47,268 @@ const ( // Neovim mpack message types
 )
 
 var (
-	Sockfd          int
-	write_log       bool = false
-	io_count        int  = 0
-	sok_count       int  = 0
-	main_nvim_mutex sync.Mutex
-	decode_mutexes  map[int]*sync.Mutex
+	Sockfd int
 )
 
 //========================================================================================
+// Errors
+//========================================================================================
+
+const ( // Error types neovim reports in the error field of a response
+	NVIM_EXCEPTION  = 0
+	NVIM_VALIDATION = 1
+)
+
+// An NvimError is an error reported by neovim itself in response to a request,
+// as opposed to a failure to talk to neovim at all.
+type NvimError struct {
+	Type    int64
+	Message string
+}
 
-func Decode_Nvim_Stream(fd int, expect_mes_type int64) *mpack.Object {
-	if fd == 1 {
-		fd = 0
-	}
-	if decode_mutexes == nil {
-		decode_mutexes = make(map[int]*sync.Mutex, 32)
-	}
-	mut := decode_mutexes[fd]
-	if mut == nil {
-		var tmp sync.Mutex
-		decode_mutexes[fd] = &tmp
-		mut = &tmp
-	}
-	mut.Lock()
-	defer mut.Unlock()
-
-	ret := mpack.Decode_Stream(fd)
-
-	if ret.Mtype != mpack.T_ARRAY {
-		log.Panic("For some reason neovim did not return an array.")
-	}
-	log_nvim_obj(ret, nil)
-
-	ret_type := (ret.Index(0).Data.(int64))
-	if expect_mes_type != MES_ANY && expect_mes_type != ret_type {
-		switch ret_type {
-		case MES_REQUEST:
-			panic("This application cannot handle requests and neovim just sent one.")
-		case MES_RESPONSE:
-			// Eprintf("Got unexpected response.\n")
-			log_nvim_obj(ret, nil)
-			return nil
-		case MES_NOTIFICATION:
-			panic("Not ready to accept notifications.")
-		default:
-			panic("Invalid neovim response.")
+func (e *NvimError) Error() string {
+	switch e.Type {
+	case NVIM_EXCEPTION:
+		return "Neovim exception: " + e.Message
+	case NVIM_VALIDATION:
+		return "Neovim validation error: " + e.Message
+	default:
+		return fmt.Sprintf("Neovim error (type %d): %s", e.Type, e.Message)
+	}
+}
+
+/*
+ * Neovim itself always sends errors as [type, message], but anything else
+ * speaking msgpack-rpc at us may well just send a string.
+ */
+func new_nvim_error(obj *mpack.Object) error {
+	switch obj.Mtype {
+	case mpack.T_ARRAY:
+		if obj.Len() == 2 && obj.Index(1).Mtype == mpack.T_STRING {
+			return &NvimError{obj.Index(0).Get_Int64(), obj.Index(1).Get_String()}
 		}
+	case mpack.T_STRING:
+		return &NvimError{NVIM_EXCEPTION, obj.Get_String()}
 	}
 
-	return ret
+	return &NvimError{NVIM_EXCEPTION, fmt.Sprintf("<unrecognized error of type %s>", obj.TypeRepr())}
+}
+
+func type_error(fn string, got interface{}, expected string) error {
+	return fmt.Errorf("%s: expected %s but got %T", fn, expected, got)
 }
 
 //========================================================================================
 // Main neovim api wrappers
 //========================================================================================
 
-func _do_call(log bool, fd, expect int, fn, format string, a []interface{}) interface{} {
-	main_nvim_mutex.Lock()
-	defer main_nvim_mutex.Unlock()
-	write_log = log
+func _do_call(log bool, fd, expect int, fn, format string, a []interface{}) (interface{}, error) {
+	conn, err := get_conn(fd)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := conn.request(log, []byte(fn), format, a...)
+	if err != nil {
+		return nil, err
+	}
+	return ret.Expect(expect)
+}
 
-	write_api(&fd, []byte(fn), format, a...)
-	ret := Decode_Nvim_Stream(fd, MES_RESPONSE)
-	return ret.Index(3).Expect(expect)
+func New_Client(fd int) *Client {
+	return &Client{fd}
 }
 
-func generic_call(fd, expect int, fn, format string, a ...interface{}) interface{} {
+func generic_call(fd, expect int, fn, format string, a ...interface{}) (interface{}, error) {
 	return _do_call(true, fd, expect, fn, format, a)
 }
 
-func nolog_call(fd, expect int, fn, format string, a ...interface{}) interface{} {
+func nolog_call(fd, expect int, fn, format string, a ...interface{}) (interface{}, error) {
 	return _do_call(false, fd, expect, fn, format, a)
 }
 
+func object_call(fd int, fn, format string, a ...interface{}) (*mpack.Object, error) {
+	conn, err := get_conn(fd)
+	if err != nil {
+		return nil, err
+	}
+	return conn.request(true, []byte(fn), format, a...)
+}
+
 func verify_only_call(fd int, fn, format string, a ...interface{}) error {
-	main_nvim_mutex.Lock()
-	defer main_nvim_mutex.Unlock()
+	_, err := object_call(fd, fn, format, a...)
+	return err
+}
 
-	write_api(&fd, []byte(fn), format, a...)
-	ret := Decode_Nvim_Stream(fd, MES_RESPONSE)
+//----------------------------------------------------------------------------------------
+// Typed calls
 
-	if ret.Index(2).Mtype == mpack.T_ARRAY {
-		return errors.New(
-			ret.Index(2).Index(1).Expect(mpack.E_STRING).(string))
+func int64_call(fd int, fn, format string, a ...interface{}) (int64, error) {
+	ret, err := generic_call(fd, mpack.T_NUM, fn, format, a...)
+	if err != nil {
+		return 0, err
 	}
+	if val, ok := ret.(int64); ok {
+		return val, nil
+	}
+	return 0, type_error(fn, ret, "an integer")
+}
 
-	return nil
+func int_call(fd int, fn, format string, a ...interface{}) (int, error) {
+	ret, err := int64_call(fd, fn, format, a...)
+	return int(ret), err
+}
+
+func bool_call(fd int, fn, format string, a ...interface{}) (bool, error) {
+	ret, err := generic_call(fd, mpack.T_BOOL, fn, format, a...)
+	if err != nil {
+		return false, err
+	}
+	if val, ok := ret.(bool); ok {
+		return val, nil
+	}
+	return false, type_error(fn, ret, "a boolean")
+}
+
+func float_call(fd int, fn, format string, a ...interface{}) (float64, error) {
+	ret, err := generic_call(fd, mpack.T_FLOAT, fn, format, a...)
+	if err != nil {
+		return 0, err
+	}
+	if val, ok := ret.(float64); ok {
+		return val, nil
+	}
+	return 0, type_error(fn, ret, "a float")
+}
+
+func string_call(fd int, fn, format string, a ...interface{}) (string, error) {
+	ret, err := generic_call(fd, mpack.E_STRING, fn, format, a...)
+	if err != nil {
+		return "", err
+	}
+	if val, ok := ret.(string); ok || ret == nil {
+		return val, nil
+	}
+	return "", type_error(fn, ret, "a string")
+}
+
+func strlist_call(fd int, fn, format string, a ...interface{}) ([]string, error) {
+	ret, err := generic_call(fd, mpack.E_STRLIST, fn, format, a...)
+	if err != nil {
+		return nil, err
+	}
+	if val, ok := ret.([]string); ok || ret == nil {
+		return val, nil
+	}
+	return nil, type_error(fn, ret, "a list of strings")
+}
+
+func intlist_call(fd int, fn, format string, a ...interface{}) ([]int, error) {
+	ret, err := generic_call(fd, mpack.E_INTLIST, fn, format, a...)
+	if err != nil {
+		return nil, err
+	}
+	if val, ok := ret.([]int); ok || ret == nil {
+		return val, nil
+	}
+	return nil, type_error(fn, ret, "a list of integers")
 }
 
 //----------------------------------------------------------------------------------------
 // Message writing
 
-func _nvim_write(fd, w_type int, mes []byte) {
-	main_nvim_mutex.Lock()
-	defer main_nvim_mutex.Unlock()
-	check_def_fd(&fd)
-	var fn []byte
+func _nvim_write(fd, w_type int, mes []byte) error {
+	var fn string
 
 	switch w_type {
 	case NW_STANDARD:
-		fn = []byte("nvim_out_write")
+		fn = "nvim_out_write"
 	case NW_ERROR:
-		fn = []byte("nvim_err_write")
+		fn = "nvim_err_write"
 	case NW_ERROR_LN:
-		fn = []byte("nvim_err_writeln")
+		fn = "nvim_err_writeln"
 	default:
-		panic("Should not be reachable...")
+		return fmt.Errorf("Invalid write type %d", w_type)
 	}
 
-	pack := encode_fmt_api(fd, fn, "c", mes)
-	write_pack(fd, pack)
-	Decode_Nvim_Stream(fd, MES_RESPONSE)
+	return verify_only_call(fd, fn, "c", mes)
 }
 
-func Nvim_printf(fd, w_type int, format string, a ...interface{}) {
+func Nvim_printf(fd, w_type int, format string, a ...interface{}) error {
 	str := fmt.Sprintf(format, a...)
-	_nvim_write(fd, w_type, []byte(str))
+	return _nvim_write(fd, w_type, []byte(str))
 }
 
 func Echo(format string, a ...interface{}) {
-	Nvim_printf(0, NW_STANDARD, format+"\n", a...)
+	if err := Nvim_printf(0, NW_STANDARD, format+"\n", a...); err != nil {
+		util.Eprintf("Failed to echo message: %s\n", err)
+	}
 }
 
 //----------------------------------------------------------------------------------------
 // Buffer functions
 
-func Nvim_list_bufs(fd int) []int {
-	fn := "nvim_list_bufs"
-	return generic_call(fd, mpack.E_INTLIST, fn, "").([]int)
+func Nvim_list_bufs(fd int) ([]int, error) {
+	return New_Client(fd).Nvim_list_bufs()
 }
 
-func Nvim_get_current_buf(fd int) int {
-	fn := "nvim_get_current_buf"
-	return int(generic_call(fd, mpack.T_NUM, fn, "").(int64))
+func Nvim_get_current_buf(fd int) (int, error) {
+	return New_Client(fd).Nvim_get_current_buf()
 }
 
-func Nvim_buf_line_count(fd, bufnum int) int {
-	fn := "nvim_buf_line_count"
-	return int(generic_call(fd, mpack.T_NUM, fn, "d", bufnum).(int64))
+func Nvim_buf_line_count(fd, bufnum int) (int, error) {
+	ret, err := New_Client(fd).Nvim_buf_line_count(bufnum)
+	return int(ret), err
 }
 
-func Nvim_buf_get_lines(fd, bufnum, start, end int) []string {
+func Nvim_buf_get_lines(fd, bufnum, start, end int) ([]string, error) {
 	fn := "nvim_buf_get_lines"
-	ret := nolog_call(
-		fd, mpack.E_STRLIST, fn, "d,d,d,B", bufnum, start, end, false).([]string)
-	line := strings.Repeat("*", 120) + "\n"
-	line += line
+	ret, err := nolog_call(fd, mpack.E_STRLIST, fn, "d,d,d,B", bufnum, start, end, false)
+	if err != nil {
+		return nil, err
+	}
+	lines, ok := ret.([]string)
+	if !ok && ret != nil {
+		return nil, type_error(fn, ret, "a list of strings")
+	}
 
-	// util.Logfiles["main"].WriteString(line + "INITIAL BUFFER UPDATE\n" + line + strings.Join(ret, "\n") + "\n" + line + "END UPDATE\n" + line)
-	// util.Logfiles["main"].WriteString(line + strings.Join(ret, "\n") + "\n" + line)
-	return ret
+	return lines, nil
 }
 
-func Nvim_buf_get_option(fd, bufnum int, optname []byte, expect int) interface{} {
+func Nvim_buf_get_option(fd, bufnum int, optname []byte, expect int) (interface{}, error) {
 	fn := "nvim_buf_get_option"
 	return generic_call(fd, expect, fn, "d,c", bufnum, optname)
 }
 
-func Nvim_buf_get_name(fd, bufnum int) string {
-	fn := "nvim_buf_get_name"
-	fname := generic_call(fd, mpack.E_STRING, fn, "d", bufnum).(string)
-
-	ret, e := filepath.Abs(fname)
-	if e != nil {
-		panic(e)
+func Nvim_buf_get_name(fd, bufnum int) (string, error) {
+	fname, err := string_call(fd, "nvim_buf_get_name", "d", bufnum)
+	if err != nil {
+		return "", err
 	}
-	return ret
+	return filepath.Abs(fname)
 }
 
-func Nvim_buf_get_changedtick(fd, bufnum int) int {
-	fn := "nvim_buf_get_changedtick"
-	return int(generic_call(fd, mpack.T_NUM, fn, "d", bufnum).(int64))
+func Nvim_buf_get_changedtick(fd, bufnum int) (int, error) {
+	ret, err := New_Client(fd).Nvim_buf_get_changedtick(bufnum)
+	return int(ret), err
 }
 
 //----------------------------------------------------------------------------------------
 // Vimscript commands and functions
 
-func Nvim_command(fd int, cmd []byte) {
+func Nvim_command(fd int, cmd []byte) error {
 	fn := "nvim_command"
-	e := verify_only_call(fd, fn, "c", cmd)
-	if e != nil {
-		log.Panicf("Nvim command failed with message '%s'", e)
+	if err := verify_only_call(fd, fn, "c", cmd); err != nil {
+		return fmt.Errorf("Nvim command '%s' failed: %s", cmd, err)
 	}
+	return nil
 }
 
-func Nvim_command_output(fd int, cmd []byte, expect int) interface{} {
+func Nvim_command_output(fd int, cmd []byte, expect int) (interface{}, error) {
 	fn := "nvim_command_output"
 	return generic_call(fd, expect, fn, "c", cmd)
 }
 
-func Nvim_call_function(fd int, function []byte, expect int) interface{} {
+func Nvim_call_function(fd int, function []byte, expect int) (interface{}, error) {
 	fn := "nvim_call_function"
 	return generic_call(fd, expect, fn, "c,[]", function)
 }
@@ -233,22 +316,37 @@ func Nvim_call_function(fd int, function []byte, expect int) interface{} {
 //----------------------------------------------------------------------------------------
 // Vim variables
 
-func Nvim_get_var(fd int, varname []byte, expect int) interface{} {
+func Nvim_get_var(fd int, varname []byte, expect int) (interface{}, error) {
 	fn := "nvim_get_var"
 	return generic_call(fd, expect, fn, "c", varname)
 }
 
+// Nvim_get_var_into decodes the value of a global variable into v, which must
+// be a pointer (see mpack.Unmarshal).
+func Nvim_get_var_into(fd int, varname []byte, v interface{}) error {
+	obj, err := object_call(fd, "nvim_get_var", "c", varname)
+	if err != nil {
+		return err
+	}
+	if err = obj.Unmarshal(v); err != nil {
+		return fmt.Errorf("Bad value for variable '%s': %s", varname, err)
+	}
+	return nil
+}
+
 //----------------------------------------------------------------------------------------
 // Misc
 
-func Nvim_buf_attach(fd, bufnum int) {
-	main_nvim_mutex.Lock()
-	defer main_nvim_mutex.Unlock()
+func Nvim_buf_attach(fd, bufnum int) error {
 	fn := "nvim_buf_attach"
+	conn, err := get_conn(fd)
+	if err != nil {
+		return err
+	}
 
 	// We don't wait for a response here
-	// write_api(&fd, []byte(fn), "d,B,[]", bufnum, true)
-	write_api(&fd, []byte(fn), "d,B,[]", bufnum, false)
+	_, err = conn.send_request(true, []byte(fn), "d,B,[]", bufnum, false)
+	return err
 }
 
 // func Nvim_call_atomic(fd int, calls []Atomic_call) error {
@@ -288,7 +386,7 @@ func Nvim_buf_attach(fd, bufnum int) {
 
 func Nvim_call_atomic(fd int, call_list *Atomic_list) error {
 	fn := "nvim_call_atomic"
-	fmt := STD_API_FMT + "[:"
+	format := STD_API_FMT + "[:"
 	calls := call_list.Calls
 	// args := make([]interface{}, 0, 256)
 
@@ -296,28 +394,48 @@ func Nvim_call_atomic(fd int, call_list *Atomic_list) error {
 	args := make([][]interface{}, 0, 128)
 
 	if len(calls) > 0 {
-		fmt += "[ @[" + calls[0].Fmt + "],"
+		format += "[ @[" + calls[0].Fmt + "],"
 		args = append(args, calls[0].Args)
 
 		for i := 1; i < len(calls); i++ {
-			fmt += "[*" + calls[i].Fmt + "],"
+			format += "[*" + calls[i].Fmt + "],"
 			args = append(args, calls[i].Args)
 		}
 
-		fmt += " ]:]"
+		format += " ]:]"
 	}
 
-	main_nvim_mutex.Lock()
-	defer main_nvim_mutex.Unlock()
-
-	pack := mpack.Encode_fmt(uint(len(calls)), fmt, MES_REQUEST, get_count(fd, true), []byte(fn), &args)
+	conn, err := get_conn(fd)
+	if err != nil {
+		return err
+	}
+	id, ch, err := conn.new_call(true)
+	if err != nil {
+		return err
+	}
+	pack, err := mpack.Encode_fmt(uint(len(calls)), format, MES_REQUEST, int(id), []byte(fn), &args)
+	if err == nil {
+		err = conn.write(true, pack)
+	}
+	if err != nil {
+		conn.cancel_call(id)
+		return err
+	}
 
-	check_def_fd(&fd)
-	write_pack(fd, pack)
+	result, err := conn.wait_response(ch)
+	if err != nil {
+		return err
+	}
 
-	result := Decode_Nvim_Stream(fd, MES_RESPONSE)
-	if result.Index(2).Mtype != mpack.T_NIL {
-		return errors.New("ERROR ERROR")
+	/* The result is [results, error]. If one of the calls failed, error is
+	 * [index, type, message] and nothing after that call was run. */
+	if result.Len() == 2 && result.Index(1).Mtype == mpack.T_ARRAY {
+		e := result.Index(1)
+		if e.Len() == 3 {
+			return fmt.Errorf("Atomic call %d failed: %w", e.Index(0).Get_Int(),
+				&NvimError{e.Index(1).Get_Int64(), e.Index(2).Get_String()})
+		}
+		return errors.New("Atomic call failed")
 	}
 
 	return nil
@@ -333,48 +451,14 @@ func check_def_fd(fd *int) {
 	}
 }
 
-func write_api(fd *int, fn []byte, format string, a ...interface{}) {
-	check_def_fd(fd)
-	pack := encode_fmt_api(*fd, fn, format, a...)
-	write_pack(*fd, pack)
-}
-
-func write_pack(fd int, pack *mpack.Object) {
-	if fd == 0 {
-		s := fmt.Sprintf("Cannot write to stdin!!! -> %d", fd)
-		panic(s)
-	}
-	fmt.Fprintf(util.Logfiles["nvim"], "Writing request %d or %d.\n", io_count, sok_count)
-	log_nvim_obj(pack, util.Logfiles["nvim"])
-	syscall.Write(fd, pack.GetPack())
-}
-
-func get_count(fd int, inc bool) int {
-	var ret int
-	var cnt *int
-
-	if fd == 1 {
-		ret = io_count
-		cnt = &io_count
-	} else {
-		ret = sok_count
-		cnt = &sok_count
-	}
-	if inc {
-		*cnt++
-	}
-
-	return ret
-}
-
-func encode_fmt_api(fd int, fn []byte, format string, a ...interface{}) *mpack.Object {
+func encode_fmt_api(id uint32, fn []byte, format string, a ...interface{}) (*mpack.Object, error) {
 	b := make([]interface{}, 0, len(a)+3)
-	b = append(b, MES_REQUEST, get_count(fd, true), fn)
+	b = append(b, MES_REQUEST, int(id), fn)
 	b = append(b, a...)
 	return mpack.Encode_fmt(0, STD_API_FMT+"["+format+"]", b...)
 }
 
-func Nvim_get_var_fmt(fd, expect int, format string, a ...interface{}) interface{} {
+func Nvim_get_var_fmt(fd, expect int, format string, a ...interface{}) (interface{}, error) {
 	s := []byte(fmt.Sprintf(format, a...))
 	return Nvim_get_var(fd, s, expect)
 }
@@ -382,7 +466,7 @@ func Nvim_get_var_fmt(fd, expect int, format string, a ...interface{}) interface
 func log_nvim_obj(pack *mpack.Object, file *os.File) {
 	ret_type := pack.Index(0).Get_Int()
 
-	if mpack.DEBUG && write_log {
+	if mpack.DEBUG {
 		if file == nil {
 			switch ret_type {
 			case MES_REQUEST:
@@ -0,0 +1,816 @@
+// Code generated by gen_api from api_info.mpack; DO NOT EDIT.
+// Neovim v0.9.5, api level 11.
+
+package api
+
+import "tag_highlight/mpack"
+
+// Nvim_buf_add_highlight calls nvim_buf_add_highlight(Buffer buffer, Integer ns_id, String hl_group, Integer line, Integer col_start, Integer col_end) -> Integer.
+func (c *Client) Nvim_buf_add_highlight(buffer int, ns_id int64, hl_group string, line int64, col_start int64, col_end int64) (int64, error) {
+	return int64_call(c.fd, "nvim_buf_add_highlight", "d,l,s,l,l,l", buffer, ns_id, hl_group, line, col_start, col_end)
+}
+
+// Nvim_buf_attach calls nvim_buf_attach(Buffer buffer, Boolean send_buffer, Dictionary opts) -> Boolean.
+func (c *Client) Nvim_buf_attach(buffer int, send_buffer bool, opts map[string]interface{}) (bool, error) {
+	return bool_call(c.fd, "nvim_buf_attach", "d,B,v", buffer, send_buffer, opts)
+}
+
+// Nvim_buf_clear_namespace calls nvim_buf_clear_namespace(Buffer buffer, Integer ns_id, Integer line_start, Integer line_end) -> void.
+func (c *Client) Nvim_buf_clear_namespace(buffer int, ns_id int64, line_start int64, line_end int64) error {
+	return verify_only_call(c.fd, "nvim_buf_clear_namespace", "d,l,l,l", buffer, ns_id, line_start, line_end)
+}
+
+// Nvim_buf_create_user_command calls nvim_buf_create_user_command(Buffer buffer, String name, Object command, Dictionary opts) -> void.
+func (c *Client) Nvim_buf_create_user_command(buffer int, name string, command interface{}, opts map[string]interface{}) error {
+	return verify_only_call(c.fd, "nvim_buf_create_user_command", "d,s,v,v", buffer, name, command, opts)
+}
+
+// Nvim_buf_del_extmark calls nvim_buf_del_extmark(Buffer buffer, Integer ns_id, Integer id) -> Boolean.
+func (c *Client) Nvim_buf_del_extmark(buffer int, ns_id int64, id int64) (bool, error) {
+	return bool_call(c.fd, "nvim_buf_del_extmark", "d,l,l", buffer, ns_id, id)
+}
+
+// Nvim_buf_del_keymap calls nvim_buf_del_keymap(Buffer buffer, String mode, String lhs) -> void.
+func (c *Client) Nvim_buf_del_keymap(buffer int, mode string, lhs string) error {
+	return verify_only_call(c.fd, "nvim_buf_del_keymap", "d,s,s", buffer, mode, lhs)
+}
+
+// Nvim_buf_del_mark calls nvim_buf_del_mark(Buffer buffer, String name) -> Boolean.
+func (c *Client) Nvim_buf_del_mark(buffer int, name string) (bool, error) {
+	return bool_call(c.fd, "nvim_buf_del_mark", "d,s", buffer, name)
+}
+
+// Nvim_buf_del_user_command calls nvim_buf_del_user_command(Buffer buffer, String name) -> void.
+func (c *Client) Nvim_buf_del_user_command(buffer int, name string) error {
+	return verify_only_call(c.fd, "nvim_buf_del_user_command", "d,s", buffer, name)
+}
+
+// Nvim_buf_del_var calls nvim_buf_del_var(Buffer buffer, String name) -> void.
+func (c *Client) Nvim_buf_del_var(buffer int, name string) error {
+	return verify_only_call(c.fd, "nvim_buf_del_var", "d,s", buffer, name)
+}
+
+// Nvim_buf_delete calls nvim_buf_delete(Buffer buffer, Dictionary opts) -> void.
+func (c *Client) Nvim_buf_delete(buffer int, opts map[string]interface{}) error {
+	return verify_only_call(c.fd, "nvim_buf_delete", "d,v", buffer, opts)
+}
+
+// Nvim_buf_detach calls nvim_buf_detach(Buffer buffer) -> Boolean.
+func (c *Client) Nvim_buf_detach(buffer int) (bool, error) {
+	return bool_call(c.fd, "nvim_buf_detach", "d", buffer)
+}
+
+// Nvim_buf_get_changedtick calls nvim_buf_get_changedtick(Buffer buffer) -> Integer.
+func (c *Client) Nvim_buf_get_changedtick(buffer int) (int64, error) {
+	return int64_call(c.fd, "nvim_buf_get_changedtick", "d", buffer)
+}
+
+// Nvim_buf_get_commands calls nvim_buf_get_commands(Buffer buffer, Dictionary opts) -> Dictionary.
+func (c *Client) Nvim_buf_get_commands(buffer int, opts map[string]interface{}) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_buf_get_commands", "d,v", buffer, opts)
+}
+
+// Nvim_buf_get_extmark_by_id calls nvim_buf_get_extmark_by_id(Buffer buffer, Integer ns_id, Integer id, Dictionary opts) -> ArrayOf(Integer).
+func (c *Client) Nvim_buf_get_extmark_by_id(buffer int, ns_id int64, id int64, opts map[string]interface{}) ([]int, error) {
+	return intlist_call(c.fd, "nvim_buf_get_extmark_by_id", "d,l,l,v", buffer, ns_id, id, opts)
+}
+
+// Nvim_buf_get_extmarks calls nvim_buf_get_extmarks(Buffer buffer, Integer ns_id, Object start, Object end, Dictionary opts) -> Array.
+func (c *Client) Nvim_buf_get_extmarks(buffer int, ns_id int64, start interface{}, end interface{}, opts map[string]interface{}) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_buf_get_extmarks", "d,l,v,v,v", buffer, ns_id, start, end, opts)
+}
+
+// Nvim_buf_get_keymap calls nvim_buf_get_keymap(Buffer buffer, String mode) -> ArrayOf(Dictionary).
+func (c *Client) Nvim_buf_get_keymap(buffer int, mode string) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_buf_get_keymap", "d,s", buffer, mode)
+}
+
+// Nvim_buf_get_lines calls nvim_buf_get_lines(Buffer buffer, Integer start, Integer end, Boolean strict_indexing) -> ArrayOf(String).
+func (c *Client) Nvim_buf_get_lines(buffer int, start int64, end int64, strict_indexing bool) ([]string, error) {
+	return strlist_call(c.fd, "nvim_buf_get_lines", "d,l,l,B", buffer, start, end, strict_indexing)
+}
+
+// Nvim_buf_get_mark calls nvim_buf_get_mark(Buffer buffer, String name) -> ArrayOf(Integer, 2).
+func (c *Client) Nvim_buf_get_mark(buffer int, name string) ([]int, error) {
+	return intlist_call(c.fd, "nvim_buf_get_mark", "d,s", buffer, name)
+}
+
+// Nvim_buf_get_name calls nvim_buf_get_name(Buffer buffer) -> String.
+func (c *Client) Nvim_buf_get_name(buffer int) (string, error) {
+	return string_call(c.fd, "nvim_buf_get_name", "d", buffer)
+}
+
+// Nvim_buf_get_offset calls nvim_buf_get_offset(Buffer buffer, Integer index) -> Integer.
+func (c *Client) Nvim_buf_get_offset(buffer int, index int64) (int64, error) {
+	return int64_call(c.fd, "nvim_buf_get_offset", "d,l", buffer, index)
+}
+
+// Nvim_buf_get_option calls nvim_buf_get_option(Buffer buffer, String name) -> Object.
+func (c *Client) Nvim_buf_get_option(buffer int, name string) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_buf_get_option", "d,s", buffer, name)
+}
+
+// Nvim_buf_get_text calls nvim_buf_get_text(Buffer buffer, Integer start_row, Integer start_col, Integer end_row, Integer end_col, Dictionary opts) -> ArrayOf(String).
+func (c *Client) Nvim_buf_get_text(buffer int, start_row int64, start_col int64, end_row int64, end_col int64, opts map[string]interface{}) ([]string, error) {
+	return strlist_call(c.fd, "nvim_buf_get_text", "d,l,l,l,l,v", buffer, start_row, start_col, end_row, end_col, opts)
+}
+
+// Nvim_buf_get_var calls nvim_buf_get_var(Buffer buffer, String name) -> Object.
+func (c *Client) Nvim_buf_get_var(buffer int, name string) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_buf_get_var", "d,s", buffer, name)
+}
+
+// Nvim_buf_is_loaded calls nvim_buf_is_loaded(Buffer buffer) -> Boolean.
+func (c *Client) Nvim_buf_is_loaded(buffer int) (bool, error) {
+	return bool_call(c.fd, "nvim_buf_is_loaded", "d", buffer)
+}
+
+// Nvim_buf_is_valid calls nvim_buf_is_valid(Buffer buffer) -> Boolean.
+func (c *Client) Nvim_buf_is_valid(buffer int) (bool, error) {
+	return bool_call(c.fd, "nvim_buf_is_valid", "d", buffer)
+}
+
+// Nvim_buf_line_count calls nvim_buf_line_count(Buffer buffer) -> Integer.
+func (c *Client) Nvim_buf_line_count(buffer int) (int64, error) {
+	return int64_call(c.fd, "nvim_buf_line_count", "d", buffer)
+}
+
+// Nvim_buf_set_extmark calls nvim_buf_set_extmark(Buffer buffer, Integer ns_id, Integer line, Integer col, Dictionary opts) -> Integer.
+func (c *Client) Nvim_buf_set_extmark(buffer int, ns_id int64, line int64, col int64, opts map[string]interface{}) (int64, error) {
+	return int64_call(c.fd, "nvim_buf_set_extmark", "d,l,l,l,v", buffer, ns_id, line, col, opts)
+}
+
+// Nvim_buf_set_keymap calls nvim_buf_set_keymap(Buffer buffer, String mode, String lhs, String rhs, Dictionary opts) -> void.
+func (c *Client) Nvim_buf_set_keymap(buffer int, mode string, lhs string, rhs string, opts map[string]interface{}) error {
+	return verify_only_call(c.fd, "nvim_buf_set_keymap", "d,s,s,s,v", buffer, mode, lhs, rhs, opts)
+}
+
+// Nvim_buf_set_lines calls nvim_buf_set_lines(Buffer buffer, Integer start, Integer end, Boolean strict_indexing, ArrayOf(String) replacement) -> void.
+func (c *Client) Nvim_buf_set_lines(buffer int, start int64, end int64, strict_indexing bool, replacement []string) error {
+	return verify_only_call(c.fd, "nvim_buf_set_lines", "d,l,l,B,v", buffer, start, end, strict_indexing, replacement)
+}
+
+// Nvim_buf_set_mark calls nvim_buf_set_mark(Buffer buffer, String name, Integer line, Integer col, Dictionary opts) -> Boolean.
+func (c *Client) Nvim_buf_set_mark(buffer int, name string, line int64, col int64, opts map[string]interface{}) (bool, error) {
+	return bool_call(c.fd, "nvim_buf_set_mark", "d,s,l,l,v", buffer, name, line, col, opts)
+}
+
+// Nvim_buf_set_name calls nvim_buf_set_name(Buffer buffer, String name) -> void.
+func (c *Client) Nvim_buf_set_name(buffer int, name string) error {
+	return verify_only_call(c.fd, "nvim_buf_set_name", "d,s", buffer, name)
+}
+
+// Nvim_buf_set_option calls nvim_buf_set_option(Buffer buffer, String name, Object value) -> void.
+func (c *Client) Nvim_buf_set_option(buffer int, name string, value interface{}) error {
+	return verify_only_call(c.fd, "nvim_buf_set_option", "d,s,v", buffer, name, value)
+}
+
+// Nvim_buf_set_text calls nvim_buf_set_text(Buffer buffer, Integer start_row, Integer start_col, Integer end_row, Integer end_col, ArrayOf(String) replacement) -> void.
+func (c *Client) Nvim_buf_set_text(buffer int, start_row int64, start_col int64, end_row int64, end_col int64, replacement []string) error {
+	return verify_only_call(c.fd, "nvim_buf_set_text", "d,l,l,l,l,v", buffer, start_row, start_col, end_row, end_col, replacement)
+}
+
+// Nvim_buf_set_var calls nvim_buf_set_var(Buffer buffer, String name, Object value) -> void.
+func (c *Client) Nvim_buf_set_var(buffer int, name string, value interface{}) error {
+	return verify_only_call(c.fd, "nvim_buf_set_var", "d,s,v", buffer, name, value)
+}
+
+// Nvim_call_atomic calls nvim_call_atomic(Array calls) -> Array.
+func (c *Client) Nvim_call_atomic(calls []interface{}) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_call_atomic", "v", calls)
+}
+
+// Nvim_call_dict_function calls nvim_call_dict_function(Object dict, String fn, Array args) -> Object.
+func (c *Client) Nvim_call_dict_function(dict interface{}, fn string, args []interface{}) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_call_dict_function", "v,s,v", dict, fn, args)
+}
+
+// Nvim_call_function calls nvim_call_function(String fn, Array args) -> Object.
+func (c *Client) Nvim_call_function(fn string, args []interface{}) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_call_function", "s,v", fn, args)
+}
+
+// Nvim_chan_send calls nvim_chan_send(Integer chan, String data) -> void.
+func (c *Client) Nvim_chan_send(chan_ int64, data string) error {
+	return verify_only_call(c.fd, "nvim_chan_send", "l,s", chan_, data)
+}
+
+// Nvim_clear_autocmds calls nvim_clear_autocmds(Dictionary opts) -> void.
+func (c *Client) Nvim_clear_autocmds(opts map[string]interface{}) error {
+	return verify_only_call(c.fd, "nvim_clear_autocmds", "v", opts)
+}
+
+// Nvim_cmd calls nvim_cmd(Dictionary cmd, Dictionary opts) -> String.
+func (c *Client) Nvim_cmd(cmd map[string]interface{}, opts map[string]interface{}) (string, error) {
+	return string_call(c.fd, "nvim_cmd", "v,v", cmd, opts)
+}
+
+// Nvim_command calls nvim_command(String command) -> void.
+func (c *Client) Nvim_command(command string) error {
+	return verify_only_call(c.fd, "nvim_command", "s", command)
+}
+
+// Nvim_create_augroup calls nvim_create_augroup(String name, Dictionary opts) -> Integer.
+func (c *Client) Nvim_create_augroup(name string, opts map[string]interface{}) (int64, error) {
+	return int64_call(c.fd, "nvim_create_augroup", "s,v", name, opts)
+}
+
+// Nvim_create_autocmd calls nvim_create_autocmd(Object event, Dictionary opts) -> Integer.
+func (c *Client) Nvim_create_autocmd(event interface{}, opts map[string]interface{}) (int64, error) {
+	return int64_call(c.fd, "nvim_create_autocmd", "v,v", event, opts)
+}
+
+// Nvim_create_buf calls nvim_create_buf(Boolean listed, Boolean scratch) -> Buffer.
+func (c *Client) Nvim_create_buf(listed bool, scratch bool) (int, error) {
+	return int_call(c.fd, "nvim_create_buf", "B,B", listed, scratch)
+}
+
+// Nvim_create_namespace calls nvim_create_namespace(String name) -> Integer.
+func (c *Client) Nvim_create_namespace(name string) (int64, error) {
+	return int64_call(c.fd, "nvim_create_namespace", "s", name)
+}
+
+// Nvim_create_user_command calls nvim_create_user_command(String name, Object command, Dictionary opts) -> void.
+func (c *Client) Nvim_create_user_command(name string, command interface{}, opts map[string]interface{}) error {
+	return verify_only_call(c.fd, "nvim_create_user_command", "s,v,v", name, command, opts)
+}
+
+// Nvim_del_augroup_by_id calls nvim_del_augroup_by_id(Integer id) -> void.
+func (c *Client) Nvim_del_augroup_by_id(id int64) error {
+	return verify_only_call(c.fd, "nvim_del_augroup_by_id", "l", id)
+}
+
+// Nvim_del_augroup_by_name calls nvim_del_augroup_by_name(String name) -> void.
+func (c *Client) Nvim_del_augroup_by_name(name string) error {
+	return verify_only_call(c.fd, "nvim_del_augroup_by_name", "s", name)
+}
+
+// Nvim_del_autocmd calls nvim_del_autocmd(Integer id) -> void.
+func (c *Client) Nvim_del_autocmd(id int64) error {
+	return verify_only_call(c.fd, "nvim_del_autocmd", "l", id)
+}
+
+// Nvim_del_current_line calls nvim_del_current_line() -> void.
+func (c *Client) Nvim_del_current_line() error {
+	return verify_only_call(c.fd, "nvim_del_current_line", "")
+}
+
+// Nvim_del_keymap calls nvim_del_keymap(String mode, String lhs) -> void.
+func (c *Client) Nvim_del_keymap(mode string, lhs string) error {
+	return verify_only_call(c.fd, "nvim_del_keymap", "s,s", mode, lhs)
+}
+
+// Nvim_del_mark calls nvim_del_mark(String name) -> Boolean.
+func (c *Client) Nvim_del_mark(name string) (bool, error) {
+	return bool_call(c.fd, "nvim_del_mark", "s", name)
+}
+
+// Nvim_del_user_command calls nvim_del_user_command(String name) -> void.
+func (c *Client) Nvim_del_user_command(name string) error {
+	return verify_only_call(c.fd, "nvim_del_user_command", "s", name)
+}
+
+// Nvim_del_var calls nvim_del_var(String name) -> void.
+func (c *Client) Nvim_del_var(name string) error {
+	return verify_only_call(c.fd, "nvim_del_var", "s", name)
+}
+
+// Nvim_echo calls nvim_echo(Array chunks, Boolean history, Dictionary opts) -> void.
+func (c *Client) Nvim_echo(chunks []interface{}, history bool, opts map[string]interface{}) error {
+	return verify_only_call(c.fd, "nvim_echo", "v,B,v", chunks, history, opts)
+}
+
+// Nvim_err_write calls nvim_err_write(String str) -> void.
+func (c *Client) Nvim_err_write(str string) error {
+	return verify_only_call(c.fd, "nvim_err_write", "s", str)
+}
+
+// Nvim_err_writeln calls nvim_err_writeln(String str) -> void.
+func (c *Client) Nvim_err_writeln(str string) error {
+	return verify_only_call(c.fd, "nvim_err_writeln", "s", str)
+}
+
+// Nvim_eval calls nvim_eval(String expr) -> Object.
+func (c *Client) Nvim_eval(expr string) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_eval", "s", expr)
+}
+
+// Nvim_eval_statusline calls nvim_eval_statusline(String str, Dictionary opts) -> Dictionary.
+func (c *Client) Nvim_eval_statusline(str string, opts map[string]interface{}) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_eval_statusline", "s,v", str, opts)
+}
+
+// Nvim_exec2 calls nvim_exec2(String src, Dictionary opts) -> Dictionary.
+func (c *Client) Nvim_exec2(src string, opts map[string]interface{}) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_exec2", "s,v", src, opts)
+}
+
+// Nvim_exec_autocmds calls nvim_exec_autocmds(Object event, Dictionary opts) -> void.
+func (c *Client) Nvim_exec_autocmds(event interface{}, opts map[string]interface{}) error {
+	return verify_only_call(c.fd, "nvim_exec_autocmds", "v,v", event, opts)
+}
+
+// Nvim_exec_lua calls nvim_exec_lua(String code, Array args) -> Object.
+func (c *Client) Nvim_exec_lua(code string, args []interface{}) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_exec_lua", "s,v", code, args)
+}
+
+// Nvim_feedkeys calls nvim_feedkeys(String keys, String mode, Boolean escape_ks) -> void.
+func (c *Client) Nvim_feedkeys(keys string, mode string, escape_ks bool) error {
+	return verify_only_call(c.fd, "nvim_feedkeys", "s,s,B", keys, mode, escape_ks)
+}
+
+// Nvim_get_all_options_info calls nvim_get_all_options_info() -> Dictionary.
+func (c *Client) Nvim_get_all_options_info() (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_all_options_info", "")
+}
+
+// Nvim_get_api_info calls nvim_get_api_info() -> Array.
+func (c *Client) Nvim_get_api_info() (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_api_info", "")
+}
+
+// Nvim_get_autocmds calls nvim_get_autocmds(Dictionary opts) -> Array.
+func (c *Client) Nvim_get_autocmds(opts map[string]interface{}) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_autocmds", "v", opts)
+}
+
+// Nvim_get_chan_info calls nvim_get_chan_info(Integer chan) -> Dictionary.
+func (c *Client) Nvim_get_chan_info(chan_ int64) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_chan_info", "l", chan_)
+}
+
+// Nvim_get_color_by_name calls nvim_get_color_by_name(String name) -> Integer.
+func (c *Client) Nvim_get_color_by_name(name string) (int64, error) {
+	return int64_call(c.fd, "nvim_get_color_by_name", "s", name)
+}
+
+// Nvim_get_color_map calls nvim_get_color_map() -> Dictionary.
+func (c *Client) Nvim_get_color_map() (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_color_map", "")
+}
+
+// Nvim_get_commands calls nvim_get_commands(Dictionary opts) -> Dictionary.
+func (c *Client) Nvim_get_commands(opts map[string]interface{}) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_commands", "v", opts)
+}
+
+// Nvim_get_context calls nvim_get_context(Dictionary opts) -> Dictionary.
+func (c *Client) Nvim_get_context(opts map[string]interface{}) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_context", "v", opts)
+}
+
+// Nvim_get_current_buf calls nvim_get_current_buf() -> Buffer.
+func (c *Client) Nvim_get_current_buf() (int, error) {
+	return int_call(c.fd, "nvim_get_current_buf", "")
+}
+
+// Nvim_get_current_line calls nvim_get_current_line() -> String.
+func (c *Client) Nvim_get_current_line() (string, error) {
+	return string_call(c.fd, "nvim_get_current_line", "")
+}
+
+// Nvim_get_current_tabpage calls nvim_get_current_tabpage() -> Tabpage.
+func (c *Client) Nvim_get_current_tabpage() (int, error) {
+	return int_call(c.fd, "nvim_get_current_tabpage", "")
+}
+
+// Nvim_get_current_win calls nvim_get_current_win() -> Window.
+func (c *Client) Nvim_get_current_win() (int, error) {
+	return int_call(c.fd, "nvim_get_current_win", "")
+}
+
+// Nvim_get_hl calls nvim_get_hl(Integer ns_id, Dictionary opts) -> Dictionary.
+func (c *Client) Nvim_get_hl(ns_id int64, opts map[string]interface{}) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_hl", "l,v", ns_id, opts)
+}
+
+// Nvim_get_hl_id_by_name calls nvim_get_hl_id_by_name(String name) -> Integer.
+func (c *Client) Nvim_get_hl_id_by_name(name string) (int64, error) {
+	return int64_call(c.fd, "nvim_get_hl_id_by_name", "s", name)
+}
+
+// Nvim_get_keymap calls nvim_get_keymap(String mode) -> ArrayOf(Dictionary).
+func (c *Client) Nvim_get_keymap(mode string) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_keymap", "s", mode)
+}
+
+// Nvim_get_mark calls nvim_get_mark(String name, Dictionary opts) -> Array.
+func (c *Client) Nvim_get_mark(name string, opts map[string]interface{}) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_mark", "s,v", name, opts)
+}
+
+// Nvim_get_mode calls nvim_get_mode() -> Dictionary.
+func (c *Client) Nvim_get_mode() (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_mode", "")
+}
+
+// Nvim_get_namespaces calls nvim_get_namespaces() -> Dictionary.
+func (c *Client) Nvim_get_namespaces() (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_namespaces", "")
+}
+
+// Nvim_get_option calls nvim_get_option(String name) -> Object.
+func (c *Client) Nvim_get_option(name string) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_option", "s", name)
+}
+
+// Nvim_get_option_info calls nvim_get_option_info(String name) -> Dictionary.
+func (c *Client) Nvim_get_option_info(name string) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_option_info", "s", name)
+}
+
+// Nvim_get_option_value calls nvim_get_option_value(String name, Dictionary opts) -> Object.
+func (c *Client) Nvim_get_option_value(name string, opts map[string]interface{}) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_option_value", "s,v", name, opts)
+}
+
+// Nvim_get_proc calls nvim_get_proc(Integer pid) -> Object.
+func (c *Client) Nvim_get_proc(pid int64) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_proc", "l", pid)
+}
+
+// Nvim_get_proc_children calls nvim_get_proc_children(Integer pid) -> Array.
+func (c *Client) Nvim_get_proc_children(pid int64) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_proc_children", "l", pid)
+}
+
+// Nvim_get_runtime_file calls nvim_get_runtime_file(String name, Boolean all) -> ArrayOf(String).
+func (c *Client) Nvim_get_runtime_file(name string, all bool) ([]string, error) {
+	return strlist_call(c.fd, "nvim_get_runtime_file", "s,B", name, all)
+}
+
+// Nvim_get_var calls nvim_get_var(String name) -> Object.
+func (c *Client) Nvim_get_var(name string) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_var", "s", name)
+}
+
+// Nvim_get_vvar calls nvim_get_vvar(String name) -> Object.
+func (c *Client) Nvim_get_vvar(name string) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_get_vvar", "s", name)
+}
+
+// Nvim_input calls nvim_input(String keys) -> Integer.
+func (c *Client) Nvim_input(keys string) (int64, error) {
+	return int64_call(c.fd, "nvim_input", "s", keys)
+}
+
+// Nvim_input_mouse calls nvim_input_mouse(String button, String action, String modifier, Integer grid, Integer row, Integer col) -> void.
+func (c *Client) Nvim_input_mouse(button string, action string, modifier string, grid int64, row int64, col int64) error {
+	return verify_only_call(c.fd, "nvim_input_mouse", "s,s,s,l,l,l", button, action, modifier, grid, row, col)
+}
+
+// Nvim_list_bufs calls nvim_list_bufs() -> ArrayOf(Buffer).
+func (c *Client) Nvim_list_bufs() ([]int, error) {
+	return intlist_call(c.fd, "nvim_list_bufs", "")
+}
+
+// Nvim_list_chans calls nvim_list_chans() -> Array.
+func (c *Client) Nvim_list_chans() (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_list_chans", "")
+}
+
+// Nvim_list_runtime_paths calls nvim_list_runtime_paths() -> ArrayOf(String).
+func (c *Client) Nvim_list_runtime_paths() ([]string, error) {
+	return strlist_call(c.fd, "nvim_list_runtime_paths", "")
+}
+
+// Nvim_list_tabpages calls nvim_list_tabpages() -> ArrayOf(Tabpage).
+func (c *Client) Nvim_list_tabpages() ([]int, error) {
+	return intlist_call(c.fd, "nvim_list_tabpages", "")
+}
+
+// Nvim_list_uis calls nvim_list_uis() -> Array.
+func (c *Client) Nvim_list_uis() (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_list_uis", "")
+}
+
+// Nvim_list_wins calls nvim_list_wins() -> ArrayOf(Window).
+func (c *Client) Nvim_list_wins() ([]int, error) {
+	return intlist_call(c.fd, "nvim_list_wins", "")
+}
+
+// Nvim_load_context calls nvim_load_context(Dictionary dict) -> Object.
+func (c *Client) Nvim_load_context(dict map[string]interface{}) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_load_context", "v", dict)
+}
+
+// Nvim_notify calls nvim_notify(String msg, Integer log_level, Dictionary opts) -> Object.
+func (c *Client) Nvim_notify(msg string, log_level int64, opts map[string]interface{}) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_notify", "s,l,v", msg, log_level, opts)
+}
+
+// Nvim_open_term calls nvim_open_term(Buffer buffer, Dictionary opts) -> Integer.
+func (c *Client) Nvim_open_term(buffer int, opts map[string]interface{}) (int64, error) {
+	return int64_call(c.fd, "nvim_open_term", "d,v", buffer, opts)
+}
+
+// Nvim_open_win calls nvim_open_win(Buffer buffer, Boolean enter, Dictionary config) -> Window.
+func (c *Client) Nvim_open_win(buffer int, enter bool, config map[string]interface{}) (int, error) {
+	return int_call(c.fd, "nvim_open_win", "d,B,v", buffer, enter, config)
+}
+
+// Nvim_out_write calls nvim_out_write(String str) -> void.
+func (c *Client) Nvim_out_write(str string) error {
+	return verify_only_call(c.fd, "nvim_out_write", "s", str)
+}
+
+// Nvim_parse_cmd calls nvim_parse_cmd(String str, Dictionary opts) -> Dictionary.
+func (c *Client) Nvim_parse_cmd(str string, opts map[string]interface{}) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_parse_cmd", "s,v", str, opts)
+}
+
+// Nvim_parse_expression calls nvim_parse_expression(String expr, String flags, Boolean highlight) -> Dictionary.
+func (c *Client) Nvim_parse_expression(expr string, flags string, highlight bool) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_parse_expression", "s,s,B", expr, flags, highlight)
+}
+
+// Nvim_paste calls nvim_paste(String data, Boolean crlf, Integer phase) -> Boolean.
+func (c *Client) Nvim_paste(data string, crlf bool, phase int64) (bool, error) {
+	return bool_call(c.fd, "nvim_paste", "s,B,l", data, crlf, phase)
+}
+
+// Nvim_put calls nvim_put(ArrayOf(String) lines, String type, Boolean after, Boolean follow) -> void.
+func (c *Client) Nvim_put(lines []string, type_ string, after bool, follow bool) error {
+	return verify_only_call(c.fd, "nvim_put", "v,s,B,B", lines, type_, after, follow)
+}
+
+// Nvim_replace_termcodes calls nvim_replace_termcodes(String str, Boolean from_part, Boolean do_lt, Boolean special) -> String.
+func (c *Client) Nvim_replace_termcodes(str string, from_part bool, do_lt bool, special bool) (string, error) {
+	return string_call(c.fd, "nvim_replace_termcodes", "s,B,B,B", str, from_part, do_lt, special)
+}
+
+// Nvim_select_popupmenu_item calls nvim_select_popupmenu_item(Integer item, Boolean insert, Boolean finish, Dictionary opts) -> void.
+func (c *Client) Nvim_select_popupmenu_item(item int64, insert bool, finish bool, opts map[string]interface{}) error {
+	return verify_only_call(c.fd, "nvim_select_popupmenu_item", "l,B,B,v", item, insert, finish, opts)
+}
+
+// Nvim_set_client_info calls nvim_set_client_info(String name, Dictionary version, String type, Dictionary methods, Dictionary attributes) -> void.
+func (c *Client) Nvim_set_client_info(name string, version map[string]interface{}, type_ string, methods map[string]interface{}, attributes map[string]interface{}) error {
+	return verify_only_call(c.fd, "nvim_set_client_info", "s,v,s,v,v", name, version, type_, methods, attributes)
+}
+
+// Nvim_set_current_buf calls nvim_set_current_buf(Buffer buffer) -> void.
+func (c *Client) Nvim_set_current_buf(buffer int) error {
+	return verify_only_call(c.fd, "nvim_set_current_buf", "d", buffer)
+}
+
+// Nvim_set_current_dir calls nvim_set_current_dir(String dir) -> void.
+func (c *Client) Nvim_set_current_dir(dir string) error {
+	return verify_only_call(c.fd, "nvim_set_current_dir", "s", dir)
+}
+
+// Nvim_set_current_line calls nvim_set_current_line(String line) -> void.
+func (c *Client) Nvim_set_current_line(line string) error {
+	return verify_only_call(c.fd, "nvim_set_current_line", "s", line)
+}
+
+// Nvim_set_current_tabpage calls nvim_set_current_tabpage(Tabpage tabpage) -> void.
+func (c *Client) Nvim_set_current_tabpage(tabpage int) error {
+	return verify_only_call(c.fd, "nvim_set_current_tabpage", "d", tabpage)
+}
+
+// Nvim_set_current_win calls nvim_set_current_win(Window window) -> void.
+func (c *Client) Nvim_set_current_win(window int) error {
+	return verify_only_call(c.fd, "nvim_set_current_win", "d", window)
+}
+
+// Nvim_set_hl calls nvim_set_hl(Integer ns_id, String name, Dictionary val) -> void.
+func (c *Client) Nvim_set_hl(ns_id int64, name string, val map[string]interface{}) error {
+	return verify_only_call(c.fd, "nvim_set_hl", "l,s,v", ns_id, name, val)
+}
+
+// Nvim_set_hl_ns calls nvim_set_hl_ns(Integer ns_id) -> void.
+func (c *Client) Nvim_set_hl_ns(ns_id int64) error {
+	return verify_only_call(c.fd, "nvim_set_hl_ns", "l", ns_id)
+}
+
+// Nvim_set_hl_ns_fast calls nvim_set_hl_ns_fast(Integer ns_id) -> void.
+func (c *Client) Nvim_set_hl_ns_fast(ns_id int64) error {
+	return verify_only_call(c.fd, "nvim_set_hl_ns_fast", "l", ns_id)
+}
+
+// Nvim_set_keymap calls nvim_set_keymap(String mode, String lhs, String rhs, Dictionary opts) -> void.
+func (c *Client) Nvim_set_keymap(mode string, lhs string, rhs string, opts map[string]interface{}) error {
+	return verify_only_call(c.fd, "nvim_set_keymap", "s,s,s,v", mode, lhs, rhs, opts)
+}
+
+// Nvim_set_option calls nvim_set_option(String name, Object value) -> void.
+func (c *Client) Nvim_set_option(name string, value interface{}) error {
+	return verify_only_call(c.fd, "nvim_set_option", "s,v", name, value)
+}
+
+// Nvim_set_option_value calls nvim_set_option_value(String name, Object value, Dictionary opts) -> void.
+func (c *Client) Nvim_set_option_value(name string, value interface{}, opts map[string]interface{}) error {
+	return verify_only_call(c.fd, "nvim_set_option_value", "s,v,v", name, value, opts)
+}
+
+// Nvim_set_var calls nvim_set_var(String name, Object value) -> void.
+func (c *Client) Nvim_set_var(name string, value interface{}) error {
+	return verify_only_call(c.fd, "nvim_set_var", "s,v", name, value)
+}
+
+// Nvim_set_vvar calls nvim_set_vvar(String name, Object value) -> void.
+func (c *Client) Nvim_set_vvar(name string, value interface{}) error {
+	return verify_only_call(c.fd, "nvim_set_vvar", "s,v", name, value)
+}
+
+// Nvim_strwidth calls nvim_strwidth(String text) -> Integer.
+func (c *Client) Nvim_strwidth(text string) (int64, error) {
+	return int64_call(c.fd, "nvim_strwidth", "s", text)
+}
+
+// Nvim_subscribe calls nvim_subscribe(String event) -> void.
+func (c *Client) Nvim_subscribe(event string) error {
+	return verify_only_call(c.fd, "nvim_subscribe", "s", event)
+}
+
+// Nvim_tabpage_del_var calls nvim_tabpage_del_var(Tabpage tabpage, String name) -> void.
+func (c *Client) Nvim_tabpage_del_var(tabpage int, name string) error {
+	return verify_only_call(c.fd, "nvim_tabpage_del_var", "d,s", tabpage, name)
+}
+
+// Nvim_tabpage_get_number calls nvim_tabpage_get_number(Tabpage tabpage) -> Integer.
+func (c *Client) Nvim_tabpage_get_number(tabpage int) (int64, error) {
+	return int64_call(c.fd, "nvim_tabpage_get_number", "d", tabpage)
+}
+
+// Nvim_tabpage_get_var calls nvim_tabpage_get_var(Tabpage tabpage, String name) -> Object.
+func (c *Client) Nvim_tabpage_get_var(tabpage int, name string) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_tabpage_get_var", "d,s", tabpage, name)
+}
+
+// Nvim_tabpage_get_win calls nvim_tabpage_get_win(Tabpage tabpage) -> Window.
+func (c *Client) Nvim_tabpage_get_win(tabpage int) (int, error) {
+	return int_call(c.fd, "nvim_tabpage_get_win", "d", tabpage)
+}
+
+// Nvim_tabpage_is_valid calls nvim_tabpage_is_valid(Tabpage tabpage) -> Boolean.
+func (c *Client) Nvim_tabpage_is_valid(tabpage int) (bool, error) {
+	return bool_call(c.fd, "nvim_tabpage_is_valid", "d", tabpage)
+}
+
+// Nvim_tabpage_list_wins calls nvim_tabpage_list_wins(Tabpage tabpage) -> ArrayOf(Window).
+func (c *Client) Nvim_tabpage_list_wins(tabpage int) ([]int, error) {
+	return intlist_call(c.fd, "nvim_tabpage_list_wins", "d", tabpage)
+}
+
+// Nvim_tabpage_set_var calls nvim_tabpage_set_var(Tabpage tabpage, String name, Object value) -> void.
+func (c *Client) Nvim_tabpage_set_var(tabpage int, name string, value interface{}) error {
+	return verify_only_call(c.fd, "nvim_tabpage_set_var", "d,s,v", tabpage, name, value)
+}
+
+// Nvim_ui_attach calls nvim_ui_attach(Integer width, Integer height, Dictionary options) -> void.
+func (c *Client) Nvim_ui_attach(width int64, height int64, options map[string]interface{}) error {
+	return verify_only_call(c.fd, "nvim_ui_attach", "l,l,v", width, height, options)
+}
+
+// Nvim_ui_detach calls nvim_ui_detach() -> void.
+func (c *Client) Nvim_ui_detach() error {
+	return verify_only_call(c.fd, "nvim_ui_detach", "")
+}
+
+// Nvim_ui_pum_set_bounds calls nvim_ui_pum_set_bounds(Float width, Float height, Float row, Float col) -> void.
+func (c *Client) Nvim_ui_pum_set_bounds(width float64, height float64, row float64, col float64) error {
+	return verify_only_call(c.fd, "nvim_ui_pum_set_bounds", "f,f,f,f", width, height, row, col)
+}
+
+// Nvim_ui_pum_set_height calls nvim_ui_pum_set_height(Integer height) -> void.
+func (c *Client) Nvim_ui_pum_set_height(height int64) error {
+	return verify_only_call(c.fd, "nvim_ui_pum_set_height", "l", height)
+}
+
+// Nvim_ui_set_focus calls nvim_ui_set_focus(Boolean gained) -> void.
+func (c *Client) Nvim_ui_set_focus(gained bool) error {
+	return verify_only_call(c.fd, "nvim_ui_set_focus", "B", gained)
+}
+
+// Nvim_ui_set_option calls nvim_ui_set_option(String name, Object value) -> void.
+func (c *Client) Nvim_ui_set_option(name string, value interface{}) error {
+	return verify_only_call(c.fd, "nvim_ui_set_option", "s,v", name, value)
+}
+
+// Nvim_ui_try_resize calls nvim_ui_try_resize(Integer width, Integer height) -> void.
+func (c *Client) Nvim_ui_try_resize(width int64, height int64) error {
+	return verify_only_call(c.fd, "nvim_ui_try_resize", "l,l", width, height)
+}
+
+// Nvim_ui_try_resize_grid calls nvim_ui_try_resize_grid(Integer grid, Integer width, Integer height) -> void.
+func (c *Client) Nvim_ui_try_resize_grid(grid int64, width int64, height int64) error {
+	return verify_only_call(c.fd, "nvim_ui_try_resize_grid", "l,l,l", grid, width, height)
+}
+
+// Nvim_unsubscribe calls nvim_unsubscribe(String event) -> void.
+func (c *Client) Nvim_unsubscribe(event string) error {
+	return verify_only_call(c.fd, "nvim_unsubscribe", "s", event)
+}
+
+// Nvim_win_close calls nvim_win_close(Window window, Boolean force) -> void.
+func (c *Client) Nvim_win_close(window int, force bool) error {
+	return verify_only_call(c.fd, "nvim_win_close", "d,B", window, force)
+}
+
+// Nvim_win_del_var calls nvim_win_del_var(Window window, String name) -> void.
+func (c *Client) Nvim_win_del_var(window int, name string) error {
+	return verify_only_call(c.fd, "nvim_win_del_var", "d,s", window, name)
+}
+
+// Nvim_win_get_buf calls nvim_win_get_buf(Window window) -> Buffer.
+func (c *Client) Nvim_win_get_buf(window int) (int, error) {
+	return int_call(c.fd, "nvim_win_get_buf", "d", window)
+}
+
+// Nvim_win_get_config calls nvim_win_get_config(Window window) -> Dictionary.
+func (c *Client) Nvim_win_get_config(window int) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_win_get_config", "d", window)
+}
+
+// Nvim_win_get_cursor calls nvim_win_get_cursor(Window window) -> ArrayOf(Integer, 2).
+func (c *Client) Nvim_win_get_cursor(window int) ([]int, error) {
+	return intlist_call(c.fd, "nvim_win_get_cursor", "d", window)
+}
+
+// Nvim_win_get_height calls nvim_win_get_height(Window window) -> Integer.
+func (c *Client) Nvim_win_get_height(window int) (int64, error) {
+	return int64_call(c.fd, "nvim_win_get_height", "d", window)
+}
+
+// Nvim_win_get_number calls nvim_win_get_number(Window window) -> Integer.
+func (c *Client) Nvim_win_get_number(window int) (int64, error) {
+	return int64_call(c.fd, "nvim_win_get_number", "d", window)
+}
+
+// Nvim_win_get_option calls nvim_win_get_option(Window window, String name) -> Object.
+func (c *Client) Nvim_win_get_option(window int, name string) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_win_get_option", "d,s", window, name)
+}
+
+// Nvim_win_get_position calls nvim_win_get_position(Window window) -> ArrayOf(Integer, 2).
+func (c *Client) Nvim_win_get_position(window int) ([]int, error) {
+	return intlist_call(c.fd, "nvim_win_get_position", "d", window)
+}
+
+// Nvim_win_get_tabpage calls nvim_win_get_tabpage(Window window) -> Tabpage.
+func (c *Client) Nvim_win_get_tabpage(window int) (int, error) {
+	return int_call(c.fd, "nvim_win_get_tabpage", "d", window)
+}
+
+// Nvim_win_get_var calls nvim_win_get_var(Window window, String name) -> Object.
+func (c *Client) Nvim_win_get_var(window int, name string) (*mpack.Object, error) {
+	return object_call(c.fd, "nvim_win_get_var", "d,s", window, name)
+}
+
+// Nvim_win_get_width calls nvim_win_get_width(Window window) -> Integer.
+func (c *Client) Nvim_win_get_width(window int) (int64, error) {
+	return int64_call(c.fd, "nvim_win_get_width", "d", window)
+}
+
+// Nvim_win_hide calls nvim_win_hide(Window window) -> void.
+func (c *Client) Nvim_win_hide(window int) error {
+	return verify_only_call(c.fd, "nvim_win_hide", "d", window)
+}
+
+// Nvim_win_is_valid calls nvim_win_is_valid(Window window) -> Boolean.
+func (c *Client) Nvim_win_is_valid(window int) (bool, error) {
+	return bool_call(c.fd, "nvim_win_is_valid", "d", window)
+}
+
+// Nvim_win_set_buf calls nvim_win_set_buf(Window window, Buffer buffer) -> void.
+func (c *Client) Nvim_win_set_buf(window int, buffer int) error {
+	return verify_only_call(c.fd, "nvim_win_set_buf", "d,d", window, buffer)
+}
+
+// Nvim_win_set_config calls nvim_win_set_config(Window window, Dictionary config) -> void.
+func (c *Client) Nvim_win_set_config(window int, config map[string]interface{}) error {
+	return verify_only_call(c.fd, "nvim_win_set_config", "d,v", window, config)
+}
+
+// Nvim_win_set_cursor calls nvim_win_set_cursor(Window window, ArrayOf(Integer, 2) pos) -> void.
+func (c *Client) Nvim_win_set_cursor(window int, pos []int) error {
+	return verify_only_call(c.fd, "nvim_win_set_cursor", "d,v", window, pos)
+}
+
+// Nvim_win_set_height calls nvim_win_set_height(Window window, Integer height) -> void.
+func (c *Client) Nvim_win_set_height(window int, height int64) error {
+	return verify_only_call(c.fd, "nvim_win_set_height", "d,l", window, height)
+}
+
+// Nvim_win_set_hl_ns calls nvim_win_set_hl_ns(Window window, Integer ns_id) -> void.
+func (c *Client) Nvim_win_set_hl_ns(window int, ns_id int64) error {
+	return verify_only_call(c.fd, "nvim_win_set_hl_ns", "d,l", window, ns_id)
+}
+
+// Nvim_win_set_option calls nvim_win_set_option(Window window, String name, Object value) -> void.
+func (c *Client) Nvim_win_set_option(window int, name string, value interface{}) error {
+	return verify_only_call(c.fd, "nvim_win_set_option", "d,s,v", window, name, value)
+}
+
+// Nvim_win_set_var calls nvim_win_set_var(Window window, String name, Object value) -> void.
+func (c *Client) Nvim_win_set_var(window int, name string, value interface{}) error {
+	return verify_only_call(c.fd, "nvim_win_set_var", "d,s,v", window, name, value)
+}
+
+// Nvim_win_set_width calls nvim_win_set_width(Window window, Integer width) -> void.
+func (c *Client) Nvim_win_set_width(window int, width int64) error {
+	return verify_only_call(c.fd, "nvim_win_set_width", "d,l", window, width)
+}
@@ -0,0 +1,370 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"syscall"
+	"tag_highlight/mpack"
+	"tag_highlight/util"
+)
+
+type pending_call struct {
+	ch  chan *mpack.Object
+	log bool
+}
+
+/*
+ * One of these exists for every connection to neovim. A single reader goroutine
+ * owns the read end and is the only thing that ever decodes from it. Responses
+ * are handed to whichever caller is waiting on their msgid, notifications are
+ * queued for whoever is listening on the notification channel, and requests are
+ * answered by whatever handler is registered for them. Any number of
+ * goroutines may have requests in flight at once.
+ */
+type rpc_conn struct {
+	name          string
+	dec           *mpack.Decoder
+	w             io.Writer
+	count         uint32
+	err           error
+	closing       bool
+	write_mutex   sync.Mutex
+	pending_mutex sync.Mutex
+	pending       map[uint32]*pending_call
+	notify_cond   *sync.Cond
+	notify_queue  []*mpack.Object
+	notify        chan *mpack.Object
+}
+
+var (
+	conns       = make(map[int]*rpc_conn, 8)
+	conns_mutex sync.Mutex
+)
+
+//========================================================================================
+
+/*
+ * Connections are identified by the fd the caller hands to the api functions. A
+ * value of 0 means the default socket (Sockfd), and a value of 1 means the
+ * stdio channel neovim started us with, which is read from fd 0 and written to
+ * fd 1. Anything else is taken to be a socket that is both read and written.
+ */
+func get_conn(fd int) (*rpc_conn, error) {
+	check_def_fd(&fd)
+	if fd == 0 {
+		return nil, errors.New("Cannot use stdin as a connection (is Sockfd set?)")
+	}
+
+	conns_mutex.Lock()
+	defer conns_mutex.Unlock()
+
+	if conn := conns[fd]; conn != nil {
+		return conn, nil
+	}
+
+	var (
+		name = fmt.Sprintf("fd %d", fd)
+		rfd  = fd
+	)
+	if fd == 1 {
+		name = "stdio"
+		rfd = 0
+	}
+	conn := new_conn(name, fd_stream(rfd), fd_stream(fd))
+	conns[fd] = conn
+
+	return conn, nil
+}
+
+/*
+ * Every kind of connection goes through the same reader and writer, whether it
+ * is stdio, a socket, or an in-memory pipe.
+ */
+func new_conn(name string, r io.Reader, w io.Writer) *rpc_conn {
+	conn := &rpc_conn{
+		name:    name,
+		dec:     mpack.New_Decoder(r),
+		w:       w,
+		count:   0,
+		pending: make(map[uint32]*pending_call, 32),
+		notify:  make(chan *mpack.Object),
+	}
+	conn.notify_cond = sync.NewCond(&conn.pending_mutex)
+
+	go conn.read_loop()
+	go conn.notify_loop()
+
+	return conn
+}
+
+// Notifications returns the channel on which every notification neovim sends
+// over the given connection is delivered, in the order they were received. The
+// channel is closed if the connection is lost.
+func Notifications(fd int) (<-chan *mpack.Object, error) {
+	conn, err := get_conn(fd)
+	if err != nil {
+		return nil, err
+	}
+	return conn.notify, nil
+}
+
+//========================================================================================
+
+func (conn *rpc_conn) read_loop() {
+	for {
+		obj, err := conn.dec.Decode()
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New("connection closed")
+			}
+			conn.shutdown(fmt.Errorf("Lost connection to neovim (%s): %s", conn.name, err))
+			return
+		}
+
+		if obj.Mtype != mpack.T_ARRAY || obj.Len() < 3 {
+			util.Warn("Ignoring malformed message from neovim (type %s).\n", obj.TypeRepr())
+			continue
+		}
+
+		switch obj.Index(0).Get_Int() {
+		case MES_RESPONSE:
+			conn.dispatch_response(obj)
+		case MES_NOTIFICATION:
+			log_nvim_obj(obj, nil)
+			conn.queue_notification(obj)
+		case MES_REQUEST:
+			log_nvim_obj(obj, nil)
+			go conn.handle_request(obj)
+		default:
+			util.Warn("Ignoring message with invalid type %d.\n", obj.Index(0).Get_Int())
+		}
+	}
+}
+
+func (conn *rpc_conn) dispatch_response(obj *mpack.Object) {
+	if obj.Len() != 4 {
+		util.Warn("Ignoring malformed response from neovim.\n")
+		return
+	}
+	id := uint32(obj.Index(1).Get_Int64())
+
+	conn.pending_mutex.Lock()
+	call := conn.pending[id]
+	delete(conn.pending, id)
+	conn.pending_mutex.Unlock()
+
+	if call == nil {
+		util.Eprintf("Got response with unknown msgid %d, discarding.\n", id)
+		return
+	}
+	if call.log {
+		log_nvim_obj(obj, nil)
+	}
+
+	/* The channel is buffered, so this never blocks the reader even if nobody
+	 * ever waits for the result. */
+	call.ch <- obj
+}
+
+/*
+ * Once the connection is gone every caller still waiting for a response is
+ * woken up with the error, and the notification channel is closed as soon as
+ * whatever is already queued has been delivered.
+ */
+func (conn *rpc_conn) shutdown(err error) {
+	conn.pending_mutex.Lock()
+	if !conn.closing {
+		util.Warn("%s\n", err)
+	}
+	conn.err = err
+	for id, call := range conn.pending {
+		close(call.ch)
+		delete(conn.pending, id)
+	}
+	conn.pending_mutex.Unlock()
+	conn.notify_cond.Signal()
+}
+
+/*
+ * Forget about a connection that is being shut down on purpose, so that losing
+ * it isn't reported as an error.
+ */
+func remove_conn(fd int) *rpc_conn {
+	conns_mutex.Lock()
+	conn := conns[fd]
+	delete(conns, fd)
+	conns_mutex.Unlock()
+
+	if conn != nil {
+		conn.pending_mutex.Lock()
+		conn.closing = true
+		conn.pending_mutex.Unlock()
+	}
+	return conn
+}
+
+/*
+ * Notifications are kept in an unbounded queue and forwarded by a separate
+ * goroutine. If the reader itself blocked on the channel, a notification handler
+ * that makes an api call would wait forever for a response the reader can no
+ * longer deliver.
+ */
+func (conn *rpc_conn) queue_notification(obj *mpack.Object) {
+	conn.pending_mutex.Lock()
+	conn.notify_queue = append(conn.notify_queue, obj)
+	conn.pending_mutex.Unlock()
+	conn.notify_cond.Signal()
+}
+
+func (conn *rpc_conn) notify_loop() {
+	for {
+		conn.pending_mutex.Lock()
+		for len(conn.notify_queue) == 0 && conn.err == nil {
+			conn.notify_cond.Wait()
+		}
+		if len(conn.notify_queue) == 0 {
+			conn.pending_mutex.Unlock()
+			close(conn.notify)
+			return
+		}
+		obj := conn.notify_queue[0]
+		conn.notify_queue[0] = nil
+		conn.notify_queue = conn.notify_queue[1:]
+		conn.pending_mutex.Unlock()
+
+		conn.notify <- obj
+	}
+}
+
+//========================================================================================
+
+/*
+ * Reserve a msgid and register the channel its response will be sent on. This
+ * must happen before the request is written, otherwise a fast response could
+ * arrive before anyone is waiting for it.
+ */
+func (conn *rpc_conn) new_call(log bool) (uint32, chan *mpack.Object, error) {
+	conn.pending_mutex.Lock()
+	defer conn.pending_mutex.Unlock()
+
+	if conn.err != nil {
+		return 0, nil, conn.err
+	}
+
+	id := conn.count
+	conn.count++
+	ch := make(chan *mpack.Object, 1)
+	conn.pending[id] = &pending_call{ch, log}
+
+	return id, ch, nil
+}
+
+func (conn *rpc_conn) cancel_call(id uint32) {
+	conn.pending_mutex.Lock()
+	delete(conn.pending, id)
+	conn.pending_mutex.Unlock()
+}
+
+func (conn *rpc_conn) send_request(log bool, fn []byte, format string, a ...interface{}) (chan *mpack.Object, error) {
+	id, ch, err := conn.new_call(log)
+	if err != nil {
+		return nil, err
+	}
+
+	pack, err := encode_fmt_api(id, fn, format, a...)
+	if err == nil {
+		err = conn.write(log, pack)
+	}
+	if err != nil {
+		conn.cancel_call(id)
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+/*
+ * Returns the result field of the response. If neovim reports an error it is
+ * returned as an *NvimError.
+ */
+func (conn *rpc_conn) wait_response(ch chan *mpack.Object) (*mpack.Object, error) {
+	obj, ok := <-ch
+	if !ok {
+		conn.pending_mutex.Lock()
+		defer conn.pending_mutex.Unlock()
+		return nil, conn.err
+	}
+
+	if e := obj.Index(2); e.Mtype != mpack.T_NIL {
+		return nil, new_nvim_error(e)
+	}
+
+	return obj.Index(3), nil
+}
+
+func (conn *rpc_conn) request(log bool, fn []byte, format string, a ...interface{}) (*mpack.Object, error) {
+	ch, err := conn.send_request(log, fn, format, a...)
+	if err != nil {
+		return nil, err
+	}
+	return conn.wait_response(ch)
+}
+
+func (conn *rpc_conn) write(log bool, pack *mpack.Object) error {
+	conn.write_mutex.Lock()
+	defer conn.write_mutex.Unlock()
+
+	if log {
+		fmt.Fprintf(util.Logfiles["nvim"], "Writing request to %s.\n", conn.name)
+		log_nvim_obj(pack, util.Logfiles["nvim"])
+	}
+
+	if _, e := conn.w.Write(pack.GetPack()); e != nil {
+		return fmt.Errorf("Write to neovim failed: %s", e)
+	}
+
+	return nil
+}
+
+//========================================================================================
+
+/*
+ * A raw file descriptor as an io.Reader and io.Writer. This is used rather than
+ * os.NewFile so that nothing ever closes the descriptor behind our back (an
+ * *os.File closes its fd when it is garbage collected).
+ */
+type fd_stream int
+
+func (fd fd_stream) Read(buf []byte) (int, error) {
+	for {
+		n, e := syscall.Read(int(fd), buf)
+		switch {
+		case e == syscall.EINTR:
+			continue
+		case e != nil:
+			return 0, e
+		case n == 0 && len(buf) > 0:
+			return 0, io.EOF
+		}
+		return n, nil
+	}
+}
+
+func (fd fd_stream) Write(buf []byte) (int, error) {
+	var total int
+
+	for total < len(buf) {
+		n, e := syscall.Write(int(fd), buf[total:])
+		if e != nil {
+			if e == syscall.EINTR {
+				continue
+			}
+			return total, e
+		}
+		total += n
+	}
+
+	return total, nil
+}
@@ -0,0 +1,141 @@
+package archive
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var test_header = Header{
+	Ctags_Version: "Universal Ctags 6.0.0",
+	Config_Hash:   "0123abcd",
+	Files: map[string]File_Stamp{
+		"/proj/a.c": {Mtime: 1700000000123456789, Size: 42},
+		"/proj/b.h": {Mtime: 1, Size: 0},
+	},
+}
+
+var test_data = [][]byte{
+	[]byte("main\t/proj/a.c\t/^int main(void)$/;\"\tf"),
+	[]byte(`{"_type": "tag", "name": "foo", "path": "/proj/b.h", "kind": "d"}`),
+	[]byte(""),
+	[]byte(strings.Repeat("x", 100000)),
+}
+
+func write_test_file(t *testing.T, comp_type int) (string, []byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tags")
+	if err := WriteFile(path, &test_header, test_data, comp_type); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, raw
+}
+
+func rewrite(t *testing.T, path string, raw []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, comp_type := range []int{COMP_NONE, COMP_GZIP, COMP_LZMA} {
+		path, _ := write_test_file(t, comp_type)
+
+		hdr, data, err := ReadFile(path, comp_type)
+		if err != nil {
+			t.Errorf("compression %d: %s", comp_type, err)
+			continue
+		}
+		if !reflect.DeepEqual(*hdr, test_header) {
+			t.Errorf("compression %d: header is %+v, want %+v", comp_type, *hdr, test_header)
+		}
+		if !reflect.DeepEqual(data, test_data) {
+			t.Errorf("compression %d: data differs", comp_type)
+		}
+	}
+
+	if err := WriteFile(filepath.Join(t.TempDir(), "tags"), &test_header, nil, COMP_NONE); err == nil {
+		t.Error("wrote a file with no data")
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	for _, comp_type := range []int{COMP_NONE, COMP_GZIP, COMP_LZMA} {
+		path, raw := write_test_file(t, comp_type)
+
+		for _, n := range []int{0, 4, len(magic), prefix_size - 1, prefix_size, prefix_size + 10, len(raw) - 100, len(raw) - 1} {
+			rewrite(t, path, raw[:n])
+			if _, _, err := ReadFile(path, comp_type); !errors.Is(err, ErrFormat) {
+				t.Errorf("compression %d, cut to %d of %d bytes: got %v, want ErrFormat", comp_type, n, len(raw), err)
+			}
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	path, raw := write_test_file(t, COMP_NONE)
+
+	/* Somewhere in the data, which isn't compressed here. */
+	raw[len(raw)-10] ^= 0x20
+	rewrite(t, path, raw)
+	_, _, err := ReadFile(path, COMP_NONE)
+	if !errors.Is(err, ErrFormat) || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("flipped data byte: got %v, want a checksum mismatch", err)
+	}
+	raw[len(raw)-10] ^= 0x20
+
+	/* The checksum covers the header too. */
+	i := strings.Index(string(raw), "0123abcd")
+	raw[i] = '9'
+	rewrite(t, path, raw)
+	_, _, err = ReadFile(path, COMP_NONE)
+	if !errors.Is(err, ErrFormat) || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("altered header: got %v, want a checksum mismatch", err)
+	}
+
+	/* The compressed formats may well notice first, but must still fail. */
+	for _, comp_type := range []int{COMP_GZIP, COMP_LZMA} {
+		path, raw := write_test_file(t, comp_type)
+		raw[len(raw)-20] ^= 0x01
+		rewrite(t, path, raw)
+		if _, _, err := ReadFile(path, comp_type); !errors.Is(err, ErrFormat) {
+			t.Errorf("compression %d, flipped payload byte: got %v, want ErrFormat", comp_type, err)
+		}
+	}
+}
+
+func TestMismatch(t *testing.T) {
+	path, raw := write_test_file(t, COMP_GZIP)
+
+	for _, comp_type := range []int{COMP_NONE, COMP_LZMA} {
+		if _, _, err := ReadFile(path, comp_type); !errors.Is(err, ErrFormat) {
+			t.Errorf("gzip file read as compression %d: got %v, want ErrFormat", comp_type, err)
+		}
+	}
+
+	binary.LittleEndian.PutUint16(raw[len(magic):], Format_Version+1)
+	rewrite(t, path, raw)
+	if _, _, err := ReadFile(path, COMP_GZIP); !errors.Is(err, ErrFormat) {
+		t.Errorf("newer format version: got %v, want ErrFormat", err)
+	}
+
+	copy(raw, "THLTAGZ\x00")
+	binary.LittleEndian.PutUint16(raw[len(magic):], Format_Version)
+	rewrite(t, path, raw)
+	if _, _, err := ReadFile(path, COMP_GZIP); !errors.Is(err, ErrFormat) {
+		t.Errorf("bad magic: got %v, want ErrFormat", err)
+	}
+
+	if _, _, err := ReadFile(filepath.Join(t.TempDir(), "missing"), COMP_GZIP); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: got %v, want ErrNotExist", err)
+	}
+}
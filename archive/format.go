@@ -0,0 +1,130 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+/*
+ * A tag cache file is laid out as
+ *
+ *     magic        8 bytes, "THLTAGS\0"
+ *     version      uint16, Format_Version
+ *     compression  uint16, one of the COMP_* values
+ *     header size  uint32
+ *     header       the Header, as JSON
+ *     data size    uint64, uncompressed
+ *     checksum     uint32, CRC-32C of the header and the uncompressed data
+ *     data         the tags, one per line, compressed
+ *
+ * with every integer little endian. The header says what produced the tags, so
+ * a cache left by another version of ctags or with other arguments can be told
+ * apart from a good one, and the checksum catches one that was cut short or
+ * otherwise mangled.
+ */
+
+const Format_Version = 1
+
+const (
+	magic       = "THLTAGS\x00"
+	prefix_size = len(magic) + 2 + 2 + 4
+	suffix_size = 8 + 4
+)
+
+var (
+	ErrFormat = errors.New("not a valid tag cache")
+
+	crc_table = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type File_Stamp struct {
+	Mtime int64 `json:"mtime"` // ns
+	Size  int64 `json:"size"`
+}
+
+type Header struct {
+	Ctags_Version string                `json:"ctags_version"`
+	Config_Hash   string                `json:"config_hash"` // Of whatever else decides the tags
+	Files         map[string]File_Stamp `json:"files"`       // When each file was tagged
+}
+
+//========================================================================================
+
+func encode_container(hdr *Header, data, payload []byte, comp_type int) ([]byte, error) {
+	hdr_json, err := json.Marshal(hdr)
+	if err != nil {
+		return nil, err
+	}
+	crc := crc32.Update(crc32.Checksum(hdr_json, crc_table), crc_table, data)
+
+	var (
+		buf = bytes.NewBuffer(make([]byte, 0, prefix_size+len(hdr_json)+suffix_size+len(payload)))
+		le  = binary.LittleEndian
+	)
+	buf.WriteString(magic)
+	buf.Write(le.AppendUint16(nil, Format_Version))
+	buf.Write(le.AppendUint16(nil, uint16(comp_type)))
+	buf.Write(le.AppendUint32(nil, uint32(len(hdr_json))))
+	buf.Write(hdr_json)
+	buf.Write(le.AppendUint64(nil, uint64(len(data))))
+	buf.Write(le.AppendUint32(nil, crc))
+	buf.Write(payload)
+
+	return buf.Bytes(), nil
+}
+
+/* A file taken apart, with its data still compressed. */
+type container struct {
+	hdr     *Header
+	hdr_crc uint32 // Of the header, for the data to be added to
+	size    uint64 // What the data should decompress to
+	crc     uint32
+	payload []byte
+}
+
+func decode_container(raw []byte, comp_type int) (*container, error) {
+	le := binary.LittleEndian
+	if len(raw) < prefix_size || string(raw[:len(magic)]) != magic {
+		return nil, ErrFormat
+	}
+	raw = raw[len(magic):]
+
+	if version := le.Uint16(raw); version != Format_Version {
+		return nil, fmt.Errorf("%w: format version %d, expected %d", ErrFormat, version, Format_Version)
+	}
+	if comp := le.Uint16(raw[2:]); int(comp) != comp_type {
+		return nil, fmt.Errorf("%w: compression type %d, expected %d", ErrFormat, comp, comp_type)
+	}
+	hdr_size := uint64(le.Uint32(raw[4:]))
+	raw = raw[8:]
+	if uint64(len(raw)) < hdr_size+uint64(suffix_size) {
+		return nil, fmt.Errorf("%w: truncated header", ErrFormat)
+	}
+
+	cont := &container{hdr: new(Header)}
+	if err := json.Unmarshal(raw[:hdr_size], cont.hdr); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrFormat, err)
+	}
+	cont.hdr_crc = crc32.Checksum(raw[:hdr_size], crc_table)
+	raw = raw[hdr_size:]
+
+	cont.size = le.Uint64(raw)
+	cont.crc = le.Uint32(raw[8:])
+	cont.payload = raw[suffix_size:]
+	return cont, nil
+}
+
+/* Checks the decompressed data against the size and checksum. */
+func (cont *container) verify(data []byte) error {
+	if uint64(len(data)) != cont.size {
+		return fmt.Errorf("%w: %d bytes of data, expected %d", ErrFormat, len(data), cont.size)
+	}
+	if crc32.Update(cont.hdr_crc, crc_table, data) != cont.crc {
+		return fmt.Errorf("%w: checksum mismatch", ErrFormat)
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"compress/gzip"
 	"fmt"
 	"github.com/ulikunitz/xz"
+	"io"
 	"os"
 	"tag_highlight/util"
 )
@@ -19,81 +20,66 @@ const ( // Compression Types
 
 //========================================================================================
 
-func ReadFile(filename string, com_type int) [][]byte {
+/*
+ * ReadFile reads a tag cache written by WriteFile. A file that isn't one, that
+ * has a different format version or compression type, or whose data doesn't
+ * match its checksum gives an error wrapping ErrFormat. Whether the header
+ * still matches is for the caller to decide.
+ */
+func ReadFile(filename string, comp_type int) (*Header, [][]byte, error) {
 	timer := util.NewTimer()
+	defer timer.EchoReport("reading file")
 
-	file, err := os.Open(filename)
+	raw, err := os.ReadFile(filename)
 	if err != nil {
-		panic(fmt.Sprintf("os.Open error '%s'\n", err))
+		return nil, nil, err
+	}
+	cont, err := decode_container(raw, comp_type)
+	if err != nil {
+		return nil, nil, err
 	}
-	defer file.Close()
-	var buf []byte
 
-	switch com_type {
+	var data []byte
+	switch comp_type {
 	case COMP_NONE:
-		buf = read_plain(file)
+		data = cont.payload
 	case COMP_GZIP:
-		buf = read_gzip(file)
+		data, err = read_gzip(cont.payload)
 	case COMP_BZIP2:
-		buf = read_bzip2(file)
+		data, err = read_bzip2(cont.payload)
 	case COMP_LZMA:
-		buf = read_lzma(file)
+		data, err = read_lzma(cont.payload)
 	default:
-		panic(fmt.Sprintf("Illegal value %d passed to ReadFile.\n", com_type))
+		panic(fmt.Sprintf("Illegal value %d passed to ReadFile.\n", comp_type))
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: decompression error: %s", ErrFormat, err)
+	}
+	if err = cont.verify(data); err != nil {
+		return nil, nil, err
 	}
 
-	timer.EchoReport("writing file")
-	return bytes.Split(buf, []byte("\n"))
+	return cont.hdr, bytes.Split(data, []byte("\n")), nil
 }
 
 //========================================================================================
 
-func read_plain(file *os.File) []byte {
-	var ret bytes.Buffer
-	ret.ReadFrom(file)
-	return ret.Bytes()
-}
-
-func read_gzip(file *os.File) []byte {
-	var (
-		reader   *gzip.Reader
-		buf, ret bytes.Buffer
-		err      error
-	)
-
-	buf.ReadFrom(file)
-
-	if reader, err = gzip.NewReader(&buf); err != nil {
-		panic(fmt.Sprintf("Decompression error %s", err))
+func read_gzip(payload []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
 	}
-	ret.ReadFrom(reader)
-
-	return ret.Bytes()
+	return io.ReadAll(reader)
 }
 
-func read_bzip2(file *os.File) []byte {
-	var buf, ret bytes.Buffer
-	buf.ReadFrom(file)
-
-	reader := bzip2.NewReader(&buf)
-	ret.ReadFrom(reader)
-
-	return ret.Bytes()
+func read_bzip2(payload []byte) ([]byte, error) {
+	return io.ReadAll(bzip2.NewReader(bytes.NewReader(payload)))
 }
 
-func read_lzma(file *os.File) []byte {
-	var (
-		reader   *xz.Reader
-		buf, ret bytes.Buffer
-		err      error
-	)
-
-	buf.ReadFrom(file)
-
-	if reader, err = xz.NewReader(&buf); err != nil {
-		panic(fmt.Sprintf("Decompression error %s", err))
+func read_lzma(payload []byte) ([]byte, error) {
+	reader, err := xz.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
 	}
-	ret.ReadFrom(reader)
-
-	return ret.Bytes()
+	return io.ReadAll(reader)
 }
@@ -3,100 +3,89 @@ package archive
 import (
 	"bytes"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"github.com/ulikunitz/xz"
 	"os"
-	"tag_highlight/api"
 	"tag_highlight/util"
 )
 
 //========================================================================================
 
-func WriteFile(filename string, data [][]byte, com_type int) bool {
+/*
+ * WriteFile writes the tags and their header out in the format described in
+ * format.go. It's written to a temporary file that is then renamed over the
+ * old one, so a reader never sees half a file.
+ */
+func WriteFile(filename string, hdr *Header, data [][]byte, comp_type int) error {
 	if data == nil {
-		return false
+		return errors.New("no data")
 	}
-	var status bool
 	timer := util.NewTimer()
-	joined := bytes.Join(data, []byte("\n"))
+	defer timer.EchoReport("writing file")
 
-	// api.Echo("Writing file '%s'", filename)
-
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
-	if err != nil {
-		panic(fmt.Sprintf("os.Create error '%s'\n", err))
-	}
-	defer file.Close()
+	var (
+		joined  = bytes.Join(data, []byte("\n"))
+		payload []byte
+		err     error
+	)
 
-	switch com_type {
+	switch comp_type {
 	case COMP_NONE:
-		status = write_plain(file, joined)
+		payload = joined
 	case COMP_GZIP:
-		status = write_gzip(file, joined)
+		payload, err = write_gzip(joined)
 	case COMP_BZIP2:
-		status = write_bzip2(file, joined)
+		err = errors.New("bzip2 compression is not supported for writing")
 	case COMP_LZMA:
-		status = write_lzma(file, joined)
+		payload, err = write_lzma(joined)
 	default:
-		panic(fmt.Sprintf("Illegal value %d passed to WriteFile.\n", com_type))
+		panic(fmt.Sprintf("Illegal value %d passed to WriteFile.\n", comp_type))
 	}
-
-	timer.EchoReport("writing file")
-	return status
-}
-
-//========================================================================================
-
-func write_plain(file *os.File, data []byte) bool {
-	if data == nil {
-		return false
+	if err != nil {
+		return err
 	}
-	n, err := file.Write(data)
 
-	if n != len(data) && err != nil {
-		api.Echo("Warning: write error.\n")
-		return false
+	raw, err := encode_container(hdr, joined, payload, comp_type)
+	if err != nil {
+		return err
 	}
 
-	return true
+	tmp := fmt.Sprintf("%s.%d", filename, os.Getpid())
+	if err = os.WriteFile(tmp, raw, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filename)
 }
 
-func write_gzip(file *os.File, data []byte) bool {
-	writer := gzip.NewWriter(file)
-	n, err := writer.Write(data)
+//========================================================================================
 
-	if n != len(data) || err != nil {
-		api.Echo("Warning: gzip write error.\n")
-		return false
-	}
+func write_gzip(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
 
-	return true
-}
-
-func write_bzip2(file *os.File, data []byte) bool {
-	panic("Not implemented")
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
-func write_lzma(file *os.File, data []byte) bool {
-	if data == nil {
-		return false
+func write_lzma(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := xz.NewWriter(&buf)
+	if err != nil {
+		return nil, err
 	}
-	var (
-		writer *xz.Writer
-		err    error
-		n      int
-	)
 
-	if writer, err = xz.NewWriter(file); err != nil {
-		panic(fmt.Sprintf("Compression error %s", err))
+	if _, err = writer.Write(data); err != nil {
+		return nil, err
 	}
-	defer writer.Close()
-
-	n, err = writer.Write(data)
-	if n != len(data) || err != nil {
-		api.Echo("Warning: lzma write error.\n")
-		return false
+	if err = writer.Close(); err != nil {
+		return nil, err
 	}
-
-	return true
+	return buf.Bytes(), nil
 }
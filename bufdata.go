@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -12,6 +13,7 @@ import (
 	"tag_highlight/archive"
 	"tag_highlight/lists"
 	"tag_highlight/mpack"
+	"tag_highlight/scan"
 	"tag_highlight/util"
 )
 
@@ -38,6 +40,12 @@ type TopDir struct {
 	Pathname string
 	Tmpfname string
 	Tags     [][]byte
+	Records  []scan.Tag_Record // Tags, parsed
+	Sources  []TagSource
+	DB       tag_db            // Records, by file
+	extra    []scan.Tag_Record // Records not in DB
+	ft       *Ftdata
+	watcher  *project_watcher
 }
 
 type Bufdata struct {
@@ -46,10 +54,13 @@ type Bufdata struct {
 	Num         uint16
 	Initialized bool
 	Filename    string
-	Lines       *lists.Linked_List
+	Lines       *lists.Line_Tree
+	Tokens      *scan.Token_Cache
 	Calls       *api.Atomic_list
 	Ft          *Ftdata
 	Topdir      *TopDir
+	Highlighted []scan.Tag
+	View        viewport
 }
 
 const init_bufs int = 4096
@@ -112,7 +123,12 @@ func New_Buffer(fd, bufnum int) *Bufdata {
 		}
 	}
 
-	ft_str := api.Nvim_buf_get_option(fd, bufnum, []byte("ft"), mpack.E_STRING).(string)
+	tmp, err := api.Nvim_buf_get_option(fd, bufnum, []byte("ft"), mpack.E_STRING)
+	if err != nil {
+		util.Eprintf("Failed to get the filetype of buffer %d: %s\n", bufnum, err)
+		return nil
+	}
+	ft_str, _ := tmp.(string)
 	ft := id_filetype(ft_str)
 	if ft == nil {
 		api.Echo("Failed to identify filetype '%s'.", ft)
@@ -126,9 +142,15 @@ func New_Buffer(fd, bufnum int) *Bufdata {
 		}
 	}
 
-	bdata := get_bufdata(fd, bufnum, ft)
+	bdata, err := get_bufdata(fd, bufnum, ft)
+	if err != nil {
+		util.Eprintf("Failed to initialize buffer %d: %s\n", bufnum, err)
+		return nil
+	}
 	if bdata.Ft.Id != FT_NONE && !bdata.Ft.Initialized {
-		init_filetype(fd, ft)
+		if err = init_filetype(fd, ft); err != nil {
+			util.Eprintf("Failed to initialize filetype %s: %s\n", ft.Vim_Name, err)
+		}
 	}
 	buffers.lst[buffers.mkr] = bdata
 	buffers.mkr++
@@ -165,6 +187,7 @@ func Remove_Buffer(bufnum int) {
 		topdir := bdata.Topdir
 		topdir.refs--
 		if topdir.refs == 0 {
+			topdir.stop_watching()
 			sys.Close(int(topdir.Tmpfd))
 			sys.Unlink(topdir.Tmpfname)
 
@@ -189,26 +212,32 @@ func Remove_Buffer(bufnum int) {
 
 //========================================================================================
 
-func get_bufdata(fd, bufnum int, ft *Ftdata) *Bufdata {
+func get_bufdata(fd, bufnum int, ft *Ftdata) (*Bufdata, error) {
+	fname, err := api.Nvim_buf_get_name(fd, bufnum)
+	if err != nil {
+		return nil, err
+	}
 	bdata := Bufdata{
-		Filename:    api.Nvim_buf_get_name(fd, bufnum),
+		Filename:    fname,
 		Ft:          ft,
 		Num:         uint16(bufnum),
 		Ctick:       0,
 		Last_Ctick:  0,
 		Initialized: false,
 		Calls:       nil,
-		Lines:       lists.New_List(),
+		Lines:       lists.New_Line_Tree(""),
+		Tokens:      scan.New_Token_Cache(int(ft.Id)),
 		Topdir:      nil,
 	}
 
-	bdata.Lines.Append("")
-	bdata.Topdir = init_topdir(fd, &bdata)
+	if bdata.Topdir, err = init_topdir(fd, &bdata); err != nil {
+		return nil, err
+	}
 
-	return &bdata
+	return &bdata, nil
 }
 
-func init_topdir(fd int, bdata *Bufdata) *TopDir {
+func init_topdir(fd int, bdata *Bufdata) (*TopDir, error) {
 	var (
 		dirname = check_project_directories(filepath.Dir(bdata.Filename))
 		recurse = check_norecurse_directories(dirname)
@@ -225,16 +254,20 @@ func init_topdir(fd int, bdata *Bufdata) *TopDir {
 	for _, tdir := range TopDir_List {
 		if tdir != nil && tdir.Id == bdata.Ft.Id && tdir.Pathname == base {
 			tdir.refs++
-			return tdir
+			return tdir, nil
 		}
 	}
 
 	api.Echo("Initializing new topdir \"%s\", ft %s", dirname, bdata.Ft.Vim_Name)
 
-	tmp_fname := api.Nvim_call_function(fd, []byte("tempname"), mpack.E_STRING).(string)
+	tmp_fname, err := get_tempname(fd)
+	if err != nil {
+		return nil, err
+	}
 	tmp := TopDir{
 		Gzfile:   HOME + "/.vim_tags_go/",
 		Id:       bdata.Ft.Id,
+		ft:       bdata.Ft,
 		Is_C:     is_c,
 		Pathname: dirname,
 		Recurse:  recurse,
@@ -263,30 +296,51 @@ func init_topdir(fd int, bdata *Bufdata) *TopDir {
 	}
 
 	TopDir_List = append(TopDir_List, &tmp)
+	tmp.start_watching(bdata.Ft)
 
-	return &tmp
+	return &tmp, nil
 }
 
-func init_filetype(fd int, ft *Ftdata) {
+func init_filetype(fd int, ft *Ftdata) error {
 	if ft.Initialized {
-		return
+		return nil
 	}
 	ftdata_mutex.Lock()
 	defer ftdata_mutex.Unlock()
 
 	ft.Initialized = true
-	ft.Order = api.Nvim_get_var_fmt(fd, mpack.E_BYTES, "tag_highlight#%s#order", ft.Vim_Name).([]byte)
+	order, err := api.Nvim_get_var_fmt(fd, mpack.E_BYTES, "tag_highlight#%s#order", ft.Vim_Name)
+	if err != nil {
+		return err
+	}
+	ft.Order, _ = order.([]byte)
 	ft.Ignored_Tags = Settings.Ignored_tags[ft.Vim_Name]
 
-	tmp := api.Nvim_get_var_fmt(fd, mpack.E_MAP_RUNE_RUNE, "tag_highlight#%s#equivalent", ft.Vim_Name)
-	switch tmp.(type) {
+	tmp, err := api.Nvim_get_var_fmt(fd, mpack.E_MAP_RUNE_RUNE, "tag_highlight#%s#equivalent", ft.Vim_Name)
+	if err != nil {
+		return err
+	}
+	switch tmp := tmp.(type) {
 	case nil:
 		ft.Equiv = nil
 	case map[rune]rune:
-		ft.Equiv = tmp.(map[rune]rune)
+		ft.Equiv = tmp
 	default:
-		panic("Got garbage return value.")
+		return fmt.Errorf("Got garbage value %T for the equivalent map", tmp)
+	}
+
+	return nil
+}
+
+func get_tempname(fd int) (string, error) {
+	tmp, err := api.Nvim_call_function(fd, []byte("tempname"), mpack.E_STRING)
+	if err != nil {
+		return "", err
+	}
+	if name, ok := tmp.(string); ok && name != "" {
+		return name, nil
 	}
+	return "", errors.New("Neovim returned an empty temporary file name")
 }
 
 //========================================================================================
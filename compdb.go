@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"tag_highlight/util"
+	"time"
+)
+
+/*
+ * Headers are found by looking for them in a list of directories. Beyond the
+ * project's directory and the file's own, that list comes from the include
+ * flags the project is actually compiled with: those in the compile_commands.json
+ * (as written by CMake, meson, bear and friends) or compile_flags.txt that
+ * applies to the file.
+ */
+
+type compdb_entry struct {
+	Directory string   `json:"directory"`
+	File      string   `json:"file"`
+	Command   string   `json:"command"`
+	Arguments []string `json:"arguments"`
+}
+
+/* What was read from one compilation database, kept until it changes. */
+type compdb_cache_entry struct {
+	mtime    time.Time
+	by_file  map[string][]string // Include dirs of each file
+	all_dirs []string            // ... and of all of them together
+}
+
+/* Where build systems commonly put the database, relative to the project. */
+var compdb_build_dirs = []string{"", "build", "builddir", "out", "cmake-build-debug", "cmake-build-release"}
+
+var compdb_cache = struct {
+	sync.Mutex
+	lst map[string]*compdb_cache_entry
+}{lst: make(map[string]*compdb_cache_entry)}
+
+//========================================================================================
+
+/*
+ * Returns the include directories for filename, looking in each directory from
+ * the file's own up to the project's for a compilation database. Files that
+ * aren't in the database (headers, usually) get the directories of every file
+ * in it.
+ */
+func find_include_dirs(filename, topdir string) []string {
+	for dir := filepath.Dir(filename); ; dir = filepath.Dir(dir) {
+		for _, sub := range compdb_build_dirs {
+			path := filepath.Join(dir, sub, "compile_commands.json")
+			if cache := read_compdb(path); cache != nil {
+				if dirs, ok := cache.by_file[filename]; ok {
+					return dirs
+				}
+				return cache.all_dirs
+			}
+		}
+
+		path := filepath.Join(dir, "compile_flags.txt")
+		if dirs, err := read_compile_flags(path); err == nil {
+			return dirs
+		}
+
+		if dir == topdir || !strings.HasPrefix(dir, topdir) || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func read_compdb(path string) *compdb_cache_entry {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+
+	compdb_cache.Lock()
+	defer compdb_cache.Unlock()
+
+	if cache := compdb_cache.lst[path]; cache != nil && cache.mtime.Equal(st.ModTime()) {
+		return cache
+	}
+
+	var entries []compdb_entry
+	if data, err := os.ReadFile(path); err != nil {
+		util.Warn("Failed to read %s: %s", path, err)
+		return nil
+	} else if err = json.Unmarshal(data, &entries); err != nil {
+		util.Warn("Failed to parse %s: %s", path, err)
+		return nil
+	}
+
+	cache := &compdb_cache_entry{
+		mtime:   st.ModTime(),
+		by_file: make(map[string][]string, len(entries)),
+	}
+	for _, ent := range entries {
+		args := ent.Arguments
+		if len(args) == 0 {
+			args = split_command(ent.Command)
+		}
+		file := ent.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(ent.Directory, file)
+		}
+
+		dirs := include_flag_dirs(args, ent.Directory)
+		cache.by_file[filepath.Clean(file)] = dirs
+		cache.all_dirs = append(cache.all_dirs, dirs...)
+	}
+	cache.all_dirs = util.Unique_Str(cache.all_dirs)
+
+	compdb_cache.lst[path] = cache
+	return cache
+}
+
+/* compile_flags.txt has one argument per line, relative to its directory. */
+func read_compile_flags(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	args := make([]string, 0, 32)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if arg := strings.TrimSpace(scanner.Text()); arg != "" {
+			args = append(args, arg)
+		}
+	}
+
+	return include_flag_dirs(args, filepath.Dir(path)), scanner.Err()
+}
+
+//========================================================================================
+
+/*
+ * Picks the directories out of -I, -isystem and -iquote, written either joined
+ * to their argument or separately.
+ */
+func include_flag_dirs(args []string, cwd string) []string {
+	dirs := make([]string, 0, 16)
+
+	for i := 0; i < len(args); i++ {
+		for _, flag := range []string{"-I", "-isystem", "-iquote"} {
+			if !strings.HasPrefix(args[i], flag) {
+				continue
+			}
+			dir := args[i][len(flag):]
+			if dir == "" && i+1 < len(args) {
+				i++
+				dir = args[i]
+			}
+			if dir == "" {
+				break
+			}
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(cwd, dir)
+			}
+			dirs = append(dirs, filepath.Clean(dir))
+			break
+		}
+	}
+
+	return dirs
+}
+
+/*
+ * Splits a "command" the way a POSIX shell would split words, which is enough
+ * for the quoting compilation databases use.
+ */
+func split_command(cmd string) []string {
+	var (
+		ret    = make([]string, 0, 32)
+		word   bytes.Buffer
+		quote  byte
+		inword bool
+	)
+
+	for i := 0; i < len(cmd); i++ {
+		ch := cmd[i]
+		switch {
+		case quote == '\'':
+			if ch == '\'' {
+				quote = 0
+			} else {
+				word.WriteByte(ch)
+			}
+		case ch == '\\' && i+1 < len(cmd) && (quote == 0 || strings.IndexByte("\"\\$`", cmd[i+1]) != (-1)):
+			i++
+			word.WriteByte(cmd[i])
+			inword = true
+		case quote == '"':
+			if ch == '"' {
+				quote = 0
+			} else {
+				word.WriteByte(ch)
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+			inword = true
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			if inword {
+				ret = append(ret, word.String())
+				word.Reset()
+				inword = false
+			}
+		default:
+			word.WriteByte(ch)
+			inword = true
+		}
+	}
+	if inword {
+		ret = append(ret, word.String())
+	}
+
+	return ret
+}
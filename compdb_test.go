@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want []string
+	}{
+		{"cc -c foo.c", []string{"cc", "-c", "foo.c"}},
+		{"  cc\t-c \n foo.c  ", []string{"cc", "-c", "foo.c"}},
+		{`cc "-I/path/with spaces/inc" foo.c`, []string{"cc", "-I/path/with spaces/inc", "foo.c"}},
+		{`cc -I'/single quoted' foo.c`, []string{"cc", "-I/single quoted", "foo.c"}},
+		{`cc -DNAME=\"value\" foo.c`, []string{"cc", `-DNAME="value"`, "foo.c"}},
+		{`cc "-DNAME=\"a b\"" foo.c`, []string{"cc", `-DNAME="a b"`, "foo.c"}},
+		{`cc "-DA=\n" '-DB=\"'`, []string{"cc", `-DA=\n`, `-DB=\"`}},
+		{`cc -I/with\ space foo.c`, []string{"cc", "-I/with space", "foo.c"}},
+		{`cc "" foo.c`, []string{"cc", "", "foo.c"}},
+		{"", []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := split_command(tt.cmd); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("split_command(%q) = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestIncludeFlagDirs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"cc", "-Iinc", "-I", "other", "-c", "foo.c"}, []string{"/proj/build/inc", "/proj/build/other"}},
+		{[]string{"-isystem", "/usr/include/foo", "-isystem/opt/x"}, []string{"/usr/include/foo", "/opt/x"}},
+		{[]string{"-iquote", "../src", "-I/abs/../abs2/"}, []string{"/proj/src", "/abs2"}},
+		{[]string{"-I/path/with spaces"}, []string{"/path/with spaces"}},
+		{[]string{"-DFOO", "-o", "foo.o", "-include", "config.h"}, []string{}},
+		{[]string{"cc", "-I"}, []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := include_flag_dirs(tt.args, "/proj/build"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("include_flag_dirs(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestFindIncludeDirs(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		build = filepath.Join(dir, "build")
+	)
+	write := func(path, data string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries := []compdb_entry{
+		{
+			Directory: build,
+			File:      "../src/a.c",
+			Command:   `cc "-I../inc dir" -isystem /usr/include/foo -DX=\"y\" -c ../src/a.c`,
+		},
+		{
+			Directory: build,
+			File:      filepath.Join(dir, "src", "b.c"),
+			Arguments: []string{"cc", "-I", "gen", "-c", "../src/b.c"},
+		},
+	}
+	data, _ := json.Marshal(entries)
+	write(filepath.Join(build, "compile_commands.json"), string(data))
+	write(filepath.Join(dir, "lib", "compile_flags.txt"), "-xc\n  -Ilocal  \n\n-isystem\n/opt/sys\n")
+
+	tests := []struct {
+		file string
+		want []string
+	}{
+		{"src/a.c", []string{filepath.Join(dir, "inc dir"), "/usr/include/foo"}},
+		{"src/b.c", []string{filepath.Join(build, "gen")}},
+		/* Not in the database, so it gets everything in it. */
+		{"src/a.h", []string{filepath.Join(dir, "inc dir"), "/usr/include/foo", filepath.Join(build, "gen")}},
+		{"src/deeper/c.h", []string{filepath.Join(dir, "inc dir"), "/usr/include/foo", filepath.Join(build, "gen")}},
+		/* The nearest flags win. */
+		{"lib/d.c", []string{filepath.Join(dir, "lib", "local"), "/opt/sys"}},
+	}
+
+	for _, tt := range tests {
+		got := find_include_dirs(filepath.Join(dir, tt.file), dir)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("find_include_dirs(%s) = %q, want %q", tt.file, got, tt.want)
+		}
+	}
+
+	if got := find_include_dirs(filepath.Join(t.TempDir(), "e.c"), dir); got != nil {
+		t.Errorf("a file outside the project got %q", got)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -13,6 +14,7 @@ import (
 	sys "syscall"
 	"tag_highlight/api"
 	"tag_highlight/archive"
+	"tag_highlight/scan"
 	"tag_highlight/util"
 )
 
@@ -28,6 +30,21 @@ func (bdata *Bufdata) Run_Ctags(force int) bool {
 		headers = find_headers(bdata)
 	}
 
+	/* The interactive worker only does single files, so a recursive run
+	 * always has to exec ctags. */
+	if !ctags_recurse(bdata, force) {
+		tags, err := run_ctags_interactive(bdata, headers)
+		if err == nil {
+			err = bdata.Topdir.write_tmpfile_lines(tags)
+		}
+		if err == nil {
+			return true
+		}
+		if err != errNoInteractive {
+			util.Warn("Interactive ctags failed (%s), running it the old way", err)
+		}
+	}
+
 	status := exec_ctags(bdata, headers, force)
 
 	api.Echo("Status: %d", status)
@@ -52,15 +69,19 @@ func (bdata *Bufdata) Get_Initial_Taglist() bool {
 
 	if e == nil {
 		api.Echo("Reading gzfile '%s'", bdata.Topdir.Gzfile)
-		if bdata.Topdir.Read_Gzfile(int(Settings.Comp_type)) {
+		if err := bdata.Topdir.Read_Gzfile(int(Settings.Comp_type)); err == nil {
 			if err := bdata.Topdir.Write_Tmpfile(); err != nil {
 				util.Warn("Error writing tag file: %s\n", err)
 			}
+			bdata.refresh_stale_tags()
+		} else if errors.Is(err, archive.ErrFormat) || errors.Is(err, errStaleCache) {
+			api.Echo("Not using '%s' (%s); regenerating it", bdata.Topdir.Gzfile, err)
+			do_ctags = true
 		} else {
 			if !bdata.Initialized {
 				return false
 			}
-			util.Warn("Could not read tag file; running ctags")
+			util.Warn("Could not read tag file (%s); running ctags", err)
 			do_ctags = true
 		}
 	} else {
@@ -74,11 +95,8 @@ func (bdata *Bufdata) Get_Initial_Taglist() bool {
 			util.Warn("Unexpected io error: %v", e)
 		}
 
-		if !bdata.Run_Ctags(0) {
-			util.Warn("Ctags failed...")
-		}
-		if err := bdata.Topdir.Read_Tmpfile(); err != nil {
-			util.Warn("Read error: %v", err)
+		if !bdata.Generate_Tags(0) {
+			util.Warn("Tag generation failed...")
 		}
 		if !bdata.Topdir.Write_Gzfile(int(Settings.Comp_type)) {
 			api.Echo("Error writing gzfile")
@@ -98,12 +116,8 @@ func (bdata *Bufdata) Update_Taglist(force int) bool {
 	}
 
 	bdata.Last_Ctick = bdata.Ctick
-	if !bdata.Run_Ctags(force) {
-		util.Warn("Ctags failed...")
-	}
-
-	if err := bdata.Topdir.Read_Tmpfile(); err != nil {
-		api.Echo("Error reading temporary file '%s'", err)
+	if !bdata.Generate_Tags(force) {
+		util.Warn("Tag generation failed...")
 		return false
 	}
 
@@ -120,15 +134,16 @@ func (bdata *Bufdata) Update_Taglist(force int) bool {
 //========================================================================================
 
 type tdata struct {
-	wg         *sync.WaitGroup
-	ret        *[]string
-	searched   *[]string
-	src_dirs   []string
-	cur_header string
+	wg           *sync.WaitGroup
+	ret          *[]string
+	searched     *[]string
+	sys_includes *[]string
+	src_dirs     []string
+	cur_header   string
 }
 
 func find_headers(bdata *Bufdata) []string {
-	includes := find_includes(bdata)
+	includes, sys_includes := find_includes(bdata)
 	if includes == nil {
 		util.Warn("includes is nil...")
 		return nil
@@ -139,16 +154,10 @@ func find_headers(bdata *Bufdata) []string {
 		return nil
 	}
 	headers := find_header_paths(src_dirs, includes)
-	if headers == nil {
-		util.Warn("headers is nil...")
-		return nil
-	}
 	if len(headers) == 0 {
-		util.Warn("No headers found at all...")
-		return nil
+		util.Warn("No local headers found...")
 	}
 
-	includes = util.Unique_Str(includes)
 	var (
 		hcopy    = make([]string, len(headers))
 		searched = make([]string, 0, 32)
@@ -160,11 +169,12 @@ func find_headers(bdata *Bufdata) []string {
 	for i, file := range hcopy {
 		tmp := make([]string, 0, 32)
 		data[i] = tdata{
-			wg:         &wg,
-			ret:        &tmp,
-			searched:   &searched,
-			src_dirs:   src_dirs,
-			cur_header: file,
+			wg:           &wg,
+			ret:          &tmp,
+			searched:     &searched,
+			sys_includes: &sys_includes,
+			src_dirs:     src_dirs,
+			cur_header:   file,
 		}
 		wg.Add(1)
 		go recurse_headers(&data[i], 1)
@@ -178,39 +188,67 @@ func find_headers(bdata *Bufdata) []string {
 		}
 	}
 
+	/* Anything included with <> that isn't part of the project. */
+	local := make(map[string]bool, len(headers))
+	for _, file := range headers {
+		local[file] = true
+	}
+	for _, file := range find_system_headers(util.Unique_Str(sys_includes), bdata.Ft.Id == FT_CPP) {
+		if !local[file] {
+			headers = append(headers, file)
+		}
+	}
+
+	save_include_graph()
+
+	if len(headers) == 0 {
+		util.Warn("No headers found at all...")
+		return nil
+	}
 	return util.Unique_Str(headers)
 }
 
-func find_includes(bdata *Bufdata) []string {
+/*
+ * Returns pairs of included file and the directory to look in first, and
+ * separately the files included with angle brackets.
+ */
+func find_includes(bdata *Bufdata) ([]string, []string) {
 	includes := make([]string, 32)
+	sys_includes := make([]string, 0, 32)
 	dirname := filepath.Dir(bdata.Filename)
 
-	for node := bdata.Lines.Head; node != nil; node = node.Next {
-		file := analyze_line(node.Data.(string))
-		if file != "" {
+	bdata.Lines.Each(func(_ int, line string) bool {
+		if file, angle := analyze_line(line); file != "" {
 			includes = append(includes, file, dirname)
+			if angle {
+				sys_includes = append(sys_includes, file)
+			}
 		}
-	}
+		return true
+	})
 
 	if len(includes) == 0 {
 		includes = nil
 	}
-	return includes
+	return includes, sys_includes
 }
 
 func find_src_dirs(bdata *Bufdata, includes []string) []string {
 	src_dirs := make([]string, 0, 32)
 
-	/***********************************
-	 *      PARSE JSON FILE HERE       *
-	 ***********************************/
-
 	src_dirs = append(src_dirs, bdata.Topdir.Pathname)
 	file_dir := filepath.Dir(bdata.Filename)
 	if bdata.Topdir.Pathname != file_dir {
 		src_dirs = append(src_dirs, file_dir)
 	}
 
+	/* Then whatever the compilation database says the file is built with. */
+	for _, dir := range find_include_dirs(bdata.Filename, bdata.Topdir.Pathname) {
+		if dir != bdata.Topdir.Pathname && dir != file_dir {
+			src_dirs = append(src_dirs, dir)
+		}
+	}
+
 	if len(src_dirs) == 0 {
 		src_dirs = nil
 	}
@@ -242,14 +280,16 @@ func recurse_headers(data *tdata, level int) {
 	var (
 		dirname  = filepath.Dir(data.cur_header)
 		includes = make([]string, 0, 32)
-		slurp    = util.Quick_Read(data.cur_header)
-		lines    = bytes.Split(slurp, []byte("\n"))
 	)
 
-	for _, ln := range lines {
-		str := string(ln)
-		if file := analyze_line(str); file != "" {
-			includes = append(includes, file, dirname)
+	if hdr := header_includes(data.cur_header); hdr != nil {
+		for _, inc := range hdr.Includes {
+			includes = append(includes, inc.File, dirname)
+			if inc.Angle {
+				searched_mutex.Lock()
+				*data.sys_includes = append(*data.sys_includes, inc.File)
+				searched_mutex.Unlock()
+			}
 		}
 	}
 
@@ -260,11 +300,12 @@ func recurse_headers(data *tdata, level int) {
 
 		for _, file := range headers {
 			tmp := tdata{
-				wg:         nil,
-				ret:        data.ret,
-				searched:   data.searched,
-				src_dirs:   data.src_dirs,
-				cur_header: file,
+				wg:           nil,
+				ret:          data.ret,
+				searched:     data.searched,
+				sys_includes: data.sys_includes,
+				src_dirs:     data.src_dirs,
+				cur_header:   file,
 			}
 			recurse_headers(&tmp, level+1)
 		}
@@ -274,9 +315,11 @@ func recurse_headers(data *tdata, level int) {
 	}
 }
 
-func analyze_line(line string) string {
+/* Returns the file a line #includes, if any, and whether it's in <>. */
+func analyze_line(line string) (string, bool) {
 	i, m := 0, len(line)
 	ret := ""
+	angle := false
 
 	if m > 0 && line[i] == '#' {
 		i++
@@ -287,18 +330,23 @@ func analyze_line(line string) string {
 			if i < m {
 				ch := line[i]
 				if ch == '"' || ch == '<' {
+					closing := ch
+					if ch == '<' {
+						closing = '>'
+					}
 					i++
-					end := strings.IndexByte(line[i:], ch)
+					end := strings.IndexByte(line[i:], closing)
 					if end != (-1) {
 						end += i
 						ret = line[i:end]
+						angle = ch == '<'
 					}
 				}
 			}
 		}
 	}
 
-	return ret
+	return ret, angle
 }
 
 //========================================================================================
@@ -376,25 +424,53 @@ func find_header_paths(src_dirs, includes []string) []string {
 
 var tmp_mutex sync.Mutex
 
-func (topdir *TopDir) Read_Gzfile(comp_type int) bool {
+/* Kind letters (not names), language, line, scope and access. */
+const ctags_json_fields = "--fields=-K+klnsa"
+
+var ctags_json struct {
+	once sync.Once
+	ok   bool
+}
+
+/*
+ * Reads the tag cache, if it was made the way tags would be made now. For a
+ * project tagged recursively the database is filled from it too.
+ */
+func (topdir *TopDir) Read_Gzfile(comp_type int) error {
+	hdr, tags, err := archive.ReadFile(topdir.Gzfile, comp_type)
+	if err != nil {
+		return err
+	}
+	use_db := topdir.Recurse && !topdir.Is_C
+	if err = topdir.check_cache_header(hdr, use_db); err != nil {
+		return err
+	}
+
+	records := scan.Parse_Tags(tags)
+	if use_db {
+		topdir.extra = topdir.DB.load(hdr.Files, records)
+	}
+
 	tmp_mutex.Lock()
 	defer tmp_mutex.Unlock()
-	topdir.Tags = archive.ReadFile(topdir.Gzfile, comp_type)
-	return (topdir.Tags != nil)
+	topdir.Tags = tags
+	topdir.Records = records
+	return nil
 }
 
-func (topdir *TopDir) Read_Tmpfile() error {
+/* Returns the lines ctags wrote, leaving the TopDir's tags alone. */
+func (topdir *TopDir) Read_Tmpfile() ([][]byte, error) {
 	tmp_mutex.Lock()
 	defer tmp_mutex.Unlock()
 
 	if topdir.Tmpfd == (-1) {
-		return errors.New("File not open")
+		return nil, errors.New("File not open")
 	}
 	var st sys.Stat_t
 
 	if err := sys.Fstat(int(topdir.Tmpfd), &st); err != nil {
 		util.Warn("Error stat'ing temporary file")
-		return err
+		return nil, err
 	}
 
 	buf := make([]byte, st.Size)
@@ -403,14 +479,19 @@ func (topdir *TopDir) Read_Tmpfile() error {
 
 	util.Assert(int64(rlen) == st.Size && err == nil,
 		fmt.Sprintf("Read error (%d of %d bytes read): %s", rlen, st.Size, err))
-	topdir.Tags = bytes.Split(buf, []byte("\n"))
-	return err
+	return bytes.Split(buf, []byte("\n")), err
 }
 
 func (topdir *TopDir) Write_Gzfile(comp_type int) bool {
+	hdr := topdir.cache_header()
+
 	tmp_mutex.Lock()
 	defer tmp_mutex.Unlock()
-	return archive.WriteFile(topdir.Gzfile, topdir.Tags, comp_type)
+	if err := archive.WriteFile(topdir.Gzfile, hdr, topdir.Tags, comp_type); err != nil {
+		util.Warn("Failed to write '%s': %s", topdir.Gzfile, err)
+		return false
+	}
+	return true
 }
 
 func (topdir *TopDir) Write_Tmpfile() error {
@@ -437,18 +518,15 @@ func exec_ctags(bdata *Bufdata, headers []string, force int) int {
 	argv := make([]string, 0, len(headers)+32)
 	argv = append(argv, "--", "ctags")
 	argv = append(argv, Settings.Ctags_args...)
+	if ctags_has_json() {
+		argv = append(argv, "--output-format=json", ctags_json_fields)
+	}
 	argv = append(argv, "-f"+bdata.Topdir.Tmpfname)
 
-	if (force != 2) && bdata.Topdir.Recurse && !bdata.Topdir.Is_C {
+	if ctags_recurse(bdata, force) {
 		argv = append(argv, "--languages="+bdata.Ft.Ctags_Name, "-R", bdata.Topdir.Pathname)
 	} else {
-		if bdata.Topdir.Is_C {
-			argv = append(argv, "--languages=c,c++")
-		} else {
-			argv = append(argv, "--languages-force="+bdata.Ft.Ctags_Name)
-		}
-
-		argv = append(argv, bdata.Filename)
+		argv = append(argv, ctags_lang_arg(bdata), bdata.Filename)
 
 		if headers != nil {
 			sort.Strings(headers)
@@ -476,6 +554,68 @@ func exec_ctags(bdata *Bufdata, headers []string, force int) int {
 	return ws.ExitStatus()
 }
 
+/*
+ * Tags just the given files, reading ctags' output from its stdout rather than
+ * the temporary file. Each file's language is picked from its name, and any
+ * that aren't in the filetype's language(s) are skipped, so that callers
+ * needn't sort out which files are relevant.
+ */
+func ctags_files(topdir *TopDir, ft *Ftdata, files []string) ([][]byte, error) {
+	argv := make([]string, 0, len(files)+32)
+	argv = append(argv, Settings.Ctags_args...)
+	if ctags_has_json() {
+		argv = append(argv, "--output-format=json", ctags_json_fields)
+	}
+	if topdir.Is_C {
+		argv = append(argv, "--languages=c,c++")
+	} else {
+		argv = append(argv, "--languages="+ft.Ctags_Name)
+	}
+	argv = append(argv, "-f-")
+
+	nfiles := 0
+	for _, file := range files {
+		if st, err := os.Stat(file); err == nil && st.Mode().IsRegular() {
+			argv = append(argv, file)
+			nfiles++
+		}
+	}
+	if nfiles == 0 {
+		return nil, nil
+	}
+
+	out, err := exec.Command("ctags", argv...).Output()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Split(out, []byte("\n")), nil
+}
+
+/*
+ * Universal-ctags built with libjansson can write JSON, which is parsed rather
+ * than split on tabs and guessed at. Exuberant ctags can't, and its tags files
+ * are still read the old way.
+ */
+func ctags_has_json() bool {
+	ctags_json.once.Do(func() {
+		out, err := exec.Command("ctags", "--version").Output()
+		ctags_json.ok = err == nil && bytes.Contains(out, []byte("+json"))
+		api.Echo("ctags JSON output supported: %v", ctags_json.ok)
+	})
+	return ctags_json.ok
+}
+
+func ctags_recurse(bdata *Bufdata, force int) bool {
+	return (force != 2) && bdata.Topdir.Recurse && !bdata.Topdir.Is_C
+}
+
+func ctags_lang_arg(bdata *Bufdata) string {
+	if bdata.Topdir.Is_C {
+		return "--languages=c,c++"
+	}
+	return "--languages-force=" + bdata.Ft.Ctags_Name
+}
+
 func get_procattr() *sys.ProcAttr {
 	dir, err := os.Getwd()
 	if err != nil {
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	sys "syscall"
+	"tag_highlight/api"
+	"tag_highlight/util"
+	"time"
+)
+
+/*
+ * Universal-ctags can be run as a coprocess with --_interactive, reading one
+ * JSON request per line on stdin and answering with JSON on stdout. Keeping one
+ * running per set of language options means that updating the tags after a
+ * write is just a request and a reply rather than a fork/exec, a temporary file
+ * and a read. The contents of the file being edited are sent straight from the
+ * buffer.
+ *
+ * The JSON tags that come back are written out one per line, just as
+ * `ctags --output-format=json` would write them, so nothing after Run_Ctags can
+ * tell the difference. If ctags
+ * doesn't understand --_interactive (an older or exuberant ctags) the worker
+ * fails its handshake, interactive mode is disabled for good, and everything
+ * goes through exec_ctags as before.
+ */
+type ctags_worker struct {
+	mutex  sync.Mutex
+	cmd    *exec.Cmd
+	stdin  *os.File
+	stdout *os.File
+	reader *bufio.Reader
+}
+
+const ctags_worker_timeout = 10 * time.Second
+
+var (
+	errNoInteractive = errors.New("ctags doesn't support interactive mode")
+
+	ctags_workers = struct {
+		sync.Mutex
+		lst         map[string]*ctags_worker
+		unsupported bool
+	}{lst: make(map[string]*ctags_worker)}
+)
+
+//========================================================================================
+
+/*
+ * Generate tags for the buffer and any headers with the worker for the
+ * buffer's filetype, starting it if need be. A worker that misbehaves in any
+ * way is killed and started afresh next time.
+ */
+func run_ctags_interactive(bdata *Bufdata, headers []string) ([][]byte, error) {
+	lang := ctags_lang_arg(bdata)
+	worker, err := get_ctags_worker(lang)
+	if err != nil {
+		return nil, err
+	}
+
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+
+	tags, err := worker.generate(bdata.Filename, bdata.Lines.Join([]byte("\n")))
+	for i := 0; err == nil && i < len(headers); i++ {
+		var more [][]byte
+		if more, err = worker.generate(headers[i], nil); err == nil {
+			tags = append(tags, more...)
+		}
+	}
+	if err != nil {
+		kill_ctags_worker(lang, worker)
+		return nil, err
+	}
+
+	sort.Slice(tags, func(i, j int) bool { return bytes.Compare(tags[i], tags[j]) < 0 })
+	return tags, nil
+}
+
+func get_ctags_worker(lang string) (*ctags_worker, error) {
+	ctags_workers.Lock()
+	defer ctags_workers.Unlock()
+
+	if ctags_workers.unsupported {
+		return nil, errNoInteractive
+	}
+	if worker := ctags_workers.lst[lang]; worker != nil {
+		return worker, nil
+	}
+
+	worker, err := start_ctags_worker(lang)
+	if err != nil {
+		if err == errNoInteractive {
+			ctags_workers.unsupported = true
+			api.Echo("ctags doesn't support --_interactive, falling back to running it for every update")
+		}
+		return nil, err
+	}
+
+	ctags_workers.lst[lang] = worker
+	return worker, nil
+}
+
+func kill_ctags_worker(lang string, worker *ctags_worker) {
+	ctags_workers.Lock()
+	if ctags_workers.lst[lang] == worker {
+		delete(ctags_workers.lst, lang)
+	}
+	ctags_workers.Unlock()
+	worker.close()
+}
+
+// Stop every ctags worker. Called before exiting.
+func stop_ctags_workers() {
+	ctags_workers.Lock()
+	defer ctags_workers.Unlock()
+
+	for lang, worker := range ctags_workers.lst {
+		worker.close()
+		delete(ctags_workers.lst, lang)
+	}
+}
+
+//========================================================================================
+
+func start_ctags_worker(lang string) (*ctags_worker, error) {
+	args := make([]string, 0, len(Settings.Ctags_args)+8)
+	args = append(args, "--_interactive", "--output-format=json")
+	args = append(args, Settings.Ctags_args...)
+	args = append(args, ctags_json_fields, lang)
+
+	in_r, in_w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	out_r, out_w, err := os.Pipe()
+	if err != nil {
+		in_r.Close()
+		in_w.Close()
+		return nil, err
+	}
+
+	cmd := exec.Command("ctags", args...)
+	cmd.Stdin = in_r
+	cmd.Stdout = out_w
+	err = cmd.Start()
+	in_r.Close()
+	out_w.Close()
+	if err != nil {
+		in_w.Close()
+		out_r.Close()
+		return nil, err
+	}
+
+	worker := &ctags_worker{
+		cmd:    cmd,
+		stdin:  in_w,
+		stdout: out_r,
+		reader: bufio.NewReader(out_r),
+	}
+
+	/* The first thing it says is what it is. Anything else (including
+	 * nothing at all, because it exited complaining about the option) means
+	 * interactive mode isn't going to work. */
+	_, rec, err := worker.read_record()
+	if err != nil || rec["_type"] != "program" {
+		worker.close()
+		return nil, errNoInteractive
+	}
+
+	api.Echo("Started ctags worker (pid %d, %v %v) with '%s'",
+		cmd.Process.Pid, rec["name"], rec["version"], lang)
+	return worker, nil
+}
+
+func (worker *ctags_worker) close() {
+	worker.stdin.Close()
+	worker.stdout.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- worker.cmd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		worker.cmd.Process.Kill()
+		<-done
+	}
+}
+
+/*
+ * Ask for the tags of one file. With contents, ctags parses those instead of
+ * reading the file, although the tags still name it as their path.
+ */
+func (worker *ctags_worker) generate(filename string, contents []byte) ([][]byte, error) {
+	req := map[string]interface{}{
+		"command":  "generate-tags",
+		"filename": filename,
+	}
+	if contents != nil {
+		req["size"] = len(contents)
+	}
+	msg, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	worker.stdin.SetWriteDeadline(time.Now().Add(ctags_worker_timeout))
+	msg = append(msg, '\n')
+	if _, err = worker.stdin.Write(append(msg, contents...)); err != nil {
+		return nil, err
+	}
+
+	tags := make([][]byte, 0, 256)
+	for {
+		line, rec, err := worker.read_record()
+		if err != nil {
+			return nil, err
+		}
+
+		switch rec["_type"] {
+		case "tag":
+			tags = append(tags, bytes.TrimSpace(line))
+		case "completed":
+			return tags, nil
+		case "error":
+			return nil, fmt.Errorf("ctags: %v", rec["message"])
+		}
+	}
+}
+
+/* Returns the line as well as what's in it. */
+func (worker *ctags_worker) read_record() ([]byte, map[string]interface{}, error) {
+	worker.stdout.SetReadDeadline(time.Now().Add(ctags_worker_timeout))
+
+	line, err := worker.reader.ReadBytes('\n')
+	if err != nil {
+		return nil, nil, err
+	}
+	var rec map[string]interface{}
+	if err = json.Unmarshal(line, &rec); err != nil {
+		return nil, nil, fmt.Errorf("ctags: malformed output '%s': %s", bytes.TrimSpace(line), err)
+	}
+	return line, rec, nil
+}
+
+//========================================================================================
+
+/* Replaces the contents of the temporary file with the given tags. */
+func (topdir *TopDir) write_tmpfile_lines(tags [][]byte) error {
+	tmp_mutex.Lock()
+	defer tmp_mutex.Unlock()
+
+	if topdir.Tmpfd == (-1) {
+		return errors.New("File not open")
+	}
+	if err := sys.Ftruncate(int(topdir.Tmpfd), 0); err != nil {
+		return err
+	}
+	buf := bytes.Join(tags, []byte("\n"))
+	if _, err := sys.Pwrite(int(topdir.Tmpfd), buf, 0); err != nil {
+		util.Warn("Error writing temporary file: %s", err)
+		return err
+	}
+	return nil
+}
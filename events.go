@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	// "runtime/pprof"
@@ -26,6 +27,16 @@ type event_id struct {
 	id   int
 }
 
+/* The arguments of nvim_buf_lines_event, in the order neovim sends them. */
+type line_event struct {
+	Bufnum int
+	Ctick  uint32
+	First  int
+	Last   int
+	Lines  []string
+	More   bool
+}
+
 var (
 	event_list = [4]event_id{
 		{"nvim_buf_lines_event", event_BUF_LINES},
@@ -38,105 +49,112 @@ var (
 
 //========================================================================================
 
-func handle_nvim_event(event *mpack.Object) {
+func handle_nvim_event(event *mpack.Object) error {
 	event_mutex.Lock()
 	defer event_mutex.Unlock()
 
-	etype := id_event(event)
+	etype, err := id_event(event)
+	if err != nil {
+		return err
+	}
 	info := event.Index(2)
+	if info.Mtype != mpack.T_ARRAY || info.Len() == 0 {
+		return fmt.Errorf("Malformed arguments for event '%s'", etype.name)
+	}
 
 	if etype.id == event_VIM_UPDATE {
-		go interrupt_call(rune(info.Index(0).Expect(mpack.E_STRING).(string)[0]))
-	} else {
-		bufnum := int(info.Index(0).Expect(mpack.T_NUM).(int64))
-		bdata := Find_Buffer(bufnum)
-		if bdata == nil {
-			panic("Update called on an uninitialized buffer.")
+		val, err := info.Index(0).Expect(mpack.E_STRING)
+		if err != nil {
+			return err
 		}
+		if str, _ := val.(string); len(str) > 0 {
+			go interrupt_call(rune(str[0]))
+		}
+		return nil
+	}
+
+	val, err := info.Index(0).Expect(mpack.T_NUM)
+	if err != nil {
+		return err
+	}
+	bufnum := int(val.(int64))
+	bdata := Find_Buffer(bufnum)
+	if bdata == nil {
+		return fmt.Errorf("Event '%s' for unknown buffer %d", etype.name, bufnum)
+	}
 
-		switch etype.id {
-		case event_BUF_LINES:
-			handle_line_event(bdata, info)
-		case event_BUF_CHANGED_TICK:
-			bdata.Ctick = uint32(info.Index(1).Expect(mpack.T_NUM).(int64))
-		case event_BUF_DETACH:
-			api.Echo("Detaching from buffer %d", bufnum)
-			Remove_Buffer(bufnum)
+	switch etype.id {
+	case event_BUF_LINES:
+		return handle_line_event(bdata, info)
+	case event_BUF_CHANGED_TICK:
+		if info.Len() < 2 {
+			return fmt.Errorf("Malformed arguments for event '%s'", etype.name)
 		}
+		bdata.Ctick = uint32(info.Index(1).Get_Int64())
+	case event_BUF_DETACH:
+		api.Echo("Detaching from buffer %d", bufnum)
+		Remove_Buffer(bufnum)
 	}
+
+	return nil
 }
 
 //========================================================================================
 
-func handle_line_event(bdata *Bufdata, data *mpack.Object) {
-	if data.Index(5).Expect(mpack.T_BOOL).(bool) {
-		panic("Somehow the boolean argument is true!")
+func handle_line_event(bdata *Bufdata, data *mpack.Object) error {
+	var ev line_event
+	if data.Len() != 6 {
+		return fmt.Errorf("Line event has %d arguments, expected 6", data.Len())
+	}
+	if err := data.Unmarshal(&ev); err != nil {
+		return fmt.Errorf("Malformed line event: %s", err)
+	}
+	if ev.More {
+		return errors.New("Got a line event with 'more' set, which is not supported")
 	}
 	if write_buf_updates {
 		write_lines(bdata.Lines)
 	}
 
-	bdata.Ctick = uint32(data.Index(1).Expect(mpack.T_NUM).(int64))
+	bdata.Ctick = ev.Ctick
 	var (
-		first     = int(data.Index(2).Expect(mpack.T_NUM).(int64))
-		last      = int(data.Index(3).Expect(mpack.T_NUM).(int64))
-		repl_list = data.Index(4).Expect(mpack.E_STRLIST).([]string)
-		diff      = last - first
-		iters     = util.Max_Int(diff, len(repl_list))
+		first     = ev.First
+		last      = ev.Last
+		repl_list = ev.Lines
 		empty     = false
 	)
 
-	insert_slice := func(i int) {
-		/* If the first line not being replaced (first + i) is at the end
-		 * of the file, then we append. Otherwise the update is prepended. */
-		if (first) >= bdata.Lines.Qty {
-			bdata.Lines.Insert_Slice_After_At(first+i, sl2i(repl_list[i:])...)
-		} else {
-			bdata.Lines.Insert_Slice_Before_At(first+i, sl2i(repl_list[i:])...)
-		}
+	if len(repl_list) > 0 && last == (-1) {
+		return errors.New("Got a line event with an invalid last line")
+	}
+	/* The tree panics on a bad range, and nothing above us recovers. */
+	if first < 0 || last < (-1) || (last >= 0 && first > last) {
+		return fmt.Errorf("Line event with an invalid range (%d to %d)", first, last)
+	}
+	if last > bdata.Lines.Len() || first > bdata.Lines.Len() {
+		return fmt.Errorf("Line event for lines %d to %d, but the buffer only has %d",
+			first, last, bdata.Lines.Len())
 	}
 
-	if len(repl_list) > 0 {
-		if last == (-1) {
-			panic("WHAA")
-		} else if bdata.Lines.Qty <= 1 && first == 0 && len(repl_list) == 1 && len(repl_list[0]) == 0 {
-			/* Useless update, one empty string in an empty buffer. Just ignore it. */
-			empty = true
-		} else if first == 0 && last == 0 {
-			/* Inserting above the first line in the file. */
-			bdata.Lines.Insert_Slice_Before_At(first, sl2i(repl_list)...)
-		} else if first == last {
-			insert_slice(0)
-		} else {
-			olen := bdata.Lines.Qty
-			/* This loop is only meaningful when replacing lines.
-			 * All other paths break after the first iteration. */
-			for i := 0; i < iters; i++ {
-				if diff > 0 && i < olen {
-					diff--
-					if i < len(repl_list) {
-						bdata.Lines.Replace_At(first+i, repl_list[i])
-					} else {
-						bdata.Lines.Delete_Range_At(first+i, diff+1)
-						break
-					}
-				} else {
-					insert_slice(i)
-					break
-				}
-			}
-		}
+	if first == 0 && last == 1 && len(repl_list) == 1 && len(repl_list[0]) == 0 &&
+		bdata.Lines.Len() == 1 && bdata.Lines.At(0) == "" {
+		/* Useless update, one empty string in an empty buffer. Just ignore it. */
+		empty = true
 	} else {
 		/* If the replacement list is empty then all we're doing is deleting
-		 * lines. However, for some reason neovim sometimes sends updates with an
-		 * empty list in which both the first and last line are the same. God
-		 * knows what this is supposed to indicate. I'll just ignore them. */
-		bdata.Lines.Delete_Range_At(first, diff)
+		 * lines. Neovim sometimes sends updates with an empty list in which
+		 * both the first and last line are the same, which do nothing. */
+		bdata.Lines.Replace(first, last, repl_list)
+	}
+
+	if !empty {
+		bdata.Tokens.Replace(first, last, repl_list)
+		bdata.viewport_lines_changed(first, last, len(repl_list))
 	}
 
 	/* Neovim always considers there to be at least one line in any buffer.
 	 * An empty buffer therefore must have one empty line. */
-	if bdata.Lines.Qty == 0 {
+	if bdata.Lines.Len() == 0 {
 		bdata.Lines.Append("")
 	}
 	/* If the replace list wasn't empty, set the buffer as initialized. */
@@ -149,51 +167,63 @@ func handle_line_event(bdata *Bufdata, data *mpack.Object) {
 			write_lines(bdata.Lines)
 		}
 
-		if !bdata.Lines.Verify_Size() {
-			panic("Linked list size verification failed.")
+		if n := bdata.Tokens.Lines(); n != bdata.Lines.Len() {
+			return fmt.Errorf("Token cache has %d lines, but the buffer has %d", n, bdata.Lines.Len())
 		}
 
-		if ctick := api.Nvim_buf_get_changedtick(0, int(bdata.Num)); ctick == int(bdata.Ctick) {
-			if n := api.Nvim_buf_line_count(0, int(bdata.Num)); n != bdata.Lines.Qty {
-				panic(fmt.Sprintf("Recorded size (%d) is incorrect, actual value"+
-					" is (%d), cannot continue.", bdata.Lines.Qty, n))
+		if ctick, err := api.Nvim_buf_get_changedtick(0, int(bdata.Num)); err == nil && ctick == int(bdata.Ctick) {
+			if n, err := api.Nvim_buf_line_count(0, int(bdata.Num)); err == nil && n != bdata.Lines.Len() {
+				return fmt.Errorf("Recorded size (%d) is incorrect, actual value is (%d)",
+					bdata.Lines.Len(), n)
 			}
 		}
 	}
+
+	return nil
 }
 
 //========================================================================================
 
-func id_event(event *mpack.Object) *event_id {
-	name := event.Index(1).Expect(mpack.E_STRING).(string)
+func id_event(event *mpack.Object) (*event_id, error) {
+	if event.Len() != 3 {
+		return nil, errors.New("Malformed notification")
+	}
+	name := event.Index(1).Get_String()
 
 	for i, item := range event_list {
 		if name == item.name {
-			return &event_list[i]
+			return &event_list[i], nil
 		}
 	}
 
-	panic("Failed to identify event type.")
+	return nil, fmt.Errorf("Failed to identify event type '%s'", name)
 }
 
-func write_lines(list *lists.Linked_List) {
-	api.Echo("Writing, cur size is %d", list.Qty)
-	tmpfile := api.Nvim_call_function(0, []byte("tempname"), mpack.E_STRING).(string)
+func write_lines(list *lists.Line_Tree) {
+	api.Echo("Writing, cur size is %d", list.Len())
+	tmpfile, err := get_tempname(0)
+	if err != nil {
+		util.Eprintf("%s\n", err)
+		return
+	}
 	file := util.Safe_Fopen(tmpfile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_SYNC, 0600)
 	defer file.Close()
 
-	i := 0
-	for cur := list.Head; cur != nil; cur = cur.Next {
-		file.WriteString(fmt.Sprintf("%d:\t%s\n", i, cur.Data.(string)))
-		i++
-	}
+	list.Each(func(i int, line string) bool {
+		file.WriteString(fmt.Sprintf("%d:\t%s\n", i, line))
+		return true
+	})
 
 	api.Echo("Done writing file - %s.", tmpfile)
 }
 
 func write_buf(buf *[]string) {
 	api.Echo("Writing, cur size is %d", len(*buf))
-	tmpfile := api.Nvim_call_function(0, []byte("tempname"), mpack.E_STRING).(string)
+	tmpfile, err := get_tempname(0)
+	if err != nil {
+		util.Eprintf("%s\n", err)
+		return
+	}
 	file := util.Safe_Fopen(tmpfile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 	defer file.Close()
 
@@ -202,23 +232,19 @@ func write_buf(buf *[]string) {
 	}
 }
 
-func sl2i(list []string) []interface{} {
-	ret := make([]interface{}, len(list))
-
-	for i := range list {
-		ret[i] = list[i]
-	}
-
-	return ret
-}
-
 //========================================================================================
 
 var (
 	bufnum int = (-1)
 )
 
+/*
+ * Runs on its own goroutine, but holds event_mutex like everything else that
+ * touches buffers, so it never runs alongside a line event or the watcher.
+ */
 func interrupt_call(val rune) {
+	event_mutex.Lock()
+	defer event_mutex.Unlock()
 	api.Echo("Recieved \"%c\", waking up!", val)
 
 	switch val {
@@ -228,7 +254,9 @@ func interrupt_call(val rune) {
 		timer := util.NewTimer()
 
 		prev := bufnum
-		bufnum = api.Nvim_get_current_buf(0)
+		if !update_current_buf() {
+			break
+		}
 		bdata := Find_Buffer(bufnum)
 
 		if bdata == nil {
@@ -251,7 +279,9 @@ func interrupt_call(val rune) {
 		// fsleep(0.08)
 		// tv1 := time.Now()
 		timer := util.NewTimer()
-		bufnum = api.Nvim_get_current_buf(0)
+		if !update_current_buf() {
+			break
+		}
 		bdata := Find_Buffer(bufnum)
 
 		if bdata == nil {
@@ -267,14 +297,32 @@ func interrupt_call(val rune) {
 		// util.Warn("Update time: %f", util.Tdiff(&tv1, &tv2))
 		timer.EchoReport("update")
 
+	case 'S':
+		/* Sent when a window scrolls. Only the viewport backend cares. */
+		if use_viewport() && update_current_buf() {
+			if bdata := Find_Buffer(bufnum); bdata != nil {
+				bdata.Update_Viewport()
+			}
+		}
+
 	case 'C':
 		// TODO clear highlight
 		// TODO kill parent id and/or gracefully die
 
 		// pprof.StopCPUProfile()
+		stop_ctags_workers()
 		os.Exit(0)
 
 	case 'E':
+		if use_extmarks() && update_current_buf() {
+			if bdata := Find_Buffer(bufnum); bdata != nil {
+				if err := bdata.Clear_Highlight(); err != nil {
+					util.Eprintf("Failed to clear highlight: %s\n", err)
+				}
+				bdata.forget_viewport()
+				break
+			}
+		}
 		// TODO clear highlight
 		fallthrough
 
@@ -283,12 +331,25 @@ func interrupt_call(val rune) {
 	}
 }
 
+func update_current_buf() bool {
+	num, err := api.Nvim_get_current_buf(0)
+	if err != nil {
+		util.Eprintf("Failed to get the current buffer: %s\n", err)
+		return false
+	}
+	bufnum = num
+	return true
+}
+
 func do_attach(bnum int) *Bufdata {
 	bdata := New_Buffer(0, bufnum)
 	timer := util.NewTimer()
 
 	if bdata != nil {
-		api.Nvim_buf_attach(1, bufnum)
+		if err := api.Nvim_buf_attach(event_fd, bufnum); err != nil {
+			util.Eprintf("Failed to attach to buffer %d: %s\n", bufnum, err)
+			return nil
+		}
 
 		bdata.get_initial_lines()
 		bdata.Get_Initial_Taglist()
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"reflect"
+	"tag_highlight/api"
+	"tag_highlight/lists"
+	"tag_highlight/nvimtest"
+	"tag_highlight/scan"
+	"testing"
+	"time"
+)
+
+/*
+ * A buffer for the fake to send events about, set up as New_Buffer would but
+ * without a real project behind it. Its filetype is a copy, so that nothing
+ * the tests do to it sticks to the global table.
+ */
+func new_test_buffer(t *testing.T, f *nvimtest.Fake, name, ft_name string, lines ...string) *Bufdata {
+	t.Helper()
+	bufnum := f.Add_Buffer(name, ft_name, lines...)
+	ft := *id_filetype(ft_name)
+
+	bdata := &Bufdata{
+		Filename: name,
+		Ft:       &ft,
+		Num:      uint16(bufnum),
+		Lines:    lists.New_Line_Tree(""),
+		Tokens:   scan.New_Token_Cache(int(ft.Id)),
+		Topdir:   &TopDir{Id: ft.Id, ft: &ft, Is_C: ft.Id == FT_C || ft.Id == FT_CPP},
+	}
+	bdata.get_initial_lines()
+
+	buffers.lst[buffers.mkr] = bdata
+	buffers.mkr++
+	t.Cleanup(func() {
+		if index, _ := find_buffer_index(bufnum); index >= 0 {
+			buffers.lst[index] = nil
+		}
+	})
+	return bdata
+}
+
+func new_test_fake(t *testing.T) *nvimtest.Fake {
+	t.Helper()
+	f := nvimtest.New_Fake()
+	f.Install()
+	t.Cleanup(f.Close)
+	return f
+}
+
+/* Pushes a change through the fake and hands the event to the main loop's handler. */
+func push_lines(t *testing.T, f *nvimtest.Fake, bdata *Bufdata, first, last int, lines ...string) error {
+	t.Helper()
+	events, err := api.Notifications(f.Fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go f.Push_Lines(int(bdata.Num), first, last, lines, false)
+
+	select {
+	case ev := <-events:
+		return handle_nvim_event(ev)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+	return nil
+}
+
+func TestHandleLineEvent(t *testing.T) {
+	f := new_test_fake(t)
+	bdata := new_test_buffer(t, f, "/tmp/thl_events.c", "c", "int a;", "int b;", "int c;")
+
+	edits := []struct {
+		first, last int
+		lines       []string
+	}{
+		{1, 2, []string{"int x;", "int y;"}},        // replace
+		{0, 0, []string{"#include <stdio.h>"}},      // insert at the top
+		{5, 5, []string{"int z;"}},                  // append
+		{2, 4, nil},                                 // delete
+		{0, 4, []string{""}},                        // clear
+		{0, 1, []string{"int only;", "int again;"}}, // and fill again
+	}
+
+	for i, e := range edits {
+		if err := push_lines(t, f, bdata, e.first, e.last, e.lines...); err != nil {
+			t.Fatalf("edit %d: %s", i, err)
+		}
+		want := f.Buffer(int(bdata.Num)).Lines
+		if got := bdata.Lines.Slice(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("edit %d: buffer is %q, want %q", i, got, want)
+		}
+		if n := bdata.Tokens.Lines(); n != len(want) {
+			t.Fatalf("edit %d: token cache has %d lines, want %d", i, n, len(want))
+		}
+		if bdata.Ctick != uint32(f.Buffer(int(bdata.Num)).Ctick) {
+			t.Errorf("edit %d: changedtick is %d, want %d", i, bdata.Ctick, f.Buffer(int(bdata.Num)).Ctick)
+		}
+	}
+}
+
+func TestHandleLineEventOutOfRange(t *testing.T) {
+	f := new_test_fake(t)
+	bdata := new_test_buffer(t, f, "/tmp/thl_range.c", "c", "int a;", "int b;")
+
+	events, err := api.Notifications(f.Fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := []struct {
+		first, last int
+	}{
+		{5, 6},  // past the end
+		{-1, 1}, // negative first
+		{2, 1},  // first after last
+		{1, -2}, // last neither a line nor -1
+	}
+
+	for _, b := range bad {
+		go f.Push("nvim_buf_lines_event", int(bdata.Num), 1, b.first, b.last, []string{"int c;"}, false)
+
+		if err := handle_nvim_event(<-events); err == nil {
+			t.Errorf("lines %d to %d: expected an error", b.first, b.last)
+		}
+		if got := bdata.Lines.Slice(); !reflect.DeepEqual(got, []string{"int a;", "int b;"}) {
+			t.Fatalf("lines %d to %d: buffer changed to %q", b.first, b.last, got)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sync"
+	"tag_highlight/api"
+	"tag_highlight/scan"
+)
+
+const ( // Highlight backends (the tag_highlight#backend setting)
+	BACKEND_SYNTAX   = "syntax"
+	BACKEND_EXTMARK  = "extmark"
+	BACKEND_VIEWPORT = "viewport"
+)
+
+var (
+	hl_namespace    int64 = (-1)
+	namespace_mutex sync.Mutex
+)
+
+//========================================================================================
+
+/*
+ * The syntax backend defines a `syntax keyword` (or match) group per kind with
+ * every tag in it, which is what the plugin has always done. The extmark
+ * backend instead finds each occurrence of a tag in the buffer itself and
+ * highlights it in a namespace of its own, which leaves the filetype's syntax
+ * alone and makes Restore_Cmds unnecessary. The viewport backend does the same
+ * but only for the part of the buffer that is on screen (see viewport.go).
+ */
+func get_backend(name string) string {
+	switch name {
+	case "", BACKEND_SYNTAX:
+		return BACKEND_SYNTAX
+	case BACKEND_EXTMARK, "extmarks":
+		return BACKEND_EXTMARK
+	case BACKEND_VIEWPORT:
+		return BACKEND_VIEWPORT
+	default:
+		api.Echo("Warning: unrecognized highlight backend \"%s\", defaulting to syntax.", name)
+		return BACKEND_SYNTAX
+	}
+}
+
+func use_extmarks() bool {
+	return Settings.Backend == BACKEND_EXTMARK || Settings.Backend == BACKEND_VIEWPORT
+}
+
+func get_namespace() (int64, error) {
+	namespace_mutex.Lock()
+	defer namespace_mutex.Unlock()
+
+	if hl_namespace == (-1) {
+		ns, err := api.New_Client(0).Nvim_create_namespace("tag_highlight")
+		if err != nil {
+			return (-1), err
+		}
+		hl_namespace = ns
+	}
+
+	return hl_namespace, nil
+}
+
+//========================================================================================
+
+/*
+ * Builds the atomic call list for the extmark backend: clear the namespace,
+ * then add a highlight for every occurrence of a tag. Doing both in one
+ * nvim_call_atomic means there's never a moment where the buffer is shown with
+ * no highlighting at all.
+ */
+func (bdata *Bufdata) update_extmarks(tags []scan.Tag) error {
+	ns, err := get_namespace()
+	if err != nil {
+		return err
+	}
+
+	groups := make(map[byte][]byte, len(bdata.Ft.Order))
+	for _, info := range bdata.get_cmd_info() {
+		if info.group != nil {
+			groups[info.kind] = info.group
+		}
+	}
+
+	if use_viewport() {
+		bdata.Calls, err = bdata.reset_viewport(tags, groups)
+		return err
+	}
+
+	positions := bdata.Make_Scan_Struct().Find_Positions(tags)
+	bdata.Calls = &api.Atomic_list{Calls: make([]api.Atomic_call, 0, len(positions)+1)}
+	bdata.Calls.Nvim_buf_clear_namespace(int(bdata.Num), ns, 0, (-1))
+
+	for _, pos := range positions {
+		if group := groups[pos.Kind]; group != nil {
+			bdata.Calls.Nvim_buf_add_highlight(int(bdata.Num), ns, group, pos.Line, pos.Start, pos.End)
+		}
+	}
+
+	api.Echo("Placing %d highlights", len(bdata.Calls.Calls)-1)
+	return nil
+}
+
+// Clear_Highlight removes every highlight the extmark backend placed in the
+// buffer.
+func (bdata *Bufdata) Clear_Highlight() error {
+	ns, err := get_namespace()
+	if err != nil {
+		return err
+	}
+	return api.New_Client(0).Nvim_buf_clear_namespace(int(bdata.Num), ns, 0, (-1))
+}
@@ -0,0 +1,392 @@
+/*
+Package gotags generates tags for Go source with go/parser rather than ctags.
+
+Ctags' Go parser has trouble with methods on generic types, embedded
+interfaces and the like, none of which are any problem for the real parser.
+The tags are the same records ctags output is parsed into, with the kind
+letters universal-ctags uses for Go, so nothing downstream needs to know where
+they came from.
+*/
+package gotags
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"tag_highlight/scan"
+	"time"
+)
+
+// Kind letters, as universal-ctags uses them for Go.
+const (
+	K_PACKAGE      = 'p'
+	K_FUNC         = 'f'
+	K_CONST        = 'c'
+	K_TYPE         = 't'
+	K_VAR          = 'v'
+	K_STRUCT       = 's'
+	K_INTERFACE    = 'i'
+	K_MEMBER       = 'm'
+	K_ANON_MEMBER  = 'M'
+	K_METHOD_SPEC  = 'n'
+	K_ALIAS        = 'a'
+	K_PACKAGE_NAME = 'P'
+)
+
+/* Directories that never hold code that's part of the package. */
+var skip_dirs = map[string]bool{"testdata": true, "vendor": true, "node_modules": true}
+
+/* Tags of dependencies, which hardly ever change. Keyed by directory. */
+var dep_cache = struct {
+	sync.Mutex
+	lst map[string]dep_cache_entry
+}{lst: make(map[string]dep_cache_entry)}
+
+type dep_cache_entry struct {
+	mtime time.Time
+	tags  []scan.Tag_Record
+}
+
+//========================================================================================
+
+/*
+ * Tag_Dir tags every Go file in dir, and with recurse every directory below it
+ * for which skip (which may be nil) returns false. Files in contents are parsed
+ * from there instead of from disk, which is how an unsaved buffer is handled.
+ */
+func Tag_Dir(dir string, recurse bool, skip func(string) bool, contents map[string][]byte) []scan.Tag_Record {
+	var (
+		fset = token.NewFileSet()
+		tags = make([]scan.Tag_Record, 0, 1024)
+	)
+	for _, path := range go_files(dir, recurse, skip) {
+		if file := parse_file(fset, path, contents[path], 0); file != nil {
+			tags = tag_file(fset, file, path, false, tags)
+		}
+	}
+	return tags
+}
+
+/*
+ * Imports returns the import paths of the same files Tag_Dir would tag, sorted.
+ * Only the imports are parsed, so it's cheap enough to do on every write.
+ */
+func Imports(dir string, recurse bool, skip func(string) bool, contents map[string][]byte) []string {
+	var (
+		fset    = token.NewFileSet()
+		imports = make(map[string]bool, 32)
+	)
+	for _, path := range go_files(dir, recurse, skip) {
+		file := parse_file(fset, path, contents[path], parser.ImportsOnly)
+		if file == nil {
+			continue
+		}
+		for _, imp := range file.Imports {
+			if p, err := strconv.Unquote(imp.Path.Value); err == nil {
+				imports[p] = true
+			}
+		}
+	}
+
+	ret := make([]string, 0, len(imports))
+	for p := range imports {
+		ret = append(ret, p)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func go_files(dir string, recurse bool, skip func(string) bool) []string {
+	files := make([]string, 0, 64)
+
+	filepath.WalkDir(dir, func(path string, ent os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ent.IsDir() {
+			name := ent.Name()
+			if path != dir && (!recurse || skip_dirs[name] || strings.HasPrefix(name, ".") ||
+				(skip != nil && skip(path))) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, ".go") {
+			files = append(files, path)
+		}
+		return nil
+	})
+
+	return files
+}
+
+/*
+ * Tag_Files tags just the given files, for when only a few of them have
+ * changed. Files that no longer exist are skipped.
+ */
+func Tag_Files(paths []string) []scan.Tag_Record {
+	var (
+		fset = token.NewFileSet()
+		tags = make([]scan.Tag_Record, 0, 256)
+	)
+	for _, path := range paths {
+		if !strings.HasSuffix(path, ".go") {
+			continue
+		}
+		if file := parse_file(fset, path, nil, 0); file != nil {
+			tags = tag_file(fset, file, path, false, tags)
+		}
+	}
+	return tags
+}
+
+/*
+ * Tag_Imports tags the exported identifiers of the given packages, found with
+ * `go list` run from dir so that the module's own dependencies (in the module
+ * cache) are the ones found.
+ */
+func Tag_Imports(dir string, imports []string) ([]scan.Tag_Record, error) {
+	if len(imports) == 0 {
+		return nil, nil
+	}
+	args := append([]string{"list", "-e", "-f", "{{.Dir}}"}, imports...)
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]scan.Tag_Record, 0, 4096)
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		if pkgdir := string(bytes.TrimSpace(line)); pkgdir != "" {
+			tags = append(tags, tag_dep(pkgdir)...)
+		}
+	}
+	return tags, nil
+}
+
+func tag_dep(dir string) []scan.Tag_Record {
+	st, err := os.Stat(dir)
+	if err != nil {
+		return nil
+	}
+
+	dep_cache.Lock()
+	defer dep_cache.Unlock()
+	if ent, ok := dep_cache.lst[dir]; ok && ent.mtime.Equal(st.ModTime()) {
+		return ent.tags
+	}
+
+	var (
+		fset = token.NewFileSet()
+		tags = make([]scan.Tag_Record, 0, 256)
+	)
+	ents, _ := os.ReadDir(dir)
+	for _, ent := range ents {
+		name := ent.Name()
+		if ent.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if file := parse_file(fset, path, nil, 0); file != nil {
+			tags = tag_file(fset, file, path, true, tags)
+		}
+	}
+
+	dep_cache.lst[dir] = dep_cache_entry{st.ModTime(), tags}
+	return tags
+}
+
+func parse_file(fset *token.FileSet, path string, src []byte, mode parser.Mode) *ast.File {
+	var s interface{}
+	if src != nil {
+		s = src
+	}
+	/* A file with errors still gives back whatever could be parsed. */
+	file, _ := parser.ParseFile(fset, path, s, mode|parser.SkipObjectResolution)
+	return file
+}
+
+//========================================================================================
+
+type tagger struct {
+	fset          *token.FileSet
+	path          string
+	exported_only bool
+	tags          []scan.Tag_Record
+}
+
+func tag_file(fset *token.FileSet, file *ast.File, path string, exported_only bool, tags []scan.Tag_Record) []scan.Tag_Record {
+	t := &tagger{fset, path, exported_only, tags}
+
+	if !exported_only {
+		t.add(file.Name, K_PACKAGE, "", "")
+		for _, imp := range file.Imports {
+			t.add_import(imp)
+		}
+	}
+
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			scope := ""
+			if decl.Recv != nil && len(decl.Recv.List) > 0 {
+				scope = base_type_name(decl.Recv.List[0].Type)
+			}
+			if scope == "" {
+				t.add(decl.Name, K_FUNC, "", "")
+			} else {
+				t.add(decl.Name, K_FUNC, scope, "type")
+			}
+
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					t.add_type(spec)
+				case *ast.ValueSpec:
+					kind := byte(K_VAR)
+					if decl.Tok == token.CONST {
+						kind = K_CONST
+					}
+					for _, name := range spec.Names {
+						t.add(name, kind, "", "")
+					}
+				}
+			}
+		}
+	}
+
+	return t.tags
+}
+
+func (t *tagger) add_type(spec *ast.TypeSpec) {
+	name := spec.Name.Name
+
+	switch typ := spec.Type.(type) {
+	case *ast.StructType:
+		t.add(spec.Name, K_STRUCT, "", "")
+		for _, field := range typ.Fields.List {
+			if len(field.Names) == 0 {
+				t.add_embedded(field.Type, name, "struct")
+			}
+			for _, fname := range field.Names {
+				t.add(fname, K_MEMBER, name, "struct")
+			}
+		}
+
+	case *ast.InterfaceType:
+		t.add(spec.Name, K_INTERFACE, "", "")
+		for _, method := range typ.Methods.List {
+			if len(method.Names) == 0 {
+				/* An embedded interface, or a type constraint. */
+				t.add_embedded(method.Type, name, "interface")
+			}
+			for _, mname := range method.Names {
+				t.add(mname, K_METHOD_SPEC, name, "interface")
+			}
+		}
+
+	default:
+		if spec.Assign.IsValid() {
+			t.add(spec.Name, K_ALIAS, "", "")
+		} else {
+			t.add(spec.Name, K_TYPE, "", "")
+		}
+	}
+}
+
+/* An embedded field or interface is named for its type, less any package. */
+func (t *tagger) add_embedded(expr ast.Expr, scope, scope_kind string) {
+	if id := base_type_ident(expr); id != nil {
+		t.add(id, K_ANON_MEMBER, scope, scope_kind)
+	}
+}
+
+func (t *tagger) add_import(imp *ast.ImportSpec) {
+	path, err := strconv.Unquote(imp.Path.Value)
+	if err != nil {
+		return
+	}
+	if imp.Name != nil {
+		if imp.Name.Name != "_" && imp.Name.Name != "." {
+			t.add(imp.Name, K_PACKAGE_NAME, "", "")
+		}
+		return
+	}
+
+	/* Without parsing the package this is only a guess, but it's right for
+	 * all but a few packages, the ones with a version suffix included. */
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && is_major_version(name) {
+		name = elems[len(elems)-2]
+	}
+	t.add(&ast.Ident{Name: name, NamePos: imp.Path.Pos()}, K_PACKAGE_NAME, "", "")
+}
+
+func (t *tagger) add(id *ast.Ident, kind byte, scope, scope_kind string) {
+	if id == nil || id.Name == "_" || (t.exported_only && !id.IsExported()) {
+		return
+	}
+	t.tags = append(t.tags, scan.Tag_Record{
+		Name:       id.Name,
+		Path:       t.path,
+		Language:   "Go",
+		Scope:      scope,
+		Scope_Kind: scope_kind,
+		Line:       t.fset.Position(id.Pos()).Line,
+		Kind:       kind,
+	})
+}
+
+//========================================================================================
+
+func base_type_name(expr ast.Expr) string {
+	if id := base_type_ident(expr); id != nil {
+		return id.Name
+	}
+	return ""
+}
+
+/*
+ * Strips pointers, type arguments and package qualifiers from a type:
+ * *pkg.List[K, V] gives List.
+ */
+func base_type_ident(expr ast.Expr) *ast.Ident {
+	for {
+		switch e := expr.(type) {
+		case *ast.Ident:
+			return e
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.SelectorExpr:
+			return e.Sel
+		default:
+			return nil
+		}
+	}
+}
+
+func is_major_version(s string) bool {
+	if len(s) < 2 || s[0] != 'v' {
+		return false
+	}
+	_, err := strconv.Atoi(s[1:])
+	return err == nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"tag_highlight/nvimtest"
+	"testing"
+)
+
+/*
+ * Runs the real thing: the highlighter is built and started against an
+ * embedded neovim editing testdata/highlight.c, and has to get the fixture's
+ * function and struct highlighted. The variables the vim side of the plugin
+ * would normally set are given by hand.
+ */
+func TestHighlightEmbedded(t *testing.T) {
+	for _, prog := range []string{"nvim", "ctags", "go"} {
+		if _, err := exec.LookPath(prog); err != nil {
+			t.Skipf("%s isn't installed", prog)
+		}
+	}
+
+	/* The highlighter keeps its logs and tag caches under $HOME. */
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, "go", "src", "tag_highlight", ".logs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(home, ".vim_tags_go"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	bin := filepath.Join(t.TempDir(), "tag_highlight")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("Failed to build the highlighter: %s\n%s", err, out)
+	}
+
+	settings := map[string]interface{}{
+		"settings": map[string]interface{}{
+			"enabled":    true,
+			"ctags_args": []string{"--fields=+l"},
+		},
+		"restored_groups": map[string]interface{}{},
+		"c#order":         "fs",
+		"c#equivalent":    map[string]interface{}{},
+		"c#f":             map[string]interface{}{"group": "CFuncTag"},
+		"c#s":             map[string]interface{}{"group": "CStructTag"},
+	}
+	want := map[string][]string{
+		"_tag_highlight_c_f": {"make_point"},
+		"_tag_highlight_c_s": {"point"},
+	}
+
+	if err := nvimtest.Check_Highlight(bin, "testdata/highlight.c", settings, want); err != nil {
+		t.Fatal(err)
+	}
+}
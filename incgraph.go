@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"tag_highlight/util"
+)
+
+/*
+ * Every header's #include lines, remembered along with the header's mtime and
+ * size so that it's only read again once it changes. Finding the headers of a C
+ * file means following these transitively through a good part of the system
+ * headers on every write, and almost none of those ever change. The cache is
+ * kept in a file beside the tag archives so that it survives from one session
+ * to the next.
+ *
+ * Only the directives themselves are stored. Where they resolve to depends on
+ * the include path of whoever is asking, and looking that up is only a stat.
+ */
+
+const include_graph_version = 1
+
+type include_graph_entry struct {
+	Mtime    int64     `json:"mtime"`
+	Size     int64     `json:"size"`
+	Includes []include `json:"includes"`
+}
+
+type include_graph_file struct {
+	Version int                             `json:"version"`
+	Headers map[string]*include_graph_entry `json:"headers"`
+}
+
+var include_graph = struct {
+	sync.Mutex
+	once    sync.Once
+	headers map[string]*include_graph_entry
+	dirty   bool
+}{}
+
+//========================================================================================
+
+func include_graph_fname() string {
+	return filepath.Join(HOME, ".vim_tags_go", "include_graph.json")
+}
+
+func load_include_graph() {
+	include_graph.headers = make(map[string]*include_graph_entry, 1024)
+
+	data, err := os.ReadFile(include_graph_fname())
+	if err != nil {
+		return
+	}
+	var file include_graph_file
+	if err = json.Unmarshal(data, &file); err != nil || file.Version != include_graph_version {
+		util.Warn("Ignoring include graph cache '%s' (version %d): %v",
+			include_graph_fname(), file.Version, err)
+		return
+	}
+	if file.Headers != nil {
+		include_graph.headers = file.Headers
+	}
+}
+
+/*
+ * Returns the includes of a header and its size, reading it only if it has
+ * changed since it was last looked at. Nil means it doesn't exist. The lock is
+ * only held to look the header up and to store it, so that headers can be read
+ * and parsed concurrently. Entries are never changed once stored.
+ */
+func header_includes(path string) *include_graph_entry {
+	include_graph.once.Do(load_include_graph)
+	st, err := os.Stat(path)
+
+	if err != nil || st.IsDir() {
+		include_graph.Lock()
+		if _, ok := include_graph.headers[path]; ok {
+			delete(include_graph.headers, path)
+			include_graph.dirty = true
+		}
+		include_graph.Unlock()
+		return nil
+	}
+
+	include_graph.Lock()
+	ent := include_graph.headers[path]
+	include_graph.Unlock()
+	if ent != nil && ent.Mtime == st.ModTime().UnixNano() && ent.Size == st.Size() {
+		return ent
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	ent = &include_graph_entry{
+		Mtime:    st.ModTime().UnixNano(),
+		Size:     st.Size(),
+		Includes: make([]include, 0, 8),
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if file, angle := analyze_line(string(line)); file != "" {
+			ent.Includes = append(ent.Includes, include{file, angle})
+		}
+	}
+
+	include_graph.Lock()
+	include_graph.headers[path] = ent
+	include_graph.dirty = true
+	include_graph.Unlock()
+	return ent
+}
+
+/*
+ * Writes the cache out if anything in it changed. It's written to a temporary
+ * file first so that another instance reading it never sees half of it.
+ */
+func save_include_graph() {
+	include_graph.Lock()
+	defer include_graph.Unlock()
+
+	if !include_graph.dirty {
+		return
+	}
+	data, err := json.Marshal(include_graph_file{
+		Version: include_graph_version,
+		Headers: include_graph.headers,
+	})
+	if err != nil {
+		util.Warn("Failed to encode the include graph: %s", err)
+		return
+	}
+
+	fname := include_graph_fname()
+	if err = os.MkdirAll(filepath.Dir(fname), 0755); err == nil {
+		tmp := fmt.Sprintf("%s.%d", fname, os.Getpid())
+		if err = os.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, fname)
+		}
+	}
+	if err != nil {
+		util.Warn("Failed to write the include graph: %s", err)
+		return
+	}
+	include_graph.dirty = false
+}
@@ -4,41 +4,62 @@ import (
 	"math"
 )
 
-type Array struct {
-	data []uint
+type Array[T any] struct {
+	data []T
 	ctr  uint
 }
 
 //========================================================================================
 
-func New_Array(len int) *Array {
-	ret := Array{
-		data: make([]uint, len),
+func New_Array[T any](len int) *Array[T] {
+	ret := Array[T]{
+		data: make([]T, 0, len),
 		ctr:  0,
 	}
 
 	return &ret
 }
 
-func (arr Array) Get() uint {
+func (arr *Array[T]) Push(val T) {
+	arr.data = append(arr.data, val)
+	arr.ctr++
+}
+
+// Get returns the last item pushed, or the zero value if there isn't one.
+func (arr *Array[T]) Get() T {
 	return arr.GetOffset(0)
 }
 
-func (arr Array) GetOffset(offset int) uint {
+// GetOffset returns the item offset places before the last one pushed.
+func (arr *Array[T]) GetOffset(offset int) T {
 	var (
 		abs   = int64(math.Abs(float64(offset)))
 		index = int64(arr.ctr) - abs - 1
 	)
 
-	if index < 0 || index > int64(len(arr.data)) {
-		return 0
+	return arr.GetInd(int(index))
+}
+
+func (arr *Array[T]) GetInd(index int) T {
+	var zero T
+	if index < 0 || index >= len(arr.data) {
+		return zero
 	}
 	return arr.data[index]
 }
 
-func (arr Array) GetInd(index int) uint {
-	if index < 0 || index > len(arr.data) {
-		return 0
+func (arr *Array[T]) Set(index int, val T) {
+	arr.data[index] = val
+}
+
+func (arr *Array[T]) Len() int {
+	return len(arr.data)
+}
+
+func (arr *Array[T]) Each(fn func(i int, val T) bool) {
+	for i, val := range arr.data {
+		if !fn(i, val) {
+			return
+		}
 	}
-	return arr.data[index]
 }
@@ -6,22 +6,22 @@ import (
 	"tag_highlight/util"
 )
 
-type Node struct {
-	Data interface{}
-	Next *Node
-	Prev *Node
+type Node[T any] struct {
+	Data T
+	Next *Node[T]
+	Prev *Node[T]
 }
 
-type Linked_List struct {
-	Head *Node
-	Tail *Node
+type List[T any] struct {
+	Head *Node[T]
+	Tail *Node[T]
 	Qty  int
 }
 
 //========================================================================================
 
-func New_List() *Linked_List {
-	list := Linked_List{
+func New_List[T any]() *List[T] {
+	list := List[T]{
 		Head: nil,
 		Tail: nil,
 		Qty:  0,
@@ -29,8 +29,8 @@ func New_List() *Linked_List {
 	return &list
 }
 
-func (list *Linked_List) Prepend(data interface{}) {
-	var node Node
+func (list *List[T]) Prepend(data T) {
+	var node Node[T]
 
 	if list.Head != nil {
 		list.Head.Prev = &node
@@ -47,8 +47,8 @@ func (list *Linked_List) Prepend(data interface{}) {
 	list.Qty++
 }
 
-func (list *Linked_List) Append(data interface{}) {
-	var node Node
+func (list *List[T]) Append(data T) {
+	var node Node[T]
 
 	if list.Tail != nil {
 		list.Tail.Next = &node
@@ -65,56 +65,43 @@ func (list *Linked_List) Append(data interface{}) {
 	list.Qty++
 }
 
-func (list *Linked_List) Join(str []byte) []byte {
-	/* if list == nil || list.Qty == 0 {
-		return ""
+// Slice returns the contents of the list in order.
+func (list *List[T]) Slice() []T {
+	ret := make([]T, 0, list.Qty)
+	for node := list.Head; node != nil; node = node.Next {
+		ret = append(ret, node.Data)
 	}
-	var buf string */
-	var buf bytes.Buffer
-	buf.Grow(0x4000)
+	return ret
+}
 
+// Each calls fn with every item in order, stopping early if fn returns false.
+func (list *List[T]) Each(fn func(i int, val T) bool) {
+	i := 0
 	for node := list.Head; node != nil; node = node.Next {
-		// buf += node.Data.(string) + str
-		buf.WriteString(node.Data.(string))
-		buf.Write(str)
-	}
-
-	/* for node := list.Head; node != nil; node = node.Next {
-		switch list.Head.Data.(type) {
-		case string:
-			buf += node.Data.(string) + str
-		case *string:
-			buf += *node.Data.(*string) + str
-		case []byte:
-			buf += string(node.Data.([]byte)) + str
-		case *[]byte:
-			buf += string(*node.Data.(*[]byte)) + str
-		case []rune:
-			buf += string(node.Data.([]rune)) + str
-		case *[]rune:
-			buf += string(*node.Data.(*[]rune)) + str
-		default:
-			// panic("Node data type is not a string type I can recognize: cannot join.")
+		if !fn(i, node.Data) {
+			return
 		}
-	} */
-
-	return buf.Bytes()
+		i++
+	}
 }
 
-func (list *Linked_List) MakeSliceStr() []string {
-	ret := make([]string, list.Qty)
-	i := 0
+// Join returns every item of a list of strings with sep after each one.
+func Join[T ~string](list *List[T], sep []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(0x4000)
+
 	for node := list.Head; node != nil; node = node.Next {
-		ret[i] = node.Data.(string)
-		i++
+		buf.WriteString(string(node.Data))
+		buf.Write(sep)
 	}
-	return ret
+
+	return buf.Bytes()
 }
 
 //========================================================================================
 
-func (list *Linked_List) Insert_After(at *Node, data interface{}) {
-	var node Node
+func (list *List[T]) Insert_After(at *Node[T], data T) {
+	var node Node[T]
 	node.Data = data
 	node.Prev = at
 
@@ -138,8 +125,8 @@ func (list *Linked_List) Insert_After(at *Node, data interface{}) {
 	list.Qty++
 }
 
-func (list *Linked_List) Insert_Before(at *Node, data interface{}) {
-	var node Node
+func (list *List[T]) Insert_Before(at *Node[T], data T) {
+	var node Node[T]
 	node.Data = data
 	node.Next = at
 
@@ -188,9 +175,9 @@ func resolve_neg(val, base int) int {
 //         }
 // }
 
-func (list *Linked_List) create_nodes(i int, tmp []*Node, data []interface{}) int {
+func (list *List[T]) create_nodes(i int, tmp []*Node[T], data []T) int {
 	for x := 1; x < len(data); x++ {
-		tmp[i] = &Node{}
+		tmp[i] = &Node[T]{}
 		tmp[i].Data = data[x]
 		tmp[i].Prev = tmp[i-1]
 		tmp[i-1].Next = tmp[i]
@@ -202,7 +189,7 @@ func (list *Linked_List) create_nodes(i int, tmp []*Node, data []interface{}) in
 	return i
 }
 
-func (list *Linked_List) Insert_Slice_After(at *Node, data ...interface{}) {
+func (list *List[T]) Insert_Slice_After(at *Node[T], data ...T) {
 	// start = resolve_neg(start, int(len(data)))
 	// end = resolve_neg(end, int(len(data)))
 	// sanity_check1(start, end, data)
@@ -215,8 +202,8 @@ func (list *Linked_List) Insert_Slice_After(at *Node, data ...interface{}) {
 		return
 	}
 
-	tmp := make([]*Node, diff)
-	tmp[0] = &Node{}
+	tmp := make([]*Node[T], diff)
+	tmp[0] = &Node[T]{}
 	tmp[0].Data = data[0]
 	tmp[0].Prev = at
 	// list.Qty++
@@ -243,7 +230,7 @@ func (list *Linked_List) Insert_Slice_After(at *Node, data ...interface{}) {
 	list.Qty += diff
 }
 
-func (list *Linked_List) Insert_Slice_Before(at *Node, data ...interface{}) {
+func (list *List[T]) Insert_Slice_Before(at *Node[T], data ...T) {
 	// start = resolve_neg(start, int(len(data)))
 	// end = resolve_neg(end, int(len(data)))
 	// sanity_check1(start, end, data)
@@ -256,8 +243,8 @@ func (list *Linked_List) Insert_Slice_Before(at *Node, data ...interface{}) {
 		return
 	}
 
-	tmp := make([]*Node, diff)
-	tmp[0] = &Node{}
+	tmp := make([]*Node[T], diff)
+	tmp[0] = &Node[T]{}
 	tmp[0].Data = data[0]
 	// list.Qty++
 
@@ -286,7 +273,7 @@ func (list *Linked_List) Insert_Slice_Before(at *Node, data ...interface{}) {
 
 //========================================================================================
 
-func (list *Linked_List) Delete_Node(node *Node) {
+func (list *List[T]) Delete_Node(node *Node[T]) {
 	if list.Qty == 1 {
 		list.Head, list.Tail = nil, nil
 	} else if node == list.Head {
@@ -303,7 +290,7 @@ func (list *Linked_List) Delete_Node(node *Node) {
 	list.Qty--
 }
 
-func (list *Linked_List) Delete_Range(at *Node, rng int) {
+func (list *List[T]) Delete_Range(at *Node[T], rng int) {
 	// util.Eprintf("Deleting range %d\n", rng)
 	if list.Qty < rng {
 		log.Panicf("Delete range (%d) cannot be larger than the list's size (%d)", rng, list.Qty)
@@ -317,13 +304,13 @@ func (list *Linked_List) Delete_Range(at *Node, rng int) {
 	} */
 
 	var (
-		/* start        *Node = at.Prev
-		end          *Node = nil */
-		last         *Node = nil
-		current      *Node = at
-		next         *Node = nil
-		prev         *Node = nil
-		replace_head bool  = (at == list.Head || list.Head == nil)
+		/* start        *Node[T] = at.Prev
+		end          *Node[T] = nil */
+		last         *Node[T] = nil
+		current      *Node[T] = at
+		next         *Node[T] = nil
+		prev         *Node[T] = nil
+		replace_head bool     = (at == list.Head || list.Head == nil)
 	)
 
 	if at != nil {
@@ -367,33 +354,33 @@ func (list *Linked_List) Delete_Range(at *Node, rng int) {
  * These were all macros in the original C code, but they should be inlined in
  * pretty much every instance anyway.
  */
-func (list *Linked_List) Insert_Before_At(index int, data interface{}) {
+func (list *List[T]) Insert_Before_At(index int, data T) {
 	list.Insert_Before(list.At(index), data)
 }
 
-func (list *Linked_List) Insert_After_At(index int, data interface{}) {
+func (list *List[T]) Insert_After_At(index int, data T) {
 	list.Insert_After(list.At(index), data)
 }
 
-func (list *Linked_List) Insert_Slice_Before_At(index int, data ...interface{}) {
+func (list *List[T]) Insert_Slice_Before_At(index int, data ...T) {
 	list.Insert_Slice_Before(list.At(index), data...)
 }
 
-func (list *Linked_List) Insert_Slice_After_At(index int, data ...interface{}) {
+func (list *List[T]) Insert_Slice_After_At(index int, data ...T) {
 	list.Insert_Slice_After(list.At(index), data...)
 }
 
-func (list *Linked_List) Delete_Node_At(index int) {
+func (list *List[T]) Delete_Node_At(index int) {
 	list.Delete_Node(list.At(index))
 }
 
-func (list *Linked_List) Delete_Range_At(index, rng int) {
+func (list *List[T]) Delete_Range_At(index, rng int) {
 	list.Delete_Range(list.At(index), rng)
 }
 
 //========================================================================================
 
-func (list *Linked_List) At(index int) *Node {
+func (list *List[T]) At(index int) *Node[T] {
 	if list == nil || list.Qty == 0 || list.Head == nil || list.Tail == nil {
 		return nil
 	}
@@ -411,7 +398,7 @@ func (list *Linked_List) At(index int) *Node {
 	}
 
 	var (
-		current *Node = nil
+		current *Node[T] = nil
 		x       int
 	)
 
@@ -435,7 +422,7 @@ func (list *Linked_List) At(index int) *Node {
 	return current
 }
 
-func (list *Linked_List) Replace_At(index int, data interface{}) {
+func (list *List[T]) Replace_At(index int, data T) {
 	node := list.At(index)
 	if node == nil {
 		panic("Node out of range!")
@@ -444,7 +431,7 @@ func (list *Linked_List) Replace_At(index int, data interface{}) {
 	node.Data = data
 }
 
-func (list *Linked_List) Verify_Size() bool {
+func (list *List[T]) Verify_Size() bool {
 	i := 0
 	for node := list.Head; node != nil; i++ {
 		node = node.Next
@@ -4,61 +4,83 @@ import (
 	"math"
 )
 
-type Stack struct {
-	stack  []interface{}
-	nilret interface{}
+type Stack[T any] struct {
+	stack  []T
+	nilret T
 	ctr    uint
 }
 
 //========================================================================================
 
-func New_Stack(initlen int, nilret interface{}) *Stack {
-	return &Stack{
-		stack:  make([]interface{}, initlen),
+// New_Stack makes a stack whose Peek returns nilret when it's empty.
+func New_Stack[T any](initlen int, nilret T) *Stack[T] {
+	if initlen < 1 {
+		initlen = 1
+	}
+	return &Stack[T]{
+		stack:  make([]T, initlen),
 		nilret: nilret,
 		ctr:    0,
 	}
 }
 
-func (stk *Stack) Push(val interface{}) {
+func (stk *Stack[T]) Push(val T) {
 	if stk.ctr >= uint(len(stk.stack)) {
 		stk.stack = append(stk.stack,
-			make([]interface{}, len(stk.stack))...)
+			make([]T, len(stk.stack))...)
 	}
 
 	stk.stack[stk.ctr] = val
 	stk.ctr++
 }
 
-func (stk *Stack) Pop() interface{} {
+func (stk *Stack[T]) Pop() T {
+	var zero T
 	if stk.ctr == 0 {
 		panic("Can't pop from empty stack.")
 	}
 
 	stk.ctr--
 	ret := stk.stack[stk.ctr]
-	stk.stack[stk.ctr] = nil
+	stk.stack[stk.ctr] = zero
 
 	return ret
 }
 
-func (stk Stack) Peek() interface{} {
+func (stk *Stack[T]) Peek() T {
 	return stk.PeekAt(0)
 }
 
-func (stk Stack) PeekAt(offset int) interface{} {
+func (stk *Stack[T]) PeekAt(offset int) T {
 	var (
 		abs   = int64(math.Abs(float64(offset)))
 		index = int64(stk.ctr) - abs - 1
 	)
 
-	if stk.ctr == 0 || index < 0 || index > int64(len(stk.stack)) {
+	if stk.ctr == 0 || index < 0 || index >= int64(len(stk.stack)) {
 		return stk.nilret
 	}
 	return stk.stack[index]
 }
 
-func (stk *Stack) Reset() {
+func (stk *Stack[T]) Len() int {
+	return int(stk.ctr)
+}
+
+func (stk *Stack[T]) Reset() {
+	var zero T
+	for i := uint(0); i < stk.ctr; i++ {
+		stk.stack[i] = zero
+	}
 	stk.ctr = 0
-	stk.stack[0] = nil
+}
+
+// Each calls fn with every item from the top of the stack down, stopping early
+// if fn returns false.
+func (stk *Stack[T]) Each(fn func(i int, val T) bool) {
+	for i := 0; i < int(stk.ctr); i++ {
+		if !fn(i, stk.stack[int(stk.ctr)-i-1]) {
+			return
+		}
+	}
 }
@@ -0,0 +1,298 @@
+package lists
+
+import (
+	"bytes"
+	"fmt"
+)
+
+type tree_node struct {
+	line  string
+	prio  uint32
+	size  int
+	left  *tree_node
+	right *tree_node
+}
+
+/*
+ * A Line_Tree holds the lines of a buffer in an implicit treap: a binary tree
+ * ordered by position, where every node knows the size of its subtree, and
+ * kept balanced (in expectation) by giving each node a random priority and
+ * keeping the tree heap ordered by it. Indexing, inserting, deleting and
+ * replacing a range are all O(log n) plus the number of lines involved, where
+ * the linked list this replaces had to walk to the index first.
+ */
+type Line_Tree struct {
+	root *tree_node
+	seed uint32
+}
+
+//========================================================================================
+
+func New_Line_Tree(lines ...string) *Line_Tree {
+	tree := &Line_Tree{seed: 0x9E3779B9}
+	tree.root = tree.build(lines)
+	return tree
+}
+
+func (tree *Line_Tree) Len() int {
+	return size(tree.root)
+}
+
+// At returns line i. It panics if i is out of range, like indexing a slice.
+func (tree *Line_Tree) At(i int) string {
+	return tree.node_at(i).line
+}
+
+// Set replaces line i.
+func (tree *Line_Tree) Set(i int, line string) {
+	tree.node_at(i).line = line
+}
+
+// Insert inserts the lines before line i, so Insert(Len(), ...) appends.
+func (tree *Line_Tree) Insert(i int, lines ...string) {
+	tree.Replace(i, i, lines)
+}
+
+func (tree *Line_Tree) Append(lines ...string) {
+	tree.Replace(tree.Len(), tree.Len(), lines)
+}
+
+// Delete removes lines [first, last).
+func (tree *Line_Tree) Delete(first, last int) {
+	tree.Replace(first, last, nil)
+}
+
+/*
+ * Replace replaces lines [first, last) with the given ones, which is exactly
+ * what an nvim_buf_lines_event asks for. A negative last means the end.
+ */
+func (tree *Line_Tree) Replace(first, last int, lines []string) {
+	n := tree.Len()
+	if last < 0 {
+		last = n
+	}
+	if first < 0 || first > last || last > n {
+		panic(fmt.Sprintf("Line_Tree: invalid range [%d, %d) (len %d)", first, last, n))
+	}
+
+	left, rest := split(tree.root, first)
+	_, right := split(rest, last-first)
+	tree.root = merge(merge(left, tree.build(lines)), right)
+}
+
+//========================================================================================
+
+/*
+ * Range calls fn with each of lines [first, last) in order, stopping early if
+ * fn returns false. A negative last means the end.
+ */
+func (tree *Line_Tree) Range(first, last int, fn func(i int, line string) bool) {
+	if last < 0 || last > tree.Len() {
+		last = tree.Len()
+	}
+	if first < 0 {
+		first = 0
+	}
+
+	/* Find the first line, keeping every node we went left at: those are the
+	 * ones that come after it, in order. */
+	stack := make([]*tree_node, 0, 64)
+	for node, k := tree.root, first; node != nil; {
+		ls := size(node.left)
+		switch {
+		case k < ls:
+			stack = append(stack, node)
+			node = node.left
+		case k == ls:
+			stack = append(stack, node)
+			node = nil
+		default:
+			k -= ls + 1
+			node = node.right
+		}
+	}
+
+	for i := first; i < last && len(stack) > 0; i++ {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !fn(i, node.line) {
+			return
+		}
+		for c := node.right; c != nil; c = c.left {
+			stack = append(stack, c)
+		}
+	}
+}
+
+// Each calls fn with every line in order.
+func (tree *Line_Tree) Each(fn func(i int, line string) bool) {
+	tree.Range(0, (-1), fn)
+}
+
+// Join returns every line with sep after each one, as Join does for a List.
+func (tree *Line_Tree) Join(sep []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(0x4000)
+
+	tree.Each(func(_ int, line string) bool {
+		buf.WriteString(line)
+		buf.Write(sep)
+		return true
+	})
+
+	return buf.Bytes()
+}
+
+func (tree *Line_Tree) Slice() []string {
+	ret := make([]string, 0, tree.Len())
+	tree.Each(func(_ int, line string) bool {
+		ret = append(ret, line)
+		return true
+	})
+	return ret
+}
+
+/*
+ * verify checks that every subtree's size is right and that the priorities are
+ * heap ordered. It's only meant for the tests.
+ */
+func (tree *Line_Tree) verify() error {
+	_, err := verify_node(tree.root)
+	return err
+}
+
+func verify_node(node *tree_node) (int, error) {
+	if node == nil {
+		return 0, nil
+	}
+	l, err := verify_node(node.left)
+	if err != nil {
+		return 0, err
+	}
+	r, err := verify_node(node.right)
+	if err != nil {
+		return 0, err
+	}
+	if node.size != l+r+1 {
+		return 0, fmt.Errorf("node '%s' has size %d, expected %d", node.line, node.size, l+r+1)
+	}
+	if (node.left != nil && node.left.prio > node.prio) || (node.right != nil && node.right.prio > node.prio) {
+		return 0, fmt.Errorf("node '%s' violates the heap order", node.line)
+	}
+	return node.size, nil
+}
+
+//========================================================================================
+
+func (tree *Line_Tree) node_at(i int) *tree_node {
+	if i < 0 || i >= tree.Len() {
+		panic(fmt.Sprintf("Line_Tree: index %d out of range (len %d)", i, tree.Len()))
+	}
+	node := tree.root
+
+	for {
+		ls := size(node.left)
+		switch {
+		case i < ls:
+			node = node.left
+		case i == ls:
+			return node
+		default:
+			i -= ls + 1
+			node = node.right
+		}
+	}
+}
+
+/* xorshift32. Nothing here needs better randomness than this. */
+func (tree *Line_Tree) random() uint32 {
+	x := tree.seed
+	x ^= x << 13
+	x ^= x >> 17
+	x ^= x << 5
+	tree.seed = x
+	return x
+}
+
+/*
+ * Builds a treap from lines in O(n) by keeping the right spine on a stack, the
+ * usual way of building a cartesian tree, rather than inserting them one by
+ * one.
+ */
+func (tree *Line_Tree) build(lines []string) *tree_node {
+	if len(lines) == 0 {
+		return nil
+	}
+	spine := make([]*tree_node, 0, 64)
+
+	for _, line := range lines {
+		node := &tree_node{line: line, prio: tree.random(), size: 1}
+		var last *tree_node
+		for len(spine) > 0 && spine[len(spine)-1].prio < node.prio {
+			last = spine[len(spine)-1]
+			spine = spine[:len(spine)-1]
+		}
+		node.left = last
+		if len(spine) > 0 {
+			spine[len(spine)-1].right = node
+		}
+		spine = append(spine, node)
+	}
+
+	fix_sizes(spine[0])
+	return spine[0]
+}
+
+func fix_sizes(node *tree_node) int {
+	if node == nil {
+		return 0
+	}
+	node.size = fix_sizes(node.left) + fix_sizes(node.right) + 1
+	return node.size
+}
+
+func size(node *tree_node) int {
+	if node == nil {
+		return 0
+	}
+	return node.size
+}
+
+func (node *tree_node) update() {
+	node.size = size(node.left) + size(node.right) + 1
+}
+
+/* Splits off the first k lines. */
+func split(node *tree_node, k int) (*tree_node, *tree_node) {
+	if node == nil {
+		return nil, nil
+	}
+	if ls := size(node.left); ls < k {
+		l, r := split(node.right, k-ls-1)
+		node.right = l
+		node.update()
+		return node, r
+	}
+	l, r := split(node.left, k)
+	node.left = r
+	node.update()
+	return l, node
+}
+
+/* Every line of a comes before every line of b. */
+func merge(a, b *tree_node) *tree_node {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case a.prio > b.prio:
+		a.right = merge(a.right, b)
+		a.update()
+		return a
+	default:
+		b.left = merge(a, b.left)
+		b.update()
+		return b
+	}
+}
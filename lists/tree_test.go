@@ -0,0 +1,138 @@
+package lists
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+/*
+ * Random operations are run against a Line_Tree and a plain slice side by side,
+ * and after each one they have to agree and the tree's invariants have to hold.
+ */
+func TestLineTreeModel(t *testing.T) {
+	for seed := int64(1); seed <= 50; seed++ {
+		if err := check_line_tree(seed, 2000); err != nil {
+			t.Fatalf("seed %d: %s", seed, err)
+		}
+	}
+}
+
+func check_line_tree(seed int64, ops int) error {
+	var (
+		rnd   = rand.New(rand.NewSource(seed))
+		tree  = New_Line_Tree()
+		model = []string{}
+		ctr   = 0
+	)
+
+	gen := func(n int) []string {
+		ret := make([]string, n)
+		for i := range ret {
+			ret[i] = fmt.Sprintf("line %d", ctr)
+			ctr++
+		}
+		return ret
+	}
+	span := func() (int, int) {
+		first := rnd.Intn(len(model) + 1)
+		return first, first + rnd.Intn(len(model)-first+1)
+	}
+
+	for op := 0; op < ops; op++ {
+		var desc string
+
+		switch rnd.Intn(6) {
+		case 0:
+			first, last := span()
+			lines := gen(rnd.Intn(8))
+			desc = fmt.Sprintf("Replace(%d, %d, %d lines)", first, last, len(lines))
+			tree.Replace(first, last, lines)
+			model = append(model[:first:first], append(lines, model[last:]...)...)
+		case 1:
+			i := rnd.Intn(len(model) + 1)
+			lines := gen(1 + rnd.Intn(3))
+			desc = fmt.Sprintf("Insert(%d, %d lines)", i, len(lines))
+			tree.Insert(i, lines...)
+			model = append(model[:i:i], append(lines, model[i:]...)...)
+		case 2:
+			first, last := span()
+			desc = fmt.Sprintf("Delete(%d, %d)", first, last)
+			tree.Delete(first, last)
+			model = append(model[:first:first], model[last:]...)
+		case 3:
+			lines := gen(rnd.Intn(4))
+			desc = fmt.Sprintf("Append(%d lines)", len(lines))
+			tree.Append(lines...)
+			model = append(model, lines...)
+		case 4:
+			if len(model) == 0 {
+				continue
+			}
+			i := rnd.Intn(len(model))
+			line := gen(1)[0]
+			desc = fmt.Sprintf("Set(%d)", i)
+			tree.Set(i, line)
+			model[i] = line
+		case 5:
+			first, last := span()
+			desc = fmt.Sprintf("Range(%d, %d)", first, last)
+			got := make([]string, 0, last-first)
+			tree.Range(first, last, func(i int, line string) bool {
+				if i != first+len(got) {
+					got = append(got, "<bad index>")
+				}
+				got = append(got, line)
+				return true
+			})
+			if !equal_lines(got, model[first:last]) {
+				return fmt.Errorf("op %d (%s): got %q, expected %q", op, desc, got, model[first:last])
+			}
+		}
+
+		if err := compare_tree(tree, model); err != nil {
+			return fmt.Errorf("op %d (%s): %s", op, desc, err)
+		}
+	}
+
+	return nil
+}
+
+func compare_tree(tree *Line_Tree, model []string) error {
+	if err := tree.verify(); err != nil {
+		return err
+	}
+	if tree.Len() != len(model) {
+		return fmt.Errorf("length is %d, expected %d", tree.Len(), len(model))
+	}
+	for i := range model {
+		if s := tree.At(i); s != model[i] {
+			return fmt.Errorf("line %d is '%s', expected '%s'", i, s, model[i])
+		}
+	}
+	if !equal_lines(tree.Slice(), model) {
+		return fmt.Errorf("Slice() doesn't match")
+	}
+	want := ""
+	if len(model) > 0 {
+		want = strings.Join(model, "\n") + "\n"
+	}
+	if got := string(tree.Join([]byte("\n"))); got != want {
+		return fmt.Errorf("Join() doesn't match")
+	}
+
+	return nil
+}
+
+func equal_lines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
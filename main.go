@@ -1,11 +1,15 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	// "os/signal"
+	"reflect"
 	"runtime"
 	// "runtime/pprof"
+	"strings"
 	"syscall"
 	"tag_highlight/api"
 	"tag_highlight/archive"
@@ -16,21 +20,32 @@ import (
 type bstr = []byte
 
 type settings_t struct {
-	Comp_type       uint16
-	Comp_level      uint16
-	Ignored_tags    map[string][][]byte
-	Ctags_args      []string
-	Ignored_ftypes  []string
-	Norecurse_dirs  []string
-	Settings_file   string
-	Enabled         bool
-	Use_compression bool
-	Verbose         bool
+	Comp_type           uint16              `msgpack:"-"`
+	Comp_name           string              `msgpack:"compression_type"`
+	Comp_level          uint16              `msgpack:"compression_level"`
+	Ignored_tags        map[string][][]byte `msgpack:"ignored_tags"`
+	Ctags_args          []string            `msgpack:"ctags_args"`
+	Ignored_ftypes      []string            `msgpack:"ignore"`
+	Norecurse_dirs      []string            `msgpack:"norecurse_dirs"`
+	Settings_file       string              `msgpack:"settings_file"`
+	Backend             string              `msgpack:"backend"`
+	Viewport_margin     int                 `msgpack:"viewport_margin"`
+	Sys_header_depth    int                 `msgpack:"system_header_depth"`
+	Sys_header_max_size int                 `msgpack:"system_header_max_size"`
+	Static_tags         []string            `msgpack:"static_tags"`
+	Tag_sources         map[string][]string `msgpack:"tag_sources"`
+	Enabled             bool                `msgpack:"enabled"`
+	Go_use_ctags        bool                `msgpack:"go_use_ctags"`
+	Go_deps             bool                `msgpack:"go_deps"`
+	No_watch            bool                `msgpack:"no_watch"`
+	Use_compression     bool                `msgpack:"use_compression"`
+	Verbose             bool                `msgpack:"verbose"`
 }
 
 var (
 	read_fd int = (-1)
 	// Sockfd   int  = (-1)
+	event_fd int = 1 // Connection buffer updates are sent on
 	DEBUG    bool
 	HOME     string
 	src_dir  string
@@ -81,24 +96,26 @@ func main() {
 	// defer pprof.StopCPUProfile()
 	// defer prof_f.Close()
 
-	api.Sockfd = create_socket()
-	util.Eprintf("Created socket fd %d!\n", api.Sockfd)
-
-	Settings = settings_t{
-		Comp_type:       get_compression_type(0),
-		Comp_level:      uint16(api.Nvim_get_var(0, pkg("compression_level"), mpack.T_NUM).(int64)),
-		Ctags_args:      api.Nvim_get_var(0, pkg("ctags_args"), mpack.E_STRLIST).([]string),
-		Enabled:         api.Nvim_get_var(0, pkg("enabled"), mpack.T_BOOL).(bool),
-		Ignored_ftypes:  api.Nvim_get_var(0, pkg("ignore"), mpack.E_STRLIST).([]string),
-		Ignored_tags:    api.Nvim_get_var(0, pkg("ignored_tags"), mpack.E_MAP_STR_BYTELIST).(map[string][][]byte),
-		Norecurse_dirs:  api.Nvim_get_var(0, pkg("norecurse_dirs"), mpack.E_STRLIST).([]string),
-		Settings_file:   api.Nvim_get_var(0, pkg("settings_file"), mpack.E_STRING).(string),
-		Use_compression: api.Nvim_get_var(0, pkg("use_compression"), mpack.T_BOOL).(bool),
-		Verbose:         api.Nvim_get_var(0, pkg("verbose"), mpack.T_BOOL).(bool),
+	addr := flag.String("addr", "", "address of a running neovim to attach to (unix socket path or host:port)")
+	flag.Parse()
+
+	err := connect(*addr)
+	if err != nil {
+		util.Eprintf("Failed to connect to neovim: %s\n", err)
+		os.Exit(1)
+	}
+	util.Eprintf("Connected on fd %d (events on %d)!\n", api.Sockfd, event_fd)
+
+	if Settings, err = load_settings(); err != nil {
+		util.Eprintf("Failed to load settings: %s\n", err)
+		os.Exit(1)
 	}
+
 	if !Settings.Enabled {
+		util.Eprintf("tag_highlight is disabled (enabled is false in its settings), exiting.\n")
 		os.Exit(0)
 	}
+	register_handlers()
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	var initial_buf int = (-1)
@@ -112,10 +129,15 @@ func main() {
 			api.Echo("Retrying initial connection (attempt %d)", attempts)
 		}
 
-		initial_buf = api.Nvim_get_current_buf(0)
+		if initial_buf, err = api.Nvim_get_current_buf(0); err != nil {
+			util.Eprintf("Failed to get the current buffer: %s\n", err)
+			continue
+		}
 		if New_Buffer(0, initial_buf) != nil {
 			bdata := Find_Buffer(initial_buf)
-			api.Nvim_buf_attach(1, initial_buf)
+			if err = api.Nvim_buf_attach(event_fd, initial_buf); err != nil {
+				util.Eprintf("Failed to attach to buffer %d: %s\n", initial_buf, err)
+			}
 
 			bdata.get_initial_lines()
 			bdata.Get_Initial_Taglist()
@@ -134,13 +156,13 @@ func main() {
 		}
 	}
 
-	for {
-		event := api.Decode_Nvim_Stream(1, api.MES_NOTIFICATION)
-		if event != nil {
-			event.Print(util.Logfiles["main"])
-			handle_nvim_event(event)
-		}
+	events, err := api.Notifications(event_fd)
+	if err != nil {
+		util.Eprintf("%s\n", err)
+		os.Exit(1)
 	}
+	event_loop(events)
+	stop_ctags_workers()
 }
 
 func mpack_raw_str(bte []byte) string {
@@ -157,30 +179,33 @@ func mpack_raw_str(bte []byte) string {
 
 //========================================================================================
 
-func main_loop(bufnum int) {
-	sock := create_socket()
-
-	{
-		api.Nvim_buf_attach(sock, bufnum)
-		ret1 := api.Decode_Nvim_Stream(sock, api.MES_ANY)
-		ret2 := api.Decode_Nvim_Stream(sock, api.MES_ANY)
-		var event *mpack.Object
-
-		if ret1.Index(0).Expect(mpack.T_NUM).(int64) == api.MES_NOTIFICATION {
-			event = ret1
-		} else if ret2.Index(0).Expect(mpack.T_NUM).(int64) == api.MES_NOTIFICATION {
-			event = ret2
-		} else {
-			panic("Didn't recieve an update from neovim...")
-		}
-
-		handle_nvim_event(event)
+func main_loop(bufnum int) error {
+	sock, err := create_socket()
+	if err != nil {
+		return err
+	}
+	if err = api.Nvim_buf_attach(sock, bufnum); err != nil {
+		return err
+	}
+	events, err := api.Notifications(sock)
+	if err != nil {
+		return err
 	}
 
-	for {
-		event := api.Decode_Nvim_Stream(sock, api.MES_NOTIFICATION)
+	event_loop(events)
+	return nil
+}
+
+/*
+ * A bad event is logged and otherwise ignored; only losing the connection to
+ * neovim (which closes the channel) ends the loop.
+ */
+func event_loop(events <-chan *mpack.Object) {
+	for event := range events {
 		event.Print(util.Logfiles["main"])
-		handle_nvim_event(event)
+		if err := handle_nvim_event(event); err != nil {
+			util.Eprintf("Error handling event: %s\n", err)
+		}
 	}
 }
 
@@ -191,25 +216,106 @@ func pkg(varname string) []byte {
 	return []byte("tag_highlight#" + varname)
 }
 
-func create_socket() int {
-	name := api.Nvim_call_function(1, []byte("serverstart"), mpack.E_STRING).(string)
+/*
+ * All settings are read from the dictionary g:tag_highlight#settings. Older
+ * configurations set each one as its own g:tag_highlight#<name> variable, so if
+ * the dictionary doesn't exist the individual variables are gathered into one of
+ * the same shape with a single nvim_eval. Missing settings are left at zero,
+ * except for enabled, which has to be turned off explicitly, and
+ * system_header_depth, where 0 means not to follow system headers at all.
+ */
+func load_settings() (settings_t, error) {
+	var (
+		settings = settings_t{Enabled: true, Sys_header_depth: default_sys_header_depth}
+		nerr     *api.NvimError
+	)
+
+	err := api.Nvim_get_var_into(0, pkg("settings"), &settings)
+	if errors.As(err, &nerr) {
+		var obj *mpack.Object
+		if obj, err = api.New_Client(0).Nvim_eval(legacy_settings_expr()); err == nil {
+			err = obj.Unmarshal(&settings)
+		}
+	}
+	if err != nil {
+		return settings, err
+	}
+
+	settings.Comp_type = get_compression_type(settings.Comp_name)
+	settings.Backend = get_backend(settings.Backend)
+	return settings, nil
+}
+
+func legacy_settings_expr() string {
+	var (
+		t    = reflect.TypeOf(settings_t{})
+		ents = make([]string, 0, t.NumField())
+	)
+
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("msgpack")
+		if name != "" && name != "-" {
+			ents = append(ents, fmt.Sprintf("'%s': get(g:, 'tag_highlight#%s', v:null)", name, name))
+		}
+	}
 
-	var addr syscall.SockaddrUnix
-	addr.Name = name
+	/* Unset variables are dropped rather than passed as v:null, which would
+	 * overwrite the defaults. */
+	return "filter({" + strings.Join(ents, ", ") + "}, 'v:val isnot v:null')"
+}
 
-	fd, e := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
-	if e != nil {
-		panic(e)
+/*
+ * Normally neovim starts us with jobstart() and talks to us over stdio. Buffer
+ * updates arrive there, and everything else goes over a second connection to
+ * an address we ask neovim for with serverstart().
+ *
+ * Given an address instead (with -addr, or taken from $NVIM when run from a
+ * terminal inside neovim) we attach to that editor and use the one connection
+ * for everything. This makes it possible to run the highlighter by hand, eg.
+ * under a debugger.
+ */
+func connect(addr string) error {
+	if addr == "" && is_terminal(os.Stdin) {
+		if addr = api.Server_Address(); addr == "" {
+			return errors.New("Not started by neovim, and no address given (use -addr)")
+		}
 	}
-	if e = syscall.Connect(fd, &addr); e != nil {
-		panic(e)
+
+	if addr != "" {
+		fd, err := api.Dial(addr)
+		if err != nil {
+			return err
+		}
+		api.Sockfd = fd
+		event_fd = fd
+		return nil
 	}
 
-	return fd
+	fd, err := create_socket()
+	if err != nil {
+		return err
+	}
+	api.Sockfd = fd
+	event_fd = 1
+	return nil
+}
+
+func create_socket() (int, error) {
+	tmp, err := api.Nvim_call_function(1, []byte("serverstart"), mpack.E_STRING)
+	if err != nil {
+		return (-1), err
+	}
+	name, _ := tmp.(string)
+
+	return api.Dial(name)
+}
+
+func is_terminal(fp *os.File) bool {
+	st, err := fp.Stat()
+	return err == nil && (st.Mode()&os.ModeCharDevice) != 0
 }
 
-func get_compression_type(fd int) uint16 {
-	tmp := api.Nvim_get_var(0, pkg("compression_type"), mpack.E_STRING).(string)
+func get_compression_type(tmp string) uint16 {
 	var ret uint16 = archive.COMP_NONE
 
 	switch tmp {
@@ -228,11 +334,13 @@ func get_compression_type(fd int) uint16 {
 }
 
 func (bdata *Bufdata) get_initial_lines() {
-	list := api.Nvim_buf_get_lines(0, int(bdata.Num), 0, (-1))
-	if bdata.Lines.Qty == 1 {
-		bdata.Lines.Delete_Node(bdata.Lines.Head)
+	list, err := api.Nvim_buf_get_lines(0, int(bdata.Num), 0, (-1))
+	if err != nil {
+		util.Eprintf("Failed to get the lines of buffer %d: %s\n", bdata.Num, err)
+		return
 	}
-	bdata.Lines.Insert_Slice_After(bdata.Lines.Head, sl2i(list)...)
+	bdata.Lines.Replace(0, (-1), list)
+	bdata.Tokens.Reset(list)
 	bdata.Initialized = true
 }
 
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestLoadSettingsEnabledByDefault(t *testing.T) {
+	f := new_test_fake(t)
+
+	/* Neither the dictionary nor any of the old variables. */
+	f.Eval[legacy_settings_expr()] = map[string]interface{}{}
+	if s, err := load_settings(); err != nil || !s.Enabled {
+		t.Errorf("no settings: enabled = %v (%v), want true", s.Enabled, err)
+	}
+
+	f.Eval[legacy_settings_expr()] = map[string]interface{}{"enabled": false}
+	if s, err := load_settings(); err != nil || s.Enabled {
+		t.Errorf("legacy g:tag_highlight#enabled = 0: enabled = %v (%v), want false", s.Enabled, err)
+	}
+
+	f.Set_Var("tag_highlight#settings", map[string]interface{}{"verbose": true})
+	if s, err := load_settings(); err != nil || !s.Enabled || !s.Verbose {
+		t.Errorf("settings without enabled: got %+v (%v)", s, err)
+	}
+
+	f.Set_Var("tag_highlight#settings", map[string]interface{}{"enabled": false})
+	if s, err := load_settings(); err != nil || s.Enabled {
+		t.Errorf("settings with enabled = false: enabled = %v (%v), want false", s.Enabled, err)
+	}
+}
+
+func TestLoadSettingsSysHeaderDepth(t *testing.T) {
+	f := new_test_fake(t)
+
+	f.Set_Var("tag_highlight#settings", map[string]interface{}{})
+	if s, err := load_settings(); err != nil || s.Sys_header_depth != default_sys_header_depth {
+		t.Errorf("unset: depth = %d (%v), want %d", s.Sys_header_depth, err, default_sys_header_depth)
+	}
+
+	f.Set_Var("tag_highlight#settings", map[string]interface{}{"system_header_depth": 0})
+	if s, err := load_settings(); err != nil || s.Sys_header_depth != 0 {
+		t.Errorf("set to 0: depth = %d (%v), want 0", s.Sys_header_depth, err)
+	}
+
+	f.Set_Var("tag_highlight#settings", map[string]interface{}{"system_header_depth": 1})
+	if s, err := load_settings(); err != nil || s.Sys_header_depth != 1 {
+		t.Errorf("set to 1: depth = %d (%v), want 1", s.Sys_header_depth, err)
+	}
+}
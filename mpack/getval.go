@@ -51,12 +51,24 @@ func (obj *Object) Get_Bool() bool {
 	return ret
 }
 
+func (obj *Object) Get_Float() float64 {
+	switch obj.Mtype {
+	case T_FLOAT:
+		return obj.Data.(float64)
+	case T_NUM:
+		return float64(obj.Data.(int64))
+	default:
+		errm(obj, "float")
+		return 0
+	}
+}
+
 func (obj *Object) Get_String() string {
-	if obj.Mtype != T_STRING {
+	if obj.Mtype != T_STRING && obj.Mtype != T_BIN {
 		errm(obj, "string")
 		return ""
 	}
-	return obj.Data.(string)
+	return string(obj.Data.([]byte))
 }
 
 func (obj *Object) Get_StrList() []string {
@@ -92,7 +104,8 @@ func (obj *Object) Get_Map_Str_Str() map[string]string {
 		errm(obj, "map")
 		return nil
 	}
-	return mpack_map_to_str_str(obj)
+	ret, _ := mpack_map_to_str_str(obj)
+	return ret
 }
 
 func (obj *Object) Get_Map_Str_StrList() map[string][]string {
@@ -100,5 +113,9 @@ func (obj *Object) Get_Map_Str_StrList() map[string][]string {
 		errm(obj, "map")
 		return nil
 	}
-	return mpack_map_to_str_strlist(obj)
+	ret, err := mpack_map_to_str_strlist(obj)
+	if err != nil {
+		log.Printf("WARNING: %s\n", err)
+	}
+	return ret
 }
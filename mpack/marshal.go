@@ -0,0 +1,542 @@
+package mpack
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+/*
+ * Conversion between go values and msgpack by reflection.
+ *
+ * Struct fields are named by a `msgpack:"name"` tag. Without one the field name
+ * is used, converted to lower case (so `Comp_level` becomes "comp_level"). A tag
+ * of "-" skips the field altogether, and ",omitempty" leaves a field out of the
+ * encoded map when it holds its zero value. Unexported fields are ignored.
+ *
+ * Nil maps and slices are encoded as empty ones, as Encode_Value does, since
+ * neovim rejects nil where it wants a dictionary or an array. Only nil pointers
+ * and interfaces become nil.
+ *
+ * Structs are always encoded as maps. When decoding, a map fills fields by name
+ * (unknown keys are ignored) and an array fills them by position, in the order
+ * they are declared. The latter is what neovim uses for event arguments.
+ */
+
+// Marshal returns the msgpack encoding of v.
+func Marshal(v interface{}) (data []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			data = nil
+			err = fmt.Errorf("mpack: marshal failed: %v", r)
+		}
+	}()
+
+	data = make([]byte, 0, 128)
+	if err = marshal_value(&data, reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Unmarshal decodes the first object in data and stores it in the value v
+// points to.
+func Unmarshal(data []byte, v interface{}) error {
+	obj, err := Decode_Bytes(data)
+	if err != nil {
+		return err
+	}
+	return obj.Unmarshal(v)
+}
+
+// Unmarshal stores an already decoded object in the value v points to.
+func (obj *Object) Unmarshal(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("mpack: Unmarshal requires a non-nil pointer (got %T)", v)
+	}
+	return unmarshal_value(obj, rv.Elem())
+}
+
+//========================================================================================
+
+type field_info struct {
+	name      string
+	index     int
+	omitempty bool
+}
+
+var (
+	field_cache       = make(map[reflect.Type][]field_info, 16)
+	field_cache_mutex sync.RWMutex
+)
+
+func struct_fields(t reflect.Type) []field_info {
+	field_cache_mutex.RLock()
+	fields, ok := field_cache[t]
+	field_cache_mutex.RUnlock()
+	if ok {
+		return fields
+	}
+
+	fields = make([]field_info, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		var (
+			tag  = sf.Tag.Get("msgpack")
+			name = strings.ToLower(sf.Name)
+			info = field_info{index: i}
+		)
+		if tag == "-" {
+			continue
+		}
+		if tag != "" {
+			opts := strings.Split(tag, ",")
+			if opts[0] != "" {
+				name = opts[0]
+			}
+			for _, opt := range opts[1:] {
+				if opt == "omitempty" {
+					info.omitempty = true
+				}
+			}
+		}
+		info.name = name
+		fields = append(fields, info)
+	}
+
+	field_cache_mutex.Lock()
+	field_cache[t] = fields
+	field_cache_mutex.Unlock()
+
+	return fields
+}
+
+//========================================================================================
+
+var (
+	object_type = reflect.TypeOf(Object{})
+	ext_type    = reflect.TypeOf(Ext{})
+)
+
+func marshal_value(buf *[]byte, v reflect.Value) error {
+	if !v.IsValid() {
+		pack_nil(buf)
+		return nil
+	}
+
+	switch v.Type() {
+	case object_type:
+		obj := v.Interface().(Object)
+		return marshal_object(buf, &obj)
+	case ext_type:
+		ext := v.Interface().(Ext)
+		pack_ext(buf, ext.Etype, ext.payload())
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			pack_nil(buf)
+			return nil
+		}
+		return marshal_value(buf, v.Elem())
+
+	case reflect.Bool:
+		pack_boolean(buf, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		pack_integer(buf, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		pack_unsigned(buf, v.Uint())
+	case reflect.Float32:
+		pack_float32(buf, float32(v.Float()))
+	case reflect.Float64:
+		pack_float(buf, v.Float())
+	case reflect.String:
+		pack_string(buf, []byte(v.String()))
+
+	case reflect.Slice, reflect.Array:
+		/* Neovim has no use for the bin type, so bytes are sent as strings. */
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Kind() == reflect.Array {
+				str := make([]byte, v.Len())
+				reflect.Copy(reflect.ValueOf(str), v)
+				pack_string(buf, str)
+			} else {
+				pack_string(buf, v.Bytes())
+			}
+			return nil
+		}
+		pack_array(buf, uint(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			if err := marshal_value(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		pack_map(buf, uint(v.Len()))
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := marshal_value(buf, iter.Key()); err != nil {
+				return err
+			}
+			if err := marshal_value(buf, iter.Value()); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Struct:
+		return marshal_struct(buf, v)
+
+	default:
+		return fmt.Errorf("mpack: cannot marshal value of type %s", v.Type())
+	}
+
+	return nil
+}
+
+func marshal_struct(buf *[]byte, v reflect.Value) error {
+	var (
+		fields = struct_fields(v.Type())
+		n      = 0
+	)
+	for _, f := range fields {
+		if !f.omitempty || !v.Field(f.index).IsZero() {
+			n++
+		}
+	}
+
+	pack_map(buf, uint(n))
+	for _, f := range fields {
+		fv := v.Field(f.index)
+		if f.omitempty && fv.IsZero() {
+			continue
+		}
+		pack_string(buf, []byte(f.name))
+		if err := marshal_value(buf, fv); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func marshal_object(buf *[]byte, obj *Object) error {
+	switch obj.Mtype {
+	case T_NIL:
+		pack_nil(buf)
+	case T_BOOL:
+		pack_boolean(buf, obj.Data.(bool))
+	case T_NUM:
+		pack_integer(buf, obj.Data.(int64))
+	case T_FLOAT:
+		pack_float(buf, obj.Data.(float64))
+	case T_STRING:
+		pack_string(buf, obj.Data.([]byte))
+	case T_BIN:
+		pack_binary(buf, obj.Data.([]byte))
+	case T_EXT:
+		ext := obj.Data.(Ext)
+		pack_ext(buf, ext.Etype, ext.payload())
+	case T_ARRAY:
+		arr := obj.Data.([]Object)
+		pack_array(buf, uint(len(arr)))
+		for i := range arr {
+			if err := marshal_object(buf, &arr[i]); err != nil {
+				return err
+			}
+		}
+	case T_MAP:
+		ents := obj.Data.([]Map_Entry)
+		pack_map(buf, uint(len(ents)))
+		for i := range ents {
+			if err := marshal_object(buf, &ents[i].Key); err != nil {
+				return err
+			}
+			if err := marshal_object(buf, &ents[i].Value); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("mpack: cannot marshal object of type %s", obj.TypeRepr())
+	}
+
+	return nil
+}
+
+//========================================================================================
+
+func unmarshal_error(obj *Object, t reflect.Type) error {
+	return &Type_Error{obj.TypeRepr(), t.String()}
+}
+
+func unmarshal_value(obj *Object, v reflect.Value) error {
+	switch v.Type() {
+	case object_type:
+		v.Set(reflect.ValueOf(*obj))
+		return nil
+	case ext_type:
+		if obj.Mtype != T_EXT {
+			return unmarshal_error(obj, v.Type())
+		}
+		v.Set(reflect.ValueOf(obj.Data.(Ext)))
+		return nil
+	}
+
+	if obj.Mtype == T_NIL {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return unmarshal_value(obj, v.Elem())
+
+	case reflect.Interface:
+		/* Decode into whatever concrete value is already there if it can be
+		 * decoded into, otherwise build the natural go value. */
+		if !v.IsNil() && v.Elem().Kind() == reflect.Ptr && !v.Elem().IsNil() {
+			return unmarshal_value(obj, v.Elem().Elem())
+		}
+		if v.NumMethod() != 0 {
+			return fmt.Errorf("mpack: cannot unmarshal into non-empty interface %s", v.Type())
+		}
+		val, err := obj.natural_value()
+		if err != nil {
+			return err
+		}
+		if val == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(val))
+		}
+
+	case reflect.Bool:
+		val, err := obj.Expect(T_BOOL)
+		if err != nil {
+			return err
+		}
+		v.SetBool(val.(bool))
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		val, err := obj.Expect(T_NUM)
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(val.(int64)) {
+			return fmt.Errorf("mpack: value %d overflows %s", val, v.Type())
+		}
+		v.SetInt(val.(int64))
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		val, err := obj.Expect(T_NUM)
+		if err != nil {
+			return err
+		}
+		if val.(int64) < 0 || v.OverflowUint(uint64(val.(int64))) {
+			return fmt.Errorf("mpack: value %d overflows %s", val, v.Type())
+		}
+		v.SetUint(uint64(val.(int64)))
+
+	case reflect.Float32, reflect.Float64:
+		val, err := obj.Expect(T_FLOAT)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(val.(float64))
+
+	case reflect.String:
+		if obj.Mtype != T_STRING && obj.Mtype != T_BIN {
+			return unmarshal_error(obj, v.Type())
+		}
+		v.SetString(string(obj.Data.([]byte)))
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && (obj.Mtype == T_STRING || obj.Mtype == T_BIN) {
+			v.SetBytes(append([]byte(nil), obj.Data.([]byte)...))
+			return nil
+		}
+		if obj.Mtype != T_ARRAY {
+			return unmarshal_error(obj, v.Type())
+		}
+		arr := obj.Data.([]Object)
+		slice := reflect.MakeSlice(v.Type(), len(arr), len(arr))
+		for i := range arr {
+			if err := unmarshal_value(&arr[i], slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 && (obj.Mtype == T_STRING || obj.Mtype == T_BIN) {
+			str := obj.Data.([]byte)
+			if len(str) > v.Len() {
+				return fmt.Errorf("mpack: string of %d bytes does not fit in %s", len(str), v.Type())
+			}
+			v.Set(reflect.Zero(v.Type()))
+			reflect.Copy(v, reflect.ValueOf(str))
+			return nil
+		}
+		if obj.Mtype != T_ARRAY {
+			return unmarshal_error(obj, v.Type())
+		}
+		arr := obj.Data.([]Object)
+		if len(arr) > v.Len() {
+			return fmt.Errorf("mpack: array of %d elements does not fit in %s", len(arr), v.Type())
+		}
+		v.Set(reflect.Zero(v.Type()))
+		for i := range arr {
+			if err := unmarshal_value(&arr[i], v.Index(i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		if obj.Mtype != T_MAP {
+			return unmarshal_error(obj, v.Type())
+		}
+		ents := obj.Data.([]Map_Entry)
+		m := reflect.MakeMapWithSize(v.Type(), len(ents))
+		for i := range ents {
+			key := reflect.New(v.Type().Key()).Elem()
+			val := reflect.New(v.Type().Elem()).Elem()
+			if err := unmarshal_value(&ents[i].Key, key); err != nil {
+				return err
+			}
+			if err := unmarshal_value(&ents[i].Value, val); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, val)
+		}
+		v.Set(m)
+
+	case reflect.Struct:
+		return unmarshal_struct(obj, v)
+
+	default:
+		return fmt.Errorf("mpack: cannot unmarshal into value of type %s", v.Type())
+	}
+
+	return nil
+}
+
+func unmarshal_struct(obj *Object, v reflect.Value) error {
+	fields := struct_fields(v.Type())
+
+	switch obj.Mtype {
+	case T_MAP:
+		for _, ent := range obj.Data.([]Map_Entry) {
+			if ent.Key.Mtype != T_STRING {
+				continue
+			}
+			name := string(ent.Key.Data.([]byte))
+			for _, f := range fields {
+				if f.name == name {
+					if err := unmarshal_value(&ent.Value, v.Field(f.index)); err != nil {
+						return fmt.Errorf("field '%s': %w", name, err)
+					}
+					break
+				}
+			}
+		}
+
+	case T_ARRAY:
+		arr := obj.Data.([]Object)
+		if len(arr) > len(fields) {
+			return fmt.Errorf("mpack: array of %d elements does not fit in %s", len(arr), v.Type())
+		}
+		for i := range arr {
+			if err := unmarshal_value(&arr[i], v.Field(fields[i].index)); err != nil {
+				return fmt.Errorf("field '%s': %w", fields[i].name, err)
+			}
+		}
+
+	default:
+		return unmarshal_error(obj, v.Type())
+	}
+
+	return nil
+}
+
+/*
+ * The value an object decodes to when nothing more specific was asked for.
+ * Maps with only string keys become map[string]interface{}, others
+ * map[interface{}]interface{}.
+ */
+func (obj *Object) natural_value() (interface{}, error) {
+	switch obj.Mtype {
+	case T_NIL:
+		return nil, nil
+	case T_BOOL, T_NUM, T_FLOAT, T_EXT:
+		return obj.Data, nil
+	case T_STRING:
+		return string(obj.Data.([]byte)), nil
+	case T_BIN:
+		return obj.Data.([]byte), nil
+
+	case T_ARRAY:
+		arr := obj.Data.([]Object)
+		ret := make([]interface{}, len(arr))
+		for i := range arr {
+			val, err := arr[i].natural_value()
+			if err != nil {
+				return nil, err
+			}
+			ret[i] = val
+		}
+		return ret, nil
+
+	case T_MAP:
+		ents := obj.Data.([]Map_Entry)
+		all_strings := true
+		for i := range ents {
+			if ents[i].Key.Mtype != T_STRING {
+				all_strings = false
+				break
+			}
+		}
+
+		if all_strings {
+			ret := make(map[string]interface{}, len(ents))
+			for i := range ents {
+				val, err := ents[i].Value.natural_value()
+				if err != nil {
+					return nil, err
+				}
+				ret[string(ents[i].Key.Data.([]byte))] = val
+			}
+			return ret, nil
+		}
+
+		ret := make(map[interface{}]interface{}, len(ents))
+		for i := range ents {
+			key, err := ents[i].Key.natural_value()
+			if err != nil {
+				return nil, err
+			}
+			if key != nil && !reflect.TypeOf(key).Comparable() {
+				return nil, errors.New("mpack: map key is not a valid go map key")
+			}
+			val, err := ents[i].Value.natural_value()
+			if err != nil {
+				return nil, err
+			}
+			ret[key] = val
+		}
+		return ret, nil
+
+	default:
+		return nil, fmt.Errorf("mpack: cannot unmarshal object of type %s", obj.TypeRepr())
+	}
+}
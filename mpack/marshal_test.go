@@ -0,0 +1,172 @@
+package mpack
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type tagged struct {
+	Plain   int
+	Renamed string   `msgpack:"name"`
+	Omitted []string `msgpack:"omitted,omitempty"`
+	Skipped bool     `msgpack:"-"`
+	private int
+}
+
+/* The shape of neovim's event arguments, which come as arrays. */
+type positional struct {
+	Buf   int
+	Ctick uint32
+	Lines []string
+	More  bool
+}
+
+func value_of(t *testing.T, data []byte) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestMarshalTags(t *testing.T) {
+	tests := []struct {
+		name string
+		in   tagged
+		want map[string]interface{}
+	}{
+		{"empty omitted", tagged{Plain: 1, Renamed: "x", Skipped: true, private: 2},
+			map[string]interface{}{"plain": int64(1), "name": "x"}},
+		{"set omitted", tagged{Renamed: "y", Omitted: []string{"a"}},
+			map[string]interface{}{"plain": int64(0), "name": "y", "omitted": []interface{}{"a"}}},
+	}
+
+	for _, tt := range tests {
+		data, err := Marshal(tt.in)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if got := value_of(t, data); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: encoded as %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalStruct(t *testing.T) {
+	/* By name, ignoring keys that don't belong and fields marked "-". */
+	data, _ := Marshal(map[string]interface{}{
+		"plain": 3, "name": "z", "omitted": []string{"b", "c"}, "skipped": true, "unknown": 1,
+	})
+	var got tagged
+	if err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := tagged{Plain: 3, Renamed: "z", Omitted: []string{"b", "c"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("by name: got %+v, want %+v", got, want)
+	}
+
+	/* By position. */
+	data, _ = Marshal([]interface{}{4, 17, []string{"x", "y"}, false})
+	var ev positional
+	if err := Unmarshal(data, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if want := (positional{4, 17, []string{"x", "y"}, false}); !reflect.DeepEqual(ev, want) {
+		t.Errorf("by position: got %+v, want %+v", ev, want)
+	}
+
+	/* A shorter array leaves the rest alone, a longer one doesn't fit. */
+	data, _ = Marshal([]interface{}{5})
+	if err := Unmarshal(data, &ev); err != nil || ev.Buf != 5 || ev.Ctick != 17 {
+		t.Errorf("short array: got %+v (%v)", ev, err)
+	}
+	data, _ = Marshal([]interface{}{1, 2, nil, true, "extra"})
+	if err := Unmarshal(data, &ev); err == nil {
+		t.Error("expected an error for an array longer than the struct")
+	}
+}
+
+func TestUnmarshalMismatch(t *testing.T) {
+	var (
+		i   int
+		u8  uint8
+		s   string
+		lst []int
+		m   map[string]int
+		st  positional
+		b   bool
+	)
+	tests := []struct {
+		name string
+		in   interface{}
+		out  interface{}
+	}{
+		{"string into int", "12", &i},
+		{"negative into uint", -1, &u8},
+		{"overflow", 300, &u8},
+		{"int into string", 12, &s},
+		{"map into slice", map[string]interface{}{"a": 1}, &lst},
+		{"array into map", []int{1}, &m},
+		{"string into struct", "x", &st},
+		{"bad field", map[string]interface{}{"lines": 1}, &st},
+		{"2 into bool", 2, &b},
+	}
+
+	for _, tt := range tests {
+		data, err := Marshal(tt.in)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if err = Unmarshal(data, tt.out); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+
+	data, _ := Marshal("x")
+	var terr *Type_Error
+	if err := Unmarshal(data, &i); !errors.As(err, &terr) {
+		t.Errorf("got %v, want a Type_Error", err)
+	}
+	if err := Unmarshal(data, i); err == nil {
+		t.Error("expected an error for a non-pointer")
+	}
+}
+
+/* Marshal and Encode_Value have to agree, nil maps and slices included. */
+func TestMarshalMatchesEncodeValue(t *testing.T) {
+	for _, v := range []interface{}{
+		map[string]interface{}(nil),
+		map[string]interface{}{"a": int64(1)},
+		[]interface{}(nil),
+		[]string(nil),
+		[]byte(nil),
+		[]int{1, 2},
+		"str",
+		nil,
+	} {
+		var root Object
+		cur := &root
+		root.Encode_Value(&cur, v)
+
+		data, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, root.packed) {
+			t.Errorf("%#v: Marshal gave % X, Encode_Value % X", v, data, root.packed)
+		}
+	}
+
+	data, _ := Marshal(map[string]int(nil))
+	if !bytes.Equal(data, []byte{mMASK_MAP_F}) {
+		t.Errorf("nil map encoded as % X", data)
+	}
+	data, _ = Marshal((*int)(nil))
+	if !bytes.Equal(data, []byte{mMASK_NIL}) {
+		t.Errorf("nil pointer encoded as % X", data)
+	}
+}
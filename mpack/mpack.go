@@ -1,7 +1,9 @@
 package mpack
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"tag_highlight/lists"
 	"tag_highlight/util"
 )
@@ -15,6 +17,8 @@ const ( // Mpack object types
 	T_STRING
 	T_ARRAY
 	T_MAP
+	T_FLOAT
+	T_BIN
 )
 const ( // Mpack flags
 	mFLAG_ENCODE = 0x01
@@ -37,9 +41,16 @@ const ( // Mpack encoding masks
 	mMASK_BIN_8     = uint8(0xC4)
 	mMASK_BIN_16    = uint8(0xC5)
 	mMASK_BIN_32    = uint8(0xC6)
-	mMASK_EXT_8     = uint8(0xD4)
-	mMASK_EXT_16    = uint8(0xD5)
-	mMASK_EXT_32    = uint8(0xD6)
+	mMASK_EXT_8     = uint8(0xC7)
+	mMASK_EXT_16    = uint8(0xC8)
+	mMASK_EXT_32    = uint8(0xC9)
+	mMASK_FIXEXT_1  = uint8(0xD4)
+	mMASK_FIXEXT_2  = uint8(0xD5)
+	mMASK_FIXEXT_4  = uint8(0xD6)
+	mMASK_FIXEXT_8  = uint8(0xD7)
+	mMASK_FIXEXT_16 = uint8(0xD8)
+	mMASK_FLOAT_32  = uint8(0xCA)
+	mMASK_FLOAT_64  = uint8(0xCB)
 	mMASK_INT_8     = uint8(0xD0)
 	mMASK_INT_16    = uint8(0xD1)
 	mMASK_INT_32    = uint8(0xD2)
@@ -56,9 +67,10 @@ const ( // Mpack encoding masks
 
 var (
 	DEBUG     = true
-	Type_Repr = [8]string{
+	Type_Repr = [10]string{
 		"mpack.T_UNINITIALIZED", "mpack.T_BOOL", "mpack.T_NIL", "mpack.T_NUM",
-		"mpack.T_EXT", "mpack.T_STRING", "mpack.T_ARRAY", "mpack.T_MAP"}
+		"mpack.T_EXT", "mpack.T_STRING", "mpack.T_ARRAY", "mpack.T_MAP",
+		"mpack.T_FLOAT", "mpack.T_BIN"}
 )
 
 type Object struct {
@@ -72,9 +84,47 @@ type Map_Entry struct {
 	Key, Value Object
 }
 
+/*
+ * Neovim only uses ext types for buffer, window and tabpage handles, in which
+ * case the payload is itself an encoded integer and Num holds its value. The raw
+ * payload is always kept in Data.
+ */
 type Ext struct {
 	Etype int8
 	Num   uint32
+	Data  []byte
+}
+
+func new_ext(etype int8, data []byte) Ext {
+	ext := Ext{Etype: etype, Data: data}
+	if obj, err := decode_exact(data); err == nil && obj.Mtype == T_NUM {
+		ext.Num = uint32(obj.Data.(int64))
+	}
+	return ext
+}
+
+/*
+ * An Ext built by hand with only a handle number gets the payload neovim itself
+ * would use, ie. the number encoded as an integer.
+ */
+func (ext *Ext) payload() []byte {
+	if ext.Data != nil {
+		return ext.Data
+	}
+	var tmp Object
+	cur := &tmp
+	tmp.Encode_Integer(&cur, int64(ext.Num))
+	return tmp.packed
+}
+
+// A Type_Error is returned whenever an object is not of (and cannot be
+// converted to) the type the caller asked for.
+type Type_Error struct {
+	Got, Expected string
+}
+
+func (e *Type_Error) Error() string {
+	return fmt.Sprintf("mpack: got value of type %s (expected %s)", e.Got, e.Expected)
 }
 
 //========================================================================================
@@ -95,6 +145,19 @@ func (obj *Object) MapEnt(index int) *Map_Entry {
 	return &(obj.Data.([]Map_Entry)[index])
 }
 
+// Len returns the number of elements in an array or entries in a map, and 0 for
+// anything else.
+func (obj *Object) Len() int {
+	switch obj.Mtype {
+	case T_ARRAY:
+		return len(obj.Data.([]Object))
+	case T_MAP:
+		return len(obj.Data.([]Map_Entry))
+	default:
+		return 0
+	}
+}
+
 func (obj *Object) GetPack() []byte {
 	if (obj.flags & mFLAG_PACKED) != 0 {
 		return obj.packed
@@ -114,18 +177,31 @@ func two_thirds(val uint) uint {
 	return ((val * 2) / 3)
 }
 
-func Encode_fmt(size_hint uint, format string, args ...interface{}) *Object {
+/*
+ * A bad format string or an argument of the wrong type is a bug in the caller,
+ * but it is still reported as an error rather than taking the whole process down
+ * with it.
+ */
+func Encode_fmt(size_hint uint, format string, args ...interface{}) (pack *Object, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			pack = nil
+			err = fmt.Errorf("mpack: encoding failed: %v", r)
+		}
+	}()
+
 	var (
 		arr_size    uint   = 128 + (2 * size_hint)
 		sub_lengths []uint = make([]uint, arr_size)
 		cur_len     *uint  = &sub_lengths[0]
 		len_ctr     uint   = 0
-		len_stack          = lists.New_Stack(int(two_thirds(arr_size)), 0)
+		len_stack          = lists.New_Stack[*uint](int(two_thirds(arr_size)), nil)
 	)
 
 	for _, ch := range format {
 		switch ch {
-		case 'b', 'B', 'l', 'L', 'd', 'D', 's', 'S', 'c', 'C', 'n', 'N':
+		case 'b', 'B', 'l', 'L', 'd', 'D', 's', 'S', 'c', 'C', 'n', 'N', 'v', 'V',
+			'f', 'F', 'x', 'X', 'e', 'E':
 			*cur_len++
 		case '[', '{':
 			*cur_len++
@@ -133,32 +209,32 @@ func Encode_fmt(size_hint uint, format string, args ...interface{}) *Object {
 			len_ctr++
 			cur_len = &sub_lengths[len_ctr]
 		case ']', '}':
-			cur_len = len_stack.Pop().(*uint)
+			cur_len = len_stack.Pop()
 
 		case ':', '.', ' ', ',', '!', '@', '*':
 		default:
-			panic(fmt.Sprintf("Illegal character '%c' in format.", ch))
+			return nil, fmt.Errorf("mpack: illegal character '%c' in format", ch)
 		}
 	}
 	if sub_lengths[0] == 0 {
-		return nil
+		return nil, errors.New("mpack: empty format")
 	}
+	pack = Make_New(sub_lengths[0], true)
 
 	var (
-		pack            *Object          = Make_New(sub_lengths[0], true)
-		cur_obj         *Object          = pack.Index(0)
-		ref             *[]interface{}   = nil
-		ref_list        *[][]interface{} = nil
-		obj_stack       *lists.Stack     = lists.New_Stack(int(two_thirds(arr_size)), nil)
-		map_stack       *lists.Stack     = lists.New_Stack(int(two_thirds(arr_size)), 0)
-		sub_ctrlist     []uint           = make([]uint, two_thirds(arr_size))
-		cur_ctr         *uint            = &sub_ctrlist[0]
-		sub_ctrlist_ctr uint             = 0
-		args_ctr        uint             = 0
-		ref_ctr         uint             = 0
-		rl_ref_ctr      uint             = 0
-		rl_arg_ctr      uint             = 0
-		next_type       int              = mOWN_ARGS
+		cur_obj         *Object               = pack.Index(0)
+		ref             *[]interface{}        = nil
+		ref_list        *[][]interface{}      = nil
+		obj_stack       *lists.Stack[*Object] = lists.New_Stack[*Object](int(two_thirds(arr_size)), nil)
+		map_stack       *lists.Stack[bool]    = lists.New_Stack(int(two_thirds(arr_size)), false)
+		sub_ctrlist     []uint                = make([]uint, two_thirds(arr_size))
+		cur_ctr         *uint                 = &sub_ctrlist[0]
+		sub_ctrlist_ctr uint                  = 0
+		args_ctr        uint                  = 0
+		ref_ctr         uint                  = 0
+		rl_ref_ctr      uint                  = 0
+		rl_arg_ctr      uint                  = 0
+		next_type       int                   = mOWN_ARGS
 	)
 
 	len_ctr = 0
@@ -212,9 +288,24 @@ func Encode_fmt(size_hint uint, format string, args ...interface{}) *Object {
 		case 'n', 'N':
 			pack.Encode_Nil(&cur_obj)
 
+		case 'f', 'F':
+			var arg float64 = next_arg(true).(float64)
+			pack.Encode_Float(&cur_obj, arg)
+
+		case 'x', 'X':
+			var arg []byte = next_arg(true).([]byte)
+			pack.Encode_Binary(&cur_obj, arg)
+
+		case 'e', 'E':
+			var arg Ext = next_arg(true).(Ext)
+			pack.Encode_Ext(&cur_obj, arg.Etype, arg.payload())
+
+		case 'v', 'V':
+			pack.Encode_Value(&cur_obj, next_arg(true))
+
 		case '[':
 			len_ctr++
-			map_stack.Push(0)
+			map_stack.Push(false)
 			pack.Encode_Array(&cur_obj, sub_lengths[len_ctr])
 
 			obj_stack.Push(cur_obj)
@@ -224,7 +315,7 @@ func Encode_fmt(size_hint uint, format string, args ...interface{}) *Object {
 
 		case '{':
 			len_ctr++
-			map_stack.Push(1)
+			map_stack.Push(true)
 			pack.Encode_Map(&cur_obj, sub_lengths[len_ctr]/2)
 
 			obj_stack.Push(cur_obj)
@@ -235,7 +326,7 @@ func Encode_fmt(size_hint uint, format string, args ...interface{}) *Object {
 		case ']', '}':
 			obj_stack.Pop()
 			map_stack.Pop()
-			cur_ctr = len_stack.Pop().(*uint)
+			cur_ctr = len_stack.Pop()
 
 		case '!':
 			ref = next_arg(false).(*[]interface{})
@@ -266,26 +357,26 @@ func Encode_fmt(size_hint uint, format string, args ...interface{}) *Object {
 			panic("Not reachable.")
 		}
 
-		if map_stack.Peek().(int) != 0 {
-			x := uint(cap(obj_stack.Peek().(*Object).Data.([]Map_Entry)))
+		if map_stack.Peek() {
+			x := uint(cap(obj_stack.Peek().Data.([]Map_Entry)))
 			if x > (*cur_ctr / 2) {
 				if (*cur_ctr & 1) == 0 {
-					cur_obj = &(obj_stack.Peek().(*Object).MapEnt(int(*cur_ctr / 2)).Key)
+					cur_obj = &(obj_stack.Peek().MapEnt(int(*cur_ctr / 2)).Key)
 				} else {
-					cur_obj = &(obj_stack.Peek().(*Object).MapEnt(int(*cur_ctr / 2)).Value)
+					cur_obj = &(obj_stack.Peek().MapEnt(int(*cur_ctr / 2)).Value)
 				}
 			}
 		} else {
-			x := uint(cap(obj_stack.Peek().(*Object).Data.([]Object)))
+			x := uint(cap(obj_stack.Peek().Data.([]Object)))
 			if x > *cur_ctr {
-				cur_obj = obj_stack.Peek().(*Object).Index(int(*cur_ctr))
+				cur_obj = obj_stack.Peek().Index(int(*cur_ctr))
 			}
 		}
 
 		*cur_ctr++
 	}
 
-	return pack
+	return pack, nil
 }
 
 //========================================================================================
@@ -310,18 +401,20 @@ var expect_repr_strings = [11]string{
 	"E_MAP_RUNE_RUNE",
 }
 
-func (obj *Object) Expect(expect int) interface{} {
+func (obj *Object) Expect(expect int) (interface{}, error) {
 	/* util.Eprintf("Got type type %s (%d) (expected %s (%d))\n",
 	expect_repr(int(obj.Mtype)), obj.Mtype, expect_repr(expect), expect) */
 
 	if obj.Mtype == T_NIL {
-		util.Eprintf("Expect: got nil value.\n")
-		if expect == T_NUM {
-			return 0
-		} else if expect == T_BOOL {
-			return false
+		switch expect {
+		case T_NUM:
+			return int64(0), nil
+		case T_BOOL:
+			return false, nil
+		case T_FLOAT:
+			return float64(0), nil
 		}
-		return nil
+		return nil, nil
 	}
 
 	if int(obj.Mtype) != expect {
@@ -329,43 +422,58 @@ func (obj *Object) Expect(expect int) interface{} {
 		case T_EXT:
 			switch expect {
 			case T_NUM:
-				return int64(obj.Data.(Ext).Num)
+				return int64(obj.Data.(Ext).Num), nil
 			}
 		case T_NUM:
 			switch expect {
 			case T_BOOL:
 				val := obj.Data.(int64)
 				if val == 0 {
-					return false
+					return false, nil
 				} else if val == 1 {
-					return true
+					return true, nil
+				}
+			case T_FLOAT:
+				return float64(obj.Data.(int64)), nil
+			}
+		case T_FLOAT:
+			/* Vimscript happily turns 2 into 2.0, so allow the reverse as
+			 * long as nothing is lost. */
+			switch expect {
+			case T_NUM:
+				/* 1<<63 can't be represented as an int64, while as a float
+				 * math.MaxInt64 rounds up to it. NaN fails every comparison
+				 * anyway, but is best not left to that. */
+				val := obj.Data.(float64)
+				if !math.IsNaN(val) && val == math.Trunc(val) && val >= -(1<<63) && val < (1<<63) {
+					return int64(val), nil
 				}
 			}
-		case T_STRING:
+		case T_STRING, T_BIN:
 			switch expect {
-			case E_BYTES:
-				return obj.Data.([]byte)
+			case T_STRING, T_BIN, E_BYTES:
+				return obj.Data.([]byte), nil
 			case E_STRING:
-				return string(obj.Data.([]byte))
+				return string(obj.Data.([]byte)), nil
 			}
 		case T_ARRAY:
 			switch expect {
 			case E_BYTELIST:
 				lst := make([][]byte, 0, 32)
 				for _, elem := range obj.Data.([]Object) {
-					if elem.Mtype == T_STRING {
+					if elem.Mtype == T_STRING || elem.Mtype == T_BIN {
 						lst = append(lst, elem.Data.([]byte))
 					}
 				}
-				return lst
+				return lst, nil
 			case E_STRLIST:
 				lst := make([]string, 0, 32)
 				for _, elem := range obj.Data.([]Object) {
-					if elem.Mtype == T_STRING {
+					if elem.Mtype == T_STRING || elem.Mtype == T_BIN {
 						lst = append(lst, string(elem.Data.([]byte)))
 					}
 				}
-				return lst
+				return lst, nil
 			case E_STRPTRLIST:
 				lst := make([]*string, 0, 32)
 				for _, elem := range obj.Data.([]Object) {
@@ -374,16 +482,17 @@ func (obj *Object) Expect(expect int) interface{} {
 						lst = append(lst, &tmp)
 					}
 				}
-				return lst
+				return lst, nil
 			case E_INTLIST:
 				lst := make([]int, 0, 32)
 				for _, elem := range obj.Data.([]Object) {
-					lst = append(lst, int(elem.Expect(T_NUM).(int64)))
-				}
-				if len(lst) == 0 {
-					panic("No integers found in array.")
+					val, err := elem.Expect(T_NUM)
+					if err != nil {
+						return nil, err
+					}
+					lst = append(lst, int(val.(int64)))
 				}
-				return lst
+				return lst, nil
 			}
 		case T_MAP:
 			switch expect {
@@ -400,39 +509,43 @@ func (obj *Object) Expect(expect int) interface{} {
 			}
 		}
 
-		util.Eprintf("WARNING: Got unexpected value of type %s (expected %s)\n",
-			expect_repr(int(obj.Mtype)), expect_repr(expect))
-		return nil
+		return nil, &Type_Error{expect_repr(int(obj.Mtype)), expect_repr(expect)}
 	}
 
 	switch expect {
 	case T_ARRAY:
-		return obj.Data.([]Object)
+		return obj.Data.([]Object), nil
 	case T_MAP:
-		return obj.Data.([]Map_Entry)
-	case T_STRING:
-		return obj.Data.([]byte)
+		return obj.Data.([]Map_Entry), nil
+	case T_STRING, T_BIN:
+		return obj.Data.([]byte), nil
+	case T_FLOAT:
+		return obj.Data.(float64), nil
 	case T_EXT:
-		return obj.Data.(Ext)
+		return obj.Data.(Ext), nil
 	case T_NUM:
-		return obj.Data.(int64)
+		return obj.Data.(int64), nil
 	case T_BOOL:
-		return obj.Data.(bool)
+		return obj.Data.(bool), nil
 	default:
-		panic(fmt.Sprintf("Invalid type given to expect (%s) (obj type '%s').",
-			expect_repr(expect), Type_Repr[obj.Mtype]))
+		return nil, fmt.Errorf("mpack: invalid type given to expect (%s) (obj type '%s')",
+			expect_repr(expect), obj.TypeRepr())
 	}
 }
 
 func expect_repr(expect int) string {
-	if expect < 256 {
+	switch {
+	case expect >= 0 && expect < len(Type_Repr):
 		return Type_Repr[expect]
+	case expect >= 256 && expect-256 < len(expect_repr_strings):
+		return expect_repr_strings[expect-256]
+	default:
+		return fmt.Sprintf("<invalid type %d>", expect)
 	}
-	return expect_repr_strings[expect-256]
 }
 
 func (obj *Object) TypeRepr() string {
-	return Type_Repr[obj.Mtype]
+	return expect_repr(int(obj.Mtype))
 }
 
 func mmap_ent_str_conv(obj *Object) string {
@@ -444,7 +557,7 @@ func mmap_ent_str_conv(obj *Object) string {
 	return fmt.Sprintf("%v", obj.Data)
 }
 
-func mpack_map_to_str_str(obj *Object) map[string]string {
+func mpack_map_to_str_str(obj *Object) (map[string]string, error) {
 	tmp := obj.Data.([]Map_Entry)
 	var ret = make(map[string]string, len(tmp))
 
@@ -454,57 +567,71 @@ func mpack_map_to_str_str(obj *Object) map[string]string {
 		ret[key] = val
 	}
 
-	return ret
+	return ret, nil
 }
 
-func mpack_map_to_str_strlist(obj *Object) map[string][]string {
+func mpack_map_to_str_strlist(obj *Object) (map[string][]string, error) {
 	tmp := obj.Data.([]Map_Entry)
 	var ret = make(map[string][]string, len(tmp))
 
 	for _, ent := range tmp {
 		key := mmap_ent_str_conv(&ent.Key)
-		val := ent.Value.Expect(E_STRLIST).([]string)
-		ret[key] = val
+		val, err := ent.Value.Expect(E_STRLIST)
+		if err != nil {
+			return nil, err
+		}
+		ret[key], _ = val.([]string)
 	}
 
-	return ret
+	return ret, nil
 }
 
-func mpack_map_to_str_bytes(obj *Object) map[string][]byte {
+func mpack_map_to_str_bytes(obj *Object) (map[string][]byte, error) {
 	tmp := obj.Data.([]Map_Entry)
 	var ret = make(map[string][]byte, len(tmp))
 
 	for _, ent := range tmp {
 		key := mmap_ent_str_conv(&ent.Key)
-		val := ent.Value.Data.([]byte)
-		ret[key] = val
+		val, err := ent.Value.Expect(E_BYTES)
+		if err != nil {
+			return nil, err
+		}
+		ret[key], _ = val.([]byte)
 	}
 
-	return ret
+	return ret, nil
 }
 
-func mpack_map_to_str_bytelist(obj *Object) map[string][][]byte {
+func mpack_map_to_str_bytelist(obj *Object) (map[string][][]byte, error) {
 	tmp := obj.Data.([]Map_Entry)
 	var ret = make(map[string][][]byte, len(tmp))
 
 	for _, ent := range tmp {
 		key := mmap_ent_str_conv(&ent.Key)
-		val := ent.Value.Expect(E_BYTELIST).([][]byte)
-		ret[key] = val
+		val, err := ent.Value.Expect(E_BYTELIST)
+		if err != nil {
+			return nil, err
+		}
+		ret[key], _ = val.([][]byte)
 	}
 
-	return ret
+	return ret, nil
 }
 
-func mpack_map_to_rune_rune(obj *Object) map[rune]rune {
+func mpack_map_to_rune_rune(obj *Object) (map[rune]rune, error) {
 	tmp := obj.Data.([]Map_Entry)
 	var ret = make(map[rune]rune, len(tmp))
 
 	for _, ent := range tmp {
+		if ent.Key.Mtype != T_STRING || ent.Value.Mtype != T_STRING ||
+			len(ent.Key.Data.([]byte)) == 0 || len(ent.Value.Data.([]byte)) == 0 {
+			return nil, &Type_Error{"map of " + ent.Key.TypeRepr() + " => " + ent.Value.TypeRepr(),
+				expect_repr(E_MAP_RUNE_RUNE)}
+		}
 		key := rune(ent.Key.Data.([]byte)[0])
 		val := rune(ent.Value.Data.([]byte)[0])
 		ret[key] = val
 	}
 
-	return ret
+	return ret, nil
 }
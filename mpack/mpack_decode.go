@@ -1,11 +1,12 @@
 package mpack
 
 import (
-	// "bytes"
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
-	"sync"
-	"syscall"
-	// "tag_highlight/util"
+	"io"
+	"math"
 )
 
 const (
@@ -20,6 +21,7 @@ const (
 	grp_PLINT
 	grp_NLINT
 	grp_EXT
+	grp_FLOAT
 )
 const (
 	m_NIL = iota
@@ -59,10 +61,14 @@ const (
 	m_EXT_F1
 	m_EXT_F2
 	m_EXT_F4
+	m_EXT_F8
+	m_EXT_F16
+	m_FLOAT_32
+	m_FLOAT_64
 )
 const errmsg string = "Error: default reached somehow."
 
-type read_fn func(src *interface{}, dest []byte, nbytes uint)
+type read_fn func(src *interface{}, dest []byte, nbytes uint) error
 type mpack_mask struct {
 	group, mtype int
 	fixed        bool
@@ -70,7 +76,7 @@ type mpack_mask struct {
 	repr         string
 }
 
-var m_masks = [29]mpack_mask{
+var m_masks = [36]mpack_mask{
 	{grp_NIL, m_NIL, false, 0xC0, 0, "m_NIL"},
 	{grp_BOOL, m_TRUE, false, 0xC3, 0, "m_TRUE"},
 	{grp_BOOL, m_FALSE, false, 0xC2, 0, "m_FALSE"},
@@ -92,9 +98,16 @@ var m_masks = [29]mpack_mask{
 	{grp_UINT, m_UINT_16, false, 0xCD, 0, "m_UINT_16"},
 	{grp_UINT, m_UINT_32, false, 0xCE, 0, "m_UINT_32"},
 	{grp_UINT, m_UINT_64, false, 0xCF, 0, "m_UINT_64"},
+	{grp_EXT, m_EXT_8, false, 0xC7, 0, "m_EXT_8"},
+	{grp_EXT, m_EXT_16, false, 0xC8, 0, "m_EXT_16"},
+	{grp_EXT, m_EXT_32, false, 0xC9, 0, "m_EXT_32"},
 	{grp_EXT, m_EXT_F1, false, 0xD4, 0, "m_EXT_F1"},
 	{grp_EXT, m_EXT_F2, false, 0xD5, 0, "m_EXT_F2"},
 	{grp_EXT, m_EXT_F4, false, 0xD6, 0, "m_EXT_F4"},
+	{grp_EXT, m_EXT_F8, false, 0xD7, 0, "m_EXT_F8"},
+	{grp_EXT, m_EXT_F16, false, 0xD8, 0, "m_EXT_F16"},
+	{grp_FLOAT, m_FLOAT_32, false, 0xCA, 0, "m_FLOAT_32"},
+	{grp_FLOAT, m_FLOAT_64, false, 0xCB, 0, "m_FLOAT_64"},
 	{grp_STRING, m_FIXSTR_F, true, 0xA0, 5, "m_FIXSTR_F"},
 	{grp_ARRAY, m_ARRAY_F, true, 0x90, 4, "m_ARRAY_F"},
 	{grp_MAP, m_FIXMAP_F, true, 0x80, 4, "m_FIXMAP_F"},
@@ -104,148 +117,91 @@ var m_masks = [29]mpack_mask{
 
 //========================================================================================
 
-var (
-	// readbuffer           = make([]byte, 0x8000)
-	// leftover      []byte = nil
-	another_mutex = new(sync.Mutex)
-)
+/*
+ * A Decoder reads consecutive objects from a stream. Everything goes through a
+ * buffer, so decoding a large message costs a handful of reads rather than one
+ * for every field, and a message split across several reads is reassembled
+ * transparently. A Decoder is not safe for concurrent use.
+ */
+type Decoder struct {
+	rd *bufio.Reader
+}
 
-func Decode_Stream(fd int) *Object {
-	var tmp interface{} = fd
-	// var (
-	//         tmp   interface{} = fd
-	//         b     []byte
-	//         err   error
-	//         nread int
-	// )
-	// another_mutex.Lock()
-	// defer another_mutex.Unlock()
-	//
-	// do_read_op := func(block bool) {
-	//         if leftover == nil {
-	//                 if block {
-	//                         // if n, e := syscall.Select(1, &syscall.FdSet{Bits: [16]int64{int64(fd)}}, nil, nil, &syscall.Timeval{Sec: 3, Usec: 0}); e != nil {
-	//                         //         panic(e)
-	//                         // } else {
-	//                         //         util.Eprintf("Got %d...\n", n)
-	//                         // }
-	//                         nread, _, err = syscall.Recvfrom(fd, readbuffer, 0)
-	//                         if err != nil {
-	//                                 panic(err)
-	//                         }
-	//                 } else {
-	//                         nread, _, err = syscall.Recvfrom(fd, readbuffer, syscall.MSG_DONTWAIT)
-	//                         if err != nil && err != syscall.EAGAIN && err != syscall.EWOULDBLOCK {
-	//                                 panic(err)
-	//                         }
-	//                         if nread > 0 {
-	//                                 b = append(b, readbuffer[:nread]...)
-	//                         }
-	//                         return
-	//                 }
-	//                 if nread == len(readbuffer) {
-	//                         tmp := bytes.NewBuffer(readbuffer)
-	//                         // tmp.Read(readbuffer)
-	//
-	//                         for {
-	//                                 nread, _, err = syscall.Recvfrom(fd, readbuffer, syscall.MSG_DONTWAIT)
-	//                                 if err != nil && err != syscall.EAGAIN && err != syscall.EWOULDBLOCK {
-	//                                         panic(err)
-	//                                 }
-	//                                 if nread > 0 {
-	//                                         tmp.Read(readbuffer[:nread])
-	//                                 } else {
-	//                                         break
-	//                                 }
-	//                         }
-	//                         b = tmp.Bytes()
-	//                 } else {
-	//                         b = append(b, readbuffer[:nread]...)
-	//                 }
-	//                 util.Eprintf("read %d bytes with err %s\n", nread, err)
-	//         } else {
-	//                 util.Eprintf("There are leftovers (%d) -> %d\n", len(leftover), leftover)
-	//                 // var tmp *bytes.Buffer = bytes.NewBuffer(leftover)
-	//                 tmp := bytes.NewBuffer(leftover)
-	//                 util.Eprintf("Read: %d -> %v\n", tmp.Len(), tmp.Bytes())
-	//
-	//                 nread, _, err = syscall.Recvfrom(fd, readbuffer, syscall.MSG_DONTWAIT)
-	//                 if err != nil && err != syscall.EAGAIN && err != syscall.EWOULDBLOCK {
-	//                         panic(err)
-	//                 }
-	//                 if nread > 0 {
-	//                         tmp.Read(leftover)
-	//                 }
-	//                 for nread > 0 {
-	//                         nread, _, err = syscall.Recvfrom(fd, readbuffer, syscall.MSG_DONTWAIT)
-	//                         if err != nil && err != syscall.EAGAIN && err != syscall.EWOULDBLOCK {
-	//                                 panic(err)
-	//                         }
-	//                         tmp.Read(readbuffer[:nread])
-	//                 }
-	//                 b = tmp.Bytes()
-	//                 util.Eprintf("read: %d, e: %s\n", len(b), err)
-	//                 leftover = nil
-	//         }
-	// }
-	// do_read_op(true)
-
-	ret := do_decode(
-		func(src *interface{}, dest []byte, nbytes uint) {
-			fd := (*src).(int)
-			// n, e := syscall.Read(fd, dest[:nbytes])
-			// n, e := syscall.Read(fd, dest[:nbytes])
-			// if e != nil || uint(n) != nbytes {
-			//         panic(e)
-			// }
-
-			nread, _, err := syscall.Recvfrom(fd, dest[:nbytes], 0)
-			if err != nil || (nread) != int(nbytes) {
-				panic(err)
-			}
-			// do_read_op(false)
-			// copy(dest, b[:nbytes])
-			// b = b[nbytes:]
-		}, &tmp)
-
-	// if len(b) > 0 {
-	//         leftover = b
-	// } else {
-	//         leftover = nil
-	// }
-
-	return ret
-
-	// return do_decode(
-	//         func(src *interface{}, dest []byte, nbytes uint) {
-	//                 fd := (*src).(int)
-	//                 n, e := syscall.Read(fd, dest[:nbytes])
-	//                 if e != nil || uint(n) != nbytes {
-	//                         panic(e)
-	//                 }
-	//         }, &tmp)
+const decoder_bufsize = 0x10000
+
+// New_Decoder returns a Decoder reading from r.
+func New_Decoder(r io.Reader) *Decoder {
+	return &Decoder{bufio.NewReaderSize(r, decoder_bufsize)}
 }
 
-func (obj *Object) Decode(str []byte) *Object {
-	var tmp interface{} = obj.GetPack()
-	if tmp == nil {
-		panic("Cannot decode non-packed object.")
+/*
+ * Decode returns the next object in the stream. If the stream ends cleanly
+ * between two objects the error is io.EOF; if it ends part way through one it
+ * is io.ErrUnexpectedEOF.
+ */
+func (dec *Decoder) Decode() (*Object, error) {
+	if _, err := dec.rd.Peek(1); err != nil {
+		return nil, err
 	}
-	return do_decode(
-		func(src *interface{}, dest []byte, nbytes uint) {
-			for i := uint(0); i < nbytes; i++ {
-				dest[i] = ((*src).([]byte))[i]
+
+	var src interface{} = dec.rd
+	return do_decode(read_buffered, &src)
+}
+
+func read_buffered(src *interface{}, dest []byte, nbytes uint) error {
+	_, err := io.ReadFull((*src).(*bufio.Reader), dest[:nbytes])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (obj *Object) Decode() (*Object, error) {
+	pack := obj.GetPack()
+	if pack == nil {
+		return nil, errors.New("Cannot decode non-packed object.")
+	}
+	return Decode_Bytes(pack)
+}
+
+// Decode_Bytes decodes the first complete object found in data.
+func Decode_Bytes(data []byte) (*Object, error) {
+	return New_Decoder(bytes.NewReader(data)).Decode()
+}
+
+/*
+ * Decode data, which must contain exactly one object and nothing else.
+ */
+func decode_exact(data []byte) (*Object, error) {
+	var src interface{} = data
+	obj, err := do_decode(
+		func(src *interface{}, dest []byte, nbytes uint) error {
+			buf := (*src).([]byte)
+			if uint(len(buf)) < nbytes {
+				return io.ErrUnexpectedEOF
 			}
-			*src = ((*src).([]byte))[nbytes:]
-		}, &tmp)
+			copy(dest, buf[:nbytes])
+			*src = buf[nbytes:]
+			return nil
+		}, &src)
+
+	if err == nil && len(src.([]byte)) != 0 {
+		err = errors.New("Trailing data after object.")
+	}
+	return obj, err
 }
 
 //========================================================================================
 
-func do_decode(read read_fn, src *interface{}) *Object {
+func do_decode(read read_fn, src *interface{}) (*Object, error) {
 	b := []byte{0}
-	read(src, b, 1)
-	mask := id_pack_type(b[0])
+	if err := read(src, b, 1); err != nil {
+		return nil, err
+	}
+	mask, err := id_pack_type(b[0])
+	if err != nil {
+		return nil, err
+	}
 
 	switch mask.group {
 	case grp_ARRAY:
@@ -263,22 +219,42 @@ func do_decode(read read_fn, src *interface{}) *Object {
 	case grp_BOOL:
 		return decode_bool(mask)
 	case grp_NIL:
-		return decode_nil()
+		return decode_nil(), nil
 	case grp_BIN:
-		panic("Bin is not implemented.")
+		return decode_bin(read, src, mask)
+	case grp_FLOAT:
+		return decode_float(read, src, mask)
 	default:
-		panic(fmt.Sprintf("Default reached. grp: %d, obj: %v", mask.group, mask))
+		return nil, fmt.Errorf("Default reached. grp: %d, obj: %v", mask.group, mask)
 	}
-	return nil
 }
 
 //========================================================================================
 
-func decode_array(read read_fn, src *interface{}, b byte, mask *mpack_mask) *Object {
+/*
+ * Read a 1, 2, or 4 byte big endian length field.
+ */
+func read_size(read read_fn, src *interface{}, nbytes uint) (uint32, error) {
+	word := []byte{0, 0, 0, 0}
+	if err := read(src, word, nbytes); err != nil {
+		return 0, err
+	}
+
+	switch nbytes {
+	case 1:
+		return uint32(word[0]), nil
+	case 2:
+		return uint32(decode_uint16(word)), nil
+	default:
+		return decode_uint32(word), nil
+	}
+}
+
+func decode_array(read read_fn, src *interface{}, b byte, mask *mpack_mask) (*Object, error) {
 	var (
 		item Object
 		size uint32
-		word = []byte{0, 0, 0, 0}
+		err  error
 	)
 
 	if mask.fixed {
@@ -286,34 +262,40 @@ func decode_array(read read_fn, src *interface{}, b byte, mask *mpack_mask) *Obj
 	} else {
 		switch mask.mtype {
 		case m_ARRAY_16:
-			read(src, word, 2)
-			size = uint32(decode_uint16(word))
+			size, err = read_size(read, src, 2)
 		case m_ARRAY_32:
-			read(src, word, 4)
-			size = decode_uint32(word)
+			size, err = read_size(read, src, 4)
 		default:
-			panic(errmsg)
+			return nil, errors.New(errmsg)
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	item.flags |= mFLAG_ENCODE
 	item.Mtype = T_ARRAY
-	item.Data = make([]Object, size)
+	lst := make([]Object, 0, prealloc_size(size))
 
 	// Eprintf("\n\nIt is an array! -> 0x%0X => size %d\n\n", b, size)
 
-	for i := range item.Data.([]Object) {
-		item.Data.([]Object)[i] = *do_decode(read, src)
+	for i := uint32(0); i < size; i++ {
+		elem, err := do_decode(read, src)
+		if err != nil {
+			return nil, err
+		}
+		lst = append(lst, *elem)
 	}
+	item.Data = lst
 
-	return &item
+	return &item, nil
 }
 
-func decode_map(read read_fn, src *interface{}, b byte, mask *mpack_mask) *Object {
+func decode_map(read read_fn, src *interface{}, b byte, mask *mpack_mask) (*Object, error) {
 	var (
 		item Object
 		size uint32
-		word = []byte{0, 0, 0, 0}
+		err  error
 	)
 
 	if mask.fixed {
@@ -321,33 +303,42 @@ func decode_map(read read_fn, src *interface{}, b byte, mask *mpack_mask) *Objec
 	} else {
 		switch mask.mtype {
 		case m_MAP_16:
-			read(src, word, 2)
-			size = uint32(decode_uint16(word))
+			size, err = read_size(read, src, 2)
 		case m_MAP_32:
-			read(src, word, 4)
-			size = decode_uint32(word)
+			size, err = read_size(read, src, 4)
 		default:
-			panic(errmsg)
+			return nil, errors.New(errmsg)
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	item.Mtype = T_MAP
 	item.flags = mFLAG_ENCODE
-	item.Data = make([]Map_Entry, size)
+	ents := make([]Map_Entry, 0, prealloc_size(size))
 
-	for i := range item.Data.([]Map_Entry) {
-		item.Data.([]Map_Entry)[i].Key = *do_decode(read, src)
-		item.Data.([]Map_Entry)[i].Value = *do_decode(read, src)
+	for i := uint32(0); i < size; i++ {
+		key, err := do_decode(read, src)
+		if err != nil {
+			return nil, err
+		}
+		value, err := do_decode(read, src)
+		if err != nil {
+			return nil, err
+		}
+		ents = append(ents, Map_Entry{*key, *value})
 	}
+	item.Data = ents
 
-	return &item
+	return &item, nil
 }
 
-func decode_string(read read_fn, src *interface{}, b byte, mask *mpack_mask) *Object {
+func decode_string(read read_fn, src *interface{}, b byte, mask *mpack_mask) (*Object, error) {
 	var (
 		item Object
 		size uint32
-		word = []byte{0, 0, 0, 0}
+		err  error
 	)
 
 	if mask.fixed {
@@ -355,33 +346,34 @@ func decode_string(read read_fn, src *interface{}, b byte, mask *mpack_mask) *Ob
 	} else {
 		switch mask.mtype {
 		case m_STR_8:
-			read(src, word, 1)
-			size = uint32(word[0])
+			size, err = read_size(read, src, 1)
 		case m_STR_16:
-			read(src, word, 2)
-			size = uint32(decode_uint16(word))
+			size, err = read_size(read, src, 2)
 		case m_STR_32:
-			read(src, word, 4)
-			size = decode_uint32(word)
+			size, err = read_size(read, src, 4)
 		default:
-			panic(errmsg)
+			return nil, errors.New(errmsg)
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	item.Mtype = T_STRING
 	item.flags = mFLAG_ENCODE
-	item.Data = make([]byte, size)
-
-	read(src, item.Data.([]byte), uint(size))
+	if item.Data, err = read_bytes(read, src, size); err != nil {
+		return nil, err
+	}
 
-	return &item
+	return &item, nil
 }
 
-func decode_integer(read read_fn, src *interface{}, b byte, mask *mpack_mask) *Object {
+func decode_integer(read read_fn, src *interface{}, b byte, mask *mpack_mask) (*Object, error) {
 	var (
 		item  Object
 		value int64
 		word  = []byte{0, 0, 0, 0, 0, 0, 0, 0}
+		err   error
 	)
 
 	if mask.fixed {
@@ -390,22 +382,22 @@ func decode_integer(read read_fn, src *interface{}, b byte, mask *mpack_mask) *O
 	} else {
 		switch mask.mtype {
 		case m_INT_8:
-			read(src, word, 1)
-			value = int64(word[0])
-			value = int64(uint64(value) | 0xFFFFFFFFFFFFFF00)
+			err = read(src, word, 1)
+			value = int64(int8(word[0]))
 		case m_INT_16:
-			read(src, word, 2)
+			err = read(src, word, 2)
 			value = int64(decode_int16(word))
-			value = int64(uint64(value) | 0xFFFFFFFFFFFF0000)
 		case m_INT_32:
-			read(src, word, 4)
+			err = read(src, word, 4)
 			value = int64(decode_int32(word))
-			value = int64(uint64(value) | 0xFFFFFFFF00000000)
 		case m_INT_64:
-			read(src, word, 8)
+			err = read(src, word, 8)
 			value = decode_int64(word)
 		default:
-			panic(errmsg)
+			return nil, errors.New(errmsg)
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -413,14 +405,15 @@ func decode_integer(read read_fn, src *interface{}, b byte, mask *mpack_mask) *O
 	item.Mtype = T_NUM
 	item.Data = value
 
-	return &item
+	return &item, nil
 }
 
-func decode_unsigned(read read_fn, src *interface{}, b byte, mask *mpack_mask) *Object {
+func decode_unsigned(read read_fn, src *interface{}, b byte, mask *mpack_mask) (*Object, error) {
 	var (
 		item  Object
 		value uint64
 		word  = []byte{0, 0, 0, 0, 0, 0, 0, 0}
+		err   error
 	)
 
 	if mask.fixed {
@@ -428,19 +421,26 @@ func decode_unsigned(read read_fn, src *interface{}, b byte, mask *mpack_mask) *
 	} else {
 		switch mask.mtype {
 		case m_UINT_8:
-			read(src, word, 1)
+			err = read(src, word, 1)
 			value = uint64(word[0])
 		case m_UINT_16:
-			read(src, word, 2)
+			err = read(src, word, 2)
 			value = uint64(decode_uint16(word))
 		case m_UINT_32:
-			read(src, word, 4)
+			err = read(src, word, 4)
 			value = uint64(decode_uint32(word))
 		case m_UINT_64:
-			read(src, word, 8)
+			err = read(src, word, 8)
 			value = decode_uint64(word)
 		default:
-			panic(errmsg)
+			return nil, errors.New(errmsg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		/* Numbers are kept as int64, so anything bigger can't be represented. */
+		if value > math.MaxInt64 {
+			return nil, fmt.Errorf("Unsigned integer %d is too large", value)
 		}
 	}
 
@@ -448,46 +448,120 @@ func decode_unsigned(read read_fn, src *interface{}, b byte, mask *mpack_mask) *
 	item.Mtype = T_NUM
 	item.Data = int64(value)
 
-	return &item
+	return &item, nil
 }
 
-func decode_ext(read read_fn, src *interface{}, b byte, mask *mpack_mask) *Object {
+func decode_bin(read read_fn, src *interface{}, mask *mpack_mask) (*Object, error) {
+	var (
+		item Object
+		size uint32
+		err  error
+	)
+
+	switch mask.mtype {
+	case m_BIN_8:
+		size, err = read_size(read, src, 1)
+	case m_BIN_16:
+		size, err = read_size(read, src, 2)
+	case m_BIN_32:
+		size, err = read_size(read, src, 4)
+	default:
+		return nil, errors.New(errmsg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	item.Mtype = T_BIN
+	item.flags = mFLAG_ENCODE
+	if item.Data, err = read_bytes(read, src, size); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+func decode_float(read read_fn, src *interface{}, mask *mpack_mask) (*Object, error) {
 	var (
 		item  Object
-		value uint32
-		word  = []byte{0, 0, 0, 0}
-		t     = []byte{0}
+		value float64
+		word  = []byte{0, 0, 0, 0, 0, 0, 0, 0}
+		err   error
 	)
 
-	if mask.fixed {
-		value = uint32(b ^ mask.val)
-	} else {
-		switch mask.mtype {
-		case m_EXT_F1:
-			read(src, t, 1)
-			read(src, word, 1)
-			value = uint32(word[0])
-		case m_EXT_F2:
-			read(src, t, 1)
-			read(src, word, 2)
-			value = uint32(decode_uint16(word))
-		case m_EXT_F4:
-			read(src, t, 1)
-			read(src, word, 4)
-			value = decode_uint32(word)
-		default:
-			panic(errmsg)
-		}
+	switch mask.mtype {
+	case m_FLOAT_32:
+		err = read(src, word, 4)
+		value = float64(math.Float32frombits(decode_uint32(word)))
+	case m_FLOAT_64:
+		err = read(src, word, 8)
+		value = math.Float64frombits(decode_uint64(word))
+	default:
+		return nil, errors.New(errmsg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	item.flags = mFLAG_ENCODE
+	item.Mtype = T_FLOAT
+	item.Data = value
+
+	return &item, nil
+}
+
+/*
+ * The fixext forms have an implicit payload size; the others give it explicitly
+ * before the type byte. Either way the type is followed by the payload.
+ */
+func decode_ext(read read_fn, src *interface{}, b byte, mask *mpack_mask) (*Object, error) {
+	var (
+		item Object
+		size uint32
+		t    = []byte{0}
+		err  error
+	)
+
+	switch mask.mtype {
+	case m_EXT_F1:
+		size = 1
+	case m_EXT_F2:
+		size = 2
+	case m_EXT_F4:
+		size = 4
+	case m_EXT_F8:
+		size = 8
+	case m_EXT_F16:
+		size = 16
+	case m_EXT_8:
+		size, err = read_size(read, src, 1)
+	case m_EXT_16:
+		size, err = read_size(read, src, 2)
+	case m_EXT_32:
+		size, err = read_size(read, src, 4)
+	default:
+		return nil, errors.New(errmsg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = read(src, t, 1); err != nil {
+		return nil, err
+	}
+	data, err := read_bytes(read, src, size)
+	if err != nil {
+		return nil, err
 	}
 
 	item.flags = mFLAG_ENCODE
 	item.Mtype = T_EXT
-	item.Data = Ext{int8(t[0]), value}
+	item.Data = new_ext(int8(t[0]), data)
 
-	return &item
+	return &item, nil
 }
 
-func decode_bool(mask *mpack_mask) *Object {
+func decode_bool(mask *mpack_mask) (*Object, error) {
 	var item Object
 	item.Mtype = T_BOOL
 	item.flags = mFLAG_ENCODE
@@ -498,10 +572,10 @@ func decode_bool(mask *mpack_mask) *Object {
 	case m_FALSE:
 		item.Data = false
 	default:
-		panic(errmsg)
+		return nil, errors.New(errmsg)
 	}
 
-	return &item
+	return &item, nil
 }
 
 func decode_nil() *Object {
@@ -515,9 +589,47 @@ func decode_nil() *Object {
 
 //========================================================================================
 
-func id_pack_type(b byte) *mpack_mask {
-	var ret *mpack_mask
+/*
+ * The sizes in the stream can't be trusted until the data has actually been
+ * read, so a truncated or corrupt message must not be able to make us allocate
+ * gigabytes up front. Containers start out with at most this many elements and
+ * long strings are read in chunks of this many kilobytes.
+ */
+const max_prealloc = 4096
+
+func prealloc_size(size uint32) uint32 {
+	if size > max_prealloc {
+		return max_prealloc
+	}
+	return size
+}
+
+func read_bytes(read read_fn, src *interface{}, size uint32) ([]byte, error) {
+	if size <= max_prealloc*1024 {
+		buf := make([]byte, size)
+		return buf, read(src, buf, uint(size))
+	}
+
+	buf := make([]byte, 0, max_prealloc*1024)
+	chunk := make([]byte, max_prealloc*1024)
+	for rem := uint(size); rem > 0; {
+		n := rem
+		if n > uint(len(chunk)) {
+			n = uint(len(chunk))
+		}
+		if err := read(src, chunk, n); err != nil {
+			return nil, err
+		}
+		buf = append(buf, chunk[:n]...)
+		rem -= n
+	}
+
+	return buf, nil
+}
+
+//========================================================================================
 
+func id_pack_type(b byte) (*mpack_mask, error) {
 	/* For some reason range doesn't seem to process this array in the correct order,
 	 * which is required for this to work. */
 	for i := 0; i < len(m_masks); i++ {
@@ -525,22 +637,16 @@ func id_pack_type(b byte) *mpack_mask {
 
 		if m.fixed {
 			if (b >> m.shift) == (m.val >> m.shift) {
-				ret = &m
-				break
+				return &m, nil
 			}
 		} else {
 			if b == m.val {
-				ret = &m
-				break
+				return &m, nil
 			}
 		}
 	}
 
-	if ret == nil {
-		panic("Failed to id pack.")
-	}
-
-	return ret
+	return nil, fmt.Errorf("Failed to id pack (unknown type byte 0x%02X).", b)
 }
 
 //========================================================================================
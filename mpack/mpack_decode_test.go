@@ -0,0 +1,286 @@
+package mpack
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecodeInteger(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want int64
+	}{
+		{[]byte{0x05}, 5},
+		{[]byte{0xFF}, -1},
+		{[]byte{0xE0}, -32},
+		{[]byte{0xD0, 0x7F}, 127},
+		{[]byte{0xD0, 0x80}, -128},
+		{[]byte{0xD1, 0x01, 0x00}, 256},
+		{[]byte{0xD1, 0xFF, 0x00}, -256},
+		{[]byte{0xD2, 0x00, 0x01, 0x00, 0x00}, 65536},
+		{[]byte{0xD2, 0x80, 0x00, 0x00, 0x00}, -2147483648},
+		{[]byte{0xD3, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFE}, -2},
+		{[]byte{0xCC, 0xFF}, 255},
+		{[]byte{0xCF, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, 9223372036854775807},
+	}
+
+	for _, tt := range tests {
+		obj, err := Decode_Bytes(tt.data)
+		if err != nil {
+			t.Errorf("% X: %s", tt.data, err)
+			continue
+		}
+		if got := obj.Get_Int64(); obj.Mtype != T_NUM || got != tt.want {
+			t.Errorf("% X: got %d, want %d", tt.data, got, tt.want)
+		}
+	}
+
+	if _, err := Decode_Bytes([]byte{0xCF, 0x80, 0, 0, 0, 0, 0, 0, 0}); err == nil {
+		t.Error("expected an error for a uint64 above MaxInt64")
+	}
+}
+
+func packed(fn func(buf *[]byte)) []byte {
+	buf := make([]byte, 0, 64)
+	fn(&buf)
+	return buf
+}
+
+/*
+ * Each case is made by the packing functions, so that encoding is tested too:
+ * it has to pick the form named by the first byte, decode to the given value,
+ * and encode back to exactly the same bytes.
+ */
+func TestRoundTrip(t *testing.T) {
+	var (
+		str40    = bytes.Repeat([]byte("s"), 40)
+		str300   = bytes.Repeat([]byte("t"), 300)
+		str70000 = bytes.Repeat([]byte("u"), 70000)
+		ints     = make([]Object, 20)
+	)
+	for i := range ints {
+		ints[i] = Object{Data: int64(i), Mtype: T_NUM}
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		header byte
+		mtype  uint8
+		want   interface{}
+	}{
+		{"float64", packed(func(b *[]byte) { pack_float(b, -2.25e100) }), mMASK_FLOAT_64, T_FLOAT, -2.25e100},
+		{"bin8", packed(func(b *[]byte) { pack_binary(b, str40) }), mMASK_BIN_8, T_BIN, str40},
+		{"bin16", packed(func(b *[]byte) { pack_binary(b, str300) }), mMASK_BIN_16, T_BIN, str300},
+		{"bin32", packed(func(b *[]byte) { pack_binary(b, str70000) }), mMASK_BIN_32, T_BIN, str70000},
+		{"str8", packed(func(b *[]byte) { pack_string(b, str40) }), mMASK_STR_8, T_STRING, str40},
+		{"str16", packed(func(b *[]byte) { pack_string(b, str300) }), mMASK_STR_16, T_STRING, str300},
+		{"str32", packed(func(b *[]byte) { pack_string(b, str70000) }), mMASK_STR_32, T_STRING, str70000},
+		{"fixext1", packed(func(b *[]byte) { pack_ext(b, 0, []byte{5}) }), mMASK_FIXEXT_1, T_EXT, new_ext(0, []byte{5})},
+		{"fixext2", packed(func(b *[]byte) { pack_ext(b, 1, []byte{0xCC, 200}) }), mMASK_FIXEXT_2, T_EXT, new_ext(1, []byte{0xCC, 200})},
+		{"fixext4", packed(func(b *[]byte) { pack_ext(b, 2, str40[:4]) }), mMASK_FIXEXT_4, T_EXT, new_ext(2, str40[:4])},
+		{"fixext8", packed(func(b *[]byte) { pack_ext(b, 3, str40[:8]) }), mMASK_FIXEXT_8, T_EXT, new_ext(3, str40[:8])},
+		{"fixext16", packed(func(b *[]byte) { pack_ext(b, -1, str40[:16]) }), mMASK_FIXEXT_16, T_EXT, new_ext(-1, str40[:16])},
+		{"ext8", packed(func(b *[]byte) { pack_ext(b, 4, str40[:3]) }), mMASK_EXT_8, T_EXT, new_ext(4, str40[:3])},
+		{"ext16", packed(func(b *[]byte) { pack_ext(b, 5, str300) }), mMASK_EXT_16, T_EXT, new_ext(5, str300)},
+		{"ext32", packed(func(b *[]byte) { pack_ext(b, 6, str70000) }), mMASK_EXT_32, T_EXT, new_ext(6, str70000)},
+		{"array16", packed(func(b *[]byte) {
+			pack_array(b, 20)
+			for i := 0; i < 20; i++ {
+				pack_integer(b, int64(i))
+			}
+		}), mMASK_ARRAY_16, T_ARRAY, ints},
+	}
+
+	for _, tt := range tests {
+		if tt.data[0] != tt.header {
+			t.Errorf("%s: encoded with header %02X, want %02X", tt.name, tt.data[0], tt.header)
+		}
+		obj, err := Decode_Bytes(tt.data)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if obj.Mtype != tt.mtype {
+			t.Errorf("%s: decoded as %s", tt.name, obj.TypeRepr())
+			continue
+		}
+		got := obj.Data
+		if arr, ok := got.([]Object); ok {
+			for i := range arr {
+				arr[i].flags = 0
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: decoded to %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+
+		buf := make([]byte, 0, len(tt.data))
+		if err = marshal_object(&buf, obj); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		} else if !bytes.Equal(buf, tt.data) {
+			t.Errorf("%s: re-encoded differently", tt.name)
+		}
+	}
+}
+
+/* Forms the encoder never produces itself must still be understood. */
+func TestDecodeOtherForms(t *testing.T) {
+	obj, err := Decode_Bytes(packed(func(b *[]byte) { pack_float32(b, 1.5) }))
+	if err != nil || obj.Mtype != T_FLOAT || obj.Data.(float64) != 1.5 {
+		t.Errorf("float32: got %v (%v)", obj, err)
+	}
+
+	/* A map32 with two entries. */
+	data := []byte{mMASK_MAP_32, 0, 0, 0, 2, 0xA1, 'a', 0x01, 0xA1, 'b', 0xC3}
+	obj, err = Decode_Bytes(data)
+	if err != nil {
+		t.Fatalf("map32: %s", err)
+	}
+	var m map[string]interface{}
+	if err = obj.Unmarshal(&m); err != nil || !reflect.DeepEqual(m, map[string]interface{}{"a": int64(1), "b": true}) {
+		t.Errorf("map32: got %v (%v)", m, err)
+	}
+}
+
+func TestExpectFloatAsInteger(t *testing.T) {
+	tests := []struct {
+		val float64
+		ok  bool
+	}{
+		{2, true},
+		{-(1 << 63), true},
+		{1 << 62, true},
+		{1 << 63, false},
+		{-(1 << 64), false},
+		{2.5, false},
+		{math.NaN(), false},
+		{math.Inf(1), false},
+	}
+
+	for _, tt := range tests {
+		obj := Object{Data: tt.val, Mtype: T_FLOAT}
+		val, err := obj.Expect(T_NUM)
+		if tt.ok && (err != nil || val.(int64) != int64(tt.val)) {
+			t.Errorf("%v: got %v (%v)", tt.val, val, err)
+		} else if !tt.ok && err == nil {
+			t.Errorf("%v: converted to %v, expected an error", tt.val, val)
+		}
+	}
+}
+
+//========================================================================================
+
+/* A few objects of different kinds, one after another. */
+func decoder_stream(t *testing.T) ([]byte, []interface{}) {
+	t.Helper()
+	values := []interface{}{
+		[]interface{}{int64(1), "two", []interface{}{true, nil}},
+		map[string]interface{}{"key": "value", "n": int64(-300)},
+		"a string long enough to need str8 rather than a fixstr",
+		int64(1) << 40,
+	}
+
+	var stream []byte
+	for _, v := range values {
+		data, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, data...)
+	}
+	return stream, values
+}
+
+func decode_all(t *testing.T, dec *Decoder, n int) []interface{} {
+	t.Helper()
+	ret := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		obj, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("object %d: %s", i, err)
+		}
+		var v interface{}
+		if err = obj.Unmarshal(&v); err != nil {
+			t.Fatalf("object %d: %s", i, err)
+		}
+		ret = append(ret, v)
+	}
+	return ret
+}
+
+func TestDecoderStream(t *testing.T) {
+	stream, want := decoder_stream(t)
+
+	for _, tt := range []struct {
+		name string
+		r    io.Reader
+	}{
+		{"whole", bytes.NewReader(stream)},
+		{"one byte at a time", iotest.OneByteReader(bytes.NewReader(stream))},
+		{"half at a time", iotest.HalfReader(bytes.NewReader(stream))},
+	} {
+		dec := New_Decoder(tt.r)
+		if got := decode_all(t, dec, len(want)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, want)
+		}
+		/* The stream ends cleanly, between two objects. */
+		if _, err := dec.Decode(); err != io.EOF {
+			t.Errorf("%s: got %v at the end, want io.EOF", tt.name, err)
+		}
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"str", packed(func(b *[]byte) { pack_string(b, []byte("hello world")) })},
+		{"str length", []byte{mMASK_STR_16, 0x01}},
+		{"array", packed(func(b *[]byte) {
+			pack_array(b, 3)
+			pack_integer(b, 1)
+			pack_integer(b, 2)
+		})},
+		{"map", packed(func(b *[]byte) {
+			pack_map(b, 1)
+			pack_string(b, []byte("key"))
+		})},
+		{"ext payload", packed(func(b *[]byte) { pack_ext(b, 0, []byte{1, 2, 3}) })},
+		{"ext type", []byte{mMASK_FIXEXT_4}},
+		{"integer", []byte{mMASK_INT_32, 0, 0}},
+	}
+	/* Strings and ext payloads are cut one byte short. */
+	tests[0].data = tests[0].data[:len(tests[0].data)-1]
+	tests[4].data = tests[4].data[:len(tests[4].data)-1]
+
+	for _, tt := range tests {
+		/* A good object first, so that it's clear the error isn't an early EOF. */
+		stream := append([]byte{0x01}, tt.data...)
+
+		dec := New_Decoder(iotest.OneByteReader(bytes.NewReader(stream)))
+		if obj, err := dec.Decode(); err != nil || obj.Data.(int64) != 1 {
+			t.Fatalf("%s: first object: %v (%v)", tt.name, obj, err)
+		}
+		if _, err := dec.Decode(); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%s: got %v, want io.ErrUnexpectedEOF", tt.name, err)
+		}
+	}
+}
+
+func TestDecoderReadError(t *testing.T) {
+	stream, _ := decoder_stream(t)
+	boom := errors.New("boom")
+	dec := New_Decoder(io.MultiReader(bytes.NewReader(stream[:5]), iotest.ErrReader(boom)))
+
+	if _, err := dec.Decode(); !errors.Is(err, boom) {
+		t.Errorf("got %v, want the reader's error", err)
+	}
+}
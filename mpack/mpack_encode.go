@@ -1,6 +1,7 @@
 package mpack
 
 import (
+	"fmt"
 	"math"
 )
 
@@ -21,128 +22,370 @@ func Make_New(len uint, encode bool) *Object {
 func (root *Object) Encode_Array(item **Object, size uint) {
 	(*item).Data = make([]Object, size, size)
 	(*item).Mtype = T_ARRAY
+	pack_array(&root.packed, size)
+}
+
+func (root *Object) Encode_Map(item **Object, size uint) {
+	(*item).Data = make([]Map_Entry, size)
+	(*item).Mtype = T_MAP
+	pack_map(&root.packed, size)
+}
+
+func (root *Object) Encode_Integer(item **Object, value int64) {
+	if (root.flags & mFLAG_ENCODE) != 0 {
+		(*item).Mtype = T_NUM
+		(*item).Data = value
+	}
+	pack_integer(&root.packed, value)
+}
+
+func (root *Object) Encode_String(item **Object, str []byte) {
+	if (root.flags & mFLAG_ENCODE) != 0 {
+		(*item).Data = str
+		(*item).Mtype = T_STRING
+	}
+	pack_string(&root.packed, str)
+}
+
+func (root *Object) Encode_Binary(item **Object, data []byte) {
+	if (root.flags & mFLAG_ENCODE) != 0 {
+		(*item).Data = data
+		(*item).Mtype = T_BIN
+	}
+	pack_binary(&root.packed, data)
+}
+
+func (root *Object) Encode_Float(item **Object, value float64) {
+	if (root.flags & mFLAG_ENCODE) != 0 {
+		(*item).Data = value
+		(*item).Mtype = T_FLOAT
+	}
+	pack_float(&root.packed, value)
+}
+
+func (root *Object) Encode_Float32(item **Object, value float32) {
+	if (root.flags & mFLAG_ENCODE) != 0 {
+		(*item).Data = float64(value)
+		(*item).Mtype = T_FLOAT
+	}
+	pack_float32(&root.packed, value)
+}
+
+func (root *Object) Encode_Ext(item **Object, etype int8, data []byte) {
+	if (root.flags & mFLAG_ENCODE) != 0 {
+		(*item).Data = new_ext(etype, data)
+		(*item).Mtype = T_EXT
+	}
+	pack_ext(&root.packed, etype, data)
+}
+
+func (root *Object) Encode_Boolean(item **Object, value bool) {
+	if (root.flags & mFLAG_ENCODE) != 0 {
+		(*item).Data = value
+		(*item).Mtype = T_BOOL
+	}
+	pack_boolean(&root.packed, value)
+}
+
+func (root *Object) Encode_Nil(item **Object) {
+	if (root.flags & mFLAG_ENCODE) != 0 {
+		(*item).Data = uint16(0)
+		(*item).Mtype = T_NIL
+	}
+	pack_nil(&root.packed)
+}
+
+/*
+ * Encode an arbitrary go value. The types that turn up all the time as arguments
+ * and results of rpc calls are handled directly.
+ */
+func (root *Object) Encode_Value(item **Object, val interface{}) {
+	switch v := val.(type) {
+	case nil:
+		root.Encode_Nil(item)
+	case bool:
+		root.Encode_Boolean(item, v)
+	case int:
+		root.Encode_Integer(item, int64(v))
+	case int64:
+		root.Encode_Integer(item, v)
+	case uint16:
+		root.Encode_Integer(item, int64(v))
+	case uint32:
+		root.Encode_Integer(item, int64(v))
+	case float64:
+		root.Encode_Float(item, v)
+	case float32:
+		root.Encode_Float32(item, v)
+	case Ext:
+		root.Encode_Ext(item, v.Etype, v.payload())
+	case string:
+		root.Encode_String(item, []byte(v))
+	case []byte:
+		root.Encode_String(item, v)
+	case []int:
+		root.Encode_Array(item, uint(len(v)))
+		for i := range v {
+			sub := (*item).Index(i)
+			root.Encode_Integer(&sub, int64(v[i]))
+		}
+	case []string:
+		root.Encode_Array(item, uint(len(v)))
+		for i := range v {
+			sub := (*item).Index(i)
+			root.Encode_String(&sub, []byte(v[i]))
+		}
+	case [][]byte:
+		root.Encode_Array(item, uint(len(v)))
+		for i := range v {
+			sub := (*item).Index(i)
+			root.Encode_String(&sub, v[i])
+		}
+	case []interface{}:
+		root.Encode_Array(item, uint(len(v)))
+		for i := range v {
+			sub := (*item).Index(i)
+			root.Encode_Value(&sub, v[i])
+		}
+	case *Object:
+		root.encode_object(item, v)
+	case map[string]interface{}:
+		root.Encode_Map(item, uint(len(v)))
+		i := 0
+		for key, elem := range v {
+			ent := (*item).MapEnt(i)
+			sub := &ent.Key
+			root.Encode_String(&sub, []byte(key))
+			sub = &ent.Value
+			root.Encode_Value(&sub, elem)
+			i++
+		}
+	default:
+		root.encode_reflect(item, val)
+	}
+}
 
+/*
+ * Anything Encode_Value doesn't know about directly (structs, other kinds of
+ * maps and slices, pointers) goes through Marshal.
+ */
+func (root *Object) encode_reflect(item **Object, val interface{}) {
+	data, err := Marshal(val)
+	if err != nil {
+		panic(err)
+	}
+	if (root.flags & mFLAG_ENCODE) != 0 {
+		obj, err := decode_exact(data)
+		if err != nil {
+			panic(err)
+		}
+		**item = *obj
+	}
+	root.packed = append(root.packed, data...)
+}
+
+func (root *Object) encode_object(item **Object, obj *Object) {
+	switch obj.Mtype {
+	case T_NIL:
+		root.Encode_Nil(item)
+	case T_BOOL:
+		root.Encode_Boolean(item, obj.Data.(bool))
+	case T_NUM:
+		root.Encode_Integer(item, obj.Data.(int64))
+	case T_FLOAT:
+		root.Encode_Float(item, obj.Data.(float64))
+	case T_EXT:
+		ext := obj.Data.(Ext)
+		root.Encode_Ext(item, ext.Etype, ext.payload())
+	case T_STRING:
+		root.Encode_String(item, obj.Data.([]byte))
+	case T_BIN:
+		root.Encode_Binary(item, obj.Data.([]byte))
+	case T_ARRAY:
+		arr := obj.Data.([]Object)
+		root.Encode_Array(item, uint(len(arr)))
+		for i := range arr {
+			sub := (*item).Index(i)
+			root.encode_object(&sub, &arr[i])
+		}
+	case T_MAP:
+		ents := obj.Data.([]Map_Entry)
+		root.Encode_Map(item, uint(len(ents)))
+		for i := range ents {
+			ent := (*item).MapEnt(i)
+			sub := &ent.Key
+			root.encode_object(&sub, &ents[i].Key)
+			sub = &ent.Value
+			root.encode_object(&sub, &ents[i].Value)
+		}
+	default:
+		panic(fmt.Sprintf("Cannot encode object of type %s.", obj.TypeRepr()))
+	}
+}
+
+//========================================================================================
+// Raw packing. These only append the encoded bytes and are shared by the Encode_*
+// methods and Marshal.
+
+func pack_array(buf *[]byte, size uint) {
 	switch {
 	case size <= mARRAY_F_MAX:
-		root.packed = append(root.packed, mMASK_ARRAY_F|byte(size))
+		*buf = append(*buf, mMASK_ARRAY_F|byte(size))
 	case size <= math.MaxUint16:
-		root.packed = append(root.packed, mMASK_ARRAY_16)
-		encode_uint16(&root.packed, uint16(size))
+		*buf = append(*buf, mMASK_ARRAY_16)
+		encode_uint16(buf, uint16(size))
 	case size <= math.MaxUint32:
-		root.packed = append(root.packed, mMASK_ARRAY_32)
-		encode_uint32(&root.packed, uint32(size))
+		*buf = append(*buf, mMASK_ARRAY_32)
+		encode_uint32(buf, uint32(size))
 	default:
 		panic("Array size is too large to encode.")
 	}
 }
 
-func (root *Object) Encode_Map(item **Object, size uint) {
-	(*item).Data = make([]Map_Entry, size)
-	(*item).Mtype = T_MAP
-
+func pack_map(buf *[]byte, size uint) {
 	switch {
 	case size <= mARRAY_F_MAX:
-		root.packed = append(root.packed, mMASK_MAP_F|byte(size))
+		*buf = append(*buf, mMASK_MAP_F|byte(size))
 	case size <= math.MaxUint16:
-		root.packed = append(root.packed, mMASK_MAP_16)
-		encode_uint16(&root.packed, uint16(size))
+		*buf = append(*buf, mMASK_MAP_16)
+		encode_uint16(buf, uint16(size))
 	case size <= math.MaxUint32:
-		root.packed = append(root.packed, mMASK_MAP_32)
-		encode_uint32(&root.packed, uint32(size))
+		*buf = append(*buf, mMASK_MAP_32)
+		encode_uint32(buf, uint32(size))
 	default:
 		panic("Map size is too large to encode.")
 	}
 }
 
-func (root *Object) Encode_Integer(item **Object, value int64) {
-	if (root.flags & mFLAG_ENCODE) != 0 {
-		(*item).Mtype = T_NUM
-		(*item).Data = value
+func pack_integer(buf *[]byte, value int64) {
+	if value >= 0 {
+		pack_unsigned(buf, uint64(value))
+		return
 	}
 
-	if value >= 0 {
-		switch {
-		case value <= 127:
-			root.packed = append(root.packed, mMASK_POS_INT_F|byte(value))
-		case value <= math.MaxUint8:
-			root.packed = append(root.packed, mMASK_UINT_8, byte(value))
-		case value <= math.MaxUint16:
-			root.packed = append(root.packed, mMASK_UINT_16)
-			encode_uint16(&root.packed, uint16(value))
-		case value <= math.MaxUint32:
-			root.packed = append(root.packed, mMASK_UINT_32)
-			encode_uint32(&root.packed, uint32(value))
-		case value <= math.MaxInt64:
-			root.packed = append(root.packed, mMASK_UINT_64)
-			encode_uint64(&root.packed, uint64(value))
-		default:
-			panic("Value is too large to encode.")
-		}
-	} else {
-		switch {
-		case value >= -31:
-			root.packed = append(root.packed, mMASK_NEG_INT_F|byte(value))
-		case value >= math.MinInt8:
-			root.packed = append(root.packed, mMASK_INT_8, byte(value&0xFF))
-		case value >= math.MinInt16:
-			root.packed = append(root.packed, mMASK_INT_16)
-			encode_int16(&root.packed, int16(value))
-		case value <= math.MinInt32:
-			root.packed = append(root.packed, mMASK_INT_32)
-			encode_int32(&root.packed, int32(value))
-		case value <= math.MinInt64:
-			root.packed = append(root.packed, mMASK_INT_64)
-			encode_int64(&root.packed, int64(value))
-		default:
-			panic("Value is too small to encode.")
-		}
+	switch {
+	case value >= -32:
+		*buf = append(*buf, mMASK_NEG_INT_F|byte(value))
+	case value >= math.MinInt8:
+		*buf = append(*buf, mMASK_INT_8, byte(value&0xFF))
+	case value >= math.MinInt16:
+		*buf = append(*buf, mMASK_INT_16)
+		encode_int16(buf, int16(value))
+	case value >= math.MinInt32:
+		*buf = append(*buf, mMASK_INT_32)
+		encode_int32(buf, int32(value))
+	default:
+		*buf = append(*buf, mMASK_INT_64)
+		encode_int64(buf, value)
 	}
 }
 
-func (root *Object) Encode_String(item **Object, str []byte) {
-	if (root.flags & mFLAG_ENCODE) != 0 {
-		(*item).Data = str
-		(*item).Mtype = T_STRING
+func pack_unsigned(buf *[]byte, value uint64) {
+	switch {
+	case value <= 127:
+		*buf = append(*buf, mMASK_POS_INT_F|byte(value))
+	case value <= math.MaxUint8:
+		*buf = append(*buf, mMASK_UINT_8, byte(value))
+	case value <= math.MaxUint16:
+		*buf = append(*buf, mMASK_UINT_16)
+		encode_uint16(buf, uint16(value))
+	case value <= math.MaxUint32:
+		*buf = append(*buf, mMASK_UINT_32)
+		encode_uint32(buf, uint32(value))
+	default:
+		*buf = append(*buf, mMASK_UINT_64)
+		encode_uint64(buf, value)
 	}
+}
 
+func pack_string(buf *[]byte, str []byte) {
 	switch {
 	case len(str) <= 31:
-		root.packed = append(root.packed, mMASK_STR_F|byte(len(str)))
+		*buf = append(*buf, mMASK_STR_F|byte(len(str)))
 	case len(str) <= math.MaxUint8:
-		root.packed = append(root.packed, mMASK_STR_8, byte(len(str)))
+		*buf = append(*buf, mMASK_STR_8, byte(len(str)))
 	case len(str) <= math.MaxUint16:
-		root.packed = append(root.packed, mMASK_STR_16)
-		encode_uint16(&root.packed, uint16(len(str)))
+		*buf = append(*buf, mMASK_STR_16)
+		encode_uint16(buf, uint16(len(str)))
 	case len(str) <= math.MaxUint32:
-		root.packed = append(root.packed, mMASK_STR_32)
-		encode_uint32(&root.packed, uint32(len(str)))
+		*buf = append(*buf, mMASK_STR_32)
+		encode_uint32(buf, uint32(len(str)))
 	default:
 		panic("String is too large to encode.")
 	}
 
-	root.packed = append(root.packed, str...)
+	*buf = append(*buf, str...)
 }
 
-func (root *Object) Encode_Boolean(item **Object, value bool) {
-	if (root.flags & mFLAG_ENCODE) != 0 {
-		(*item).Data = value
-		(*item).Mtype = T_BOOL
+func pack_binary(buf *[]byte, data []byte) {
+	switch {
+	case len(data) <= math.MaxUint8:
+		*buf = append(*buf, mMASK_BIN_8, byte(len(data)))
+	case len(data) <= math.MaxUint16:
+		*buf = append(*buf, mMASK_BIN_16)
+		encode_uint16(buf, uint16(len(data)))
+	case len(data) <= math.MaxUint32:
+		*buf = append(*buf, mMASK_BIN_32)
+		encode_uint32(buf, uint32(len(data)))
+	default:
+		panic("Binary data is too large to encode.")
 	}
 
-	if value {
-		root.packed = append(root.packed, mMASK_TRUE)
-	} else {
-		root.packed = append(root.packed, mMASK_FALSE)
+	*buf = append(*buf, data...)
+}
+
+func pack_float(buf *[]byte, value float64) {
+	*buf = append(*buf, mMASK_FLOAT_64)
+	encode_uint64(buf, math.Float64bits(value))
+}
+
+func pack_float32(buf *[]byte, value float32) {
+	*buf = append(*buf, mMASK_FLOAT_32)
+	encode_uint32(buf, math.Float32bits(value))
+}
+
+/*
+ * Payloads of 1, 2, 4, 8 and 16 bytes use the fixext forms, anything else gets
+ * an explicit length.
+ */
+func pack_ext(buf *[]byte, etype int8, data []byte) {
+	switch {
+	case len(data) == 1:
+		*buf = append(*buf, mMASK_FIXEXT_1)
+	case len(data) == 2:
+		*buf = append(*buf, mMASK_FIXEXT_2)
+	case len(data) == 4:
+		*buf = append(*buf, mMASK_FIXEXT_4)
+	case len(data) == 8:
+		*buf = append(*buf, mMASK_FIXEXT_8)
+	case len(data) == 16:
+		*buf = append(*buf, mMASK_FIXEXT_16)
+	case len(data) <= math.MaxUint8:
+		*buf = append(*buf, mMASK_EXT_8, byte(len(data)))
+	case len(data) <= math.MaxUint16:
+		*buf = append(*buf, mMASK_EXT_16)
+		encode_uint16(buf, uint16(len(data)))
+	case len(data) <= math.MaxUint32:
+		*buf = append(*buf, mMASK_EXT_32)
+		encode_uint32(buf, uint32(len(data)))
+	default:
+		panic("Ext data is too large to encode.")
 	}
+
+	*buf = append(*buf, byte(etype))
+	*buf = append(*buf, data...)
 }
 
-func (root *Object) Encode_Nil(item **Object) {
-	if (root.flags & mFLAG_ENCODE) != 0 {
-		(*item).Data = uint16(0)
-		(*item).Mtype = T_NIL
+func pack_boolean(buf *[]byte, value bool) {
+	if value {
+		*buf = append(*buf, mMASK_TRUE)
+	} else {
+		*buf = append(*buf, mMASK_FALSE)
 	}
+}
 
-	root.packed = append(root.packed, mMASK_NIL)
+func pack_nil(buf *[]byte) {
+	*buf = append(*buf, mMASK_NIL)
 }
 
 //========================================================================================
@@ -46,8 +46,13 @@ func print_object(fp *os.File, obj *Object) {
 		print_bool(fp, obj)
 	case T_NUM:
 		print_number(fp, obj)
+	case T_FLOAT:
+		print_float(fp, obj)
+	case T_BIN:
+		print_bin(fp, obj)
 	default:
-		panic(fmt.Sprintf("Invalid pack type '%s'.", obj.TypeRepr()))
+		pindent(fp)
+		end(fp, []byte(fmt.Sprintf("<invalid pack type %d>", obj.Mtype)))
 	}
 }
 
@@ -139,8 +144,8 @@ func print_string(fp *os.File, obj *Object, ind bool) {
 
 func print_ext(fp *os.File, obj *Object) {
 	pindent(fp)
-	s := fmt.Sprintf("EXT: (%d -> %d)",
-		obj.Data.(Ext).Etype, obj.Data.(Ext).Num)
+	s := fmt.Sprintf("EXT: (%d -> %d) %X",
+		obj.Data.(Ext).Etype, obj.Data.(Ext).Num, obj.Data.(Ext).Data)
 	end(fp, []byte(s))
 }
 
@@ -163,3 +168,15 @@ func print_number(fp *os.File, obj *Object) {
 	s := fmt.Sprintf("%d", obj.Data.(int64))
 	end(fp, []byte(s))
 }
+
+func print_float(fp *os.File, obj *Object) {
+	pindent(fp)
+	s := fmt.Sprintf("%g", obj.Data.(float64))
+	end(fp, []byte(s))
+}
+
+func print_bin(fp *os.File, obj *Object) {
+	pindent(fp)
+	s := fmt.Sprintf("BIN: <%X>", obj.Data.([]byte))
+	end(fp, []byte(s))
+}
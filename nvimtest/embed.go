@@ -0,0 +1,242 @@
+// Package nvimtest has helpers for driving tag_highlight against neovim from
+// tests, either a real embedded instance (this file) or a fake one.
+package nvimtest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"tag_highlight/api"
+	"time"
+)
+
+// A Session is an embedded neovim with the highlighter optionally running
+// against it.
+type Session struct {
+	Emb  *api.Embedded
+	Nvim *api.Client
+	Addr string
+	hl   *exec.Cmd
+}
+
+// How long Wait_Syntax waits for the highlighter before giving up.
+var Timeout = 30 * time.Second
+
+//========================================================================================
+
+/*
+ * Start launches an embedded neovim with filetype detection and syntax on, and
+ * a server socket the highlighter can connect to.
+ */
+func Start(args ...string) (*Session, error) {
+	emb, err := api.Embed(args...)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{Emb: emb, Nvim: emb.Client()}
+
+	if err = s.Nvim.Nvim_command("filetype on | syntax on"); err != nil {
+		s.Close()
+		return nil, err
+	}
+	obj, err := s.Nvim.Nvim_call_function("serverstart", []interface{}{})
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.Addr = obj.Get_String()
+
+	return s, nil
+}
+
+// Close stops the highlighter if it is running and then neovim.
+func (s *Session) Close() error {
+	if s.hl != nil && s.hl.Process != nil {
+		s.hl.Process.Kill()
+		s.hl.Wait()
+		s.hl = nil
+	}
+	return s.Emb.Close()
+}
+
+// Open edits the given file and returns its buffer number.
+func (s *Session) Open(fixture string) (int, error) {
+	path, err := filepath.Abs(fixture)
+	if err != nil {
+		return (-1), err
+	}
+	if err = s.Nvim.Nvim_command("edit " + escape_path(path)); err != nil {
+		return (-1), err
+	}
+	return s.Nvim.Nvim_get_current_buf()
+}
+
+// Set_Var sets g:<name>, for instance "tag_highlight#settings".
+func (s *Session) Set_Var(name string, value interface{}) error {
+	return s.Nvim.Nvim_set_var(name, value)
+}
+
+/*
+ * Run_Highlighter starts the tag_highlight binary at bin, pointed at this
+ * session's server address. It attaches to whichever buffer is current, so
+ * call it after Open.
+ */
+func (s *Session) Run_Highlighter(bin string, args ...string) error {
+	if s.hl != nil {
+		return errors.New("The highlighter is already running")
+	}
+	cmd := exec.Command(bin, append([]string{"-addr", s.Addr}, args...)...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Failed to start %s: %s", bin, err)
+	}
+	s.hl = cmd
+	return nil
+}
+
+//========================================================================================
+
+/*
+ * Syntax_List returns the output of `syntax list` for the given group, or for
+ * every group if it's empty. A group that doesn't exist yet yields an empty
+ * string rather than an error.
+ */
+func (s *Session) Syntax_List(group string) (string, error) {
+	cmd := "silent! syntax list"
+	if group != "" {
+		cmd += " " + group
+	}
+	obj, err := s.Nvim.Nvim_exec2(cmd, map[string]interface{}{"output": true})
+	if err != nil {
+		return "", err
+	}
+	var ret struct {
+		Output string `msgpack:"output"`
+	}
+	if err = obj.Unmarshal(&ret); err != nil {
+		return "", err
+	}
+	return ret.Output, nil
+}
+
+/*
+ * Wait_Syntax polls `syntax list` until every word in want shows up in the
+ * listing of its group, returning an error naming whatever is still missing if
+ * that hasn't happened within Timeout. Group names are matched as prefixes, so
+ * "_tag_highlight_c_f" covers the group whatever the link target.
+ */
+func (s *Session) Wait_Syntax(want map[string][]string) error {
+	deadline := time.Now().Add(Timeout)
+	var missing []string
+
+	for {
+		listing, err := s.Syntax_List("")
+		if err != nil {
+			return err
+		}
+		groups := parse_syntax_list(listing)
+		missing = missing[:0]
+
+		for prefix, words := range want {
+			for _, word := range words {
+				if !group_has(groups, prefix, word) {
+					missing = append(missing, prefix+": "+word)
+				}
+			}
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Missing highlights after %s:\n  %s", Timeout, strings.Join(missing, "\n  "))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+/*
+ * Check_Highlight runs the whole pipeline: it starts neovim, applies settings
+ * (names without the "tag_highlight#" prefix), opens the fixture, runs the
+ * highlighter and waits for the expected words to be highlighted.
+ */
+func Check_Highlight(bin, fixture string, settings map[string]interface{}, want map[string][]string) error {
+	s, err := Start()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	for name, value := range settings {
+		if err = s.Set_Var("tag_highlight#"+name, value); err != nil {
+			return err
+		}
+	}
+	if _, err = s.Open(fixture); err != nil {
+		return err
+	}
+	if err = s.Run_Highlighter(bin); err != nil {
+		return err
+	}
+
+	return s.Wait_Syntax(want)
+}
+
+//========================================================================================
+
+/*
+ * The listing puts each group's name at the start of a line followed by its
+ * items; continuation lines are indented. Keywords and match patterns alike
+ * are kept as plain words, which is all the checks need.
+ */
+func parse_syntax_list(listing string) map[string][]string {
+	groups := make(map[string][]string)
+	var cur string
+
+	for _, line := range strings.Split(listing, "\n") {
+		if line == "" || strings.HasPrefix(line, "--- ") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if line[0] != ' ' && line[0] != '\t' {
+			cur = fields[0]
+			fields = fields[1:]
+			if len(fields) > 0 && fields[0] == "xxx" {
+				fields = fields[1:]
+			}
+		}
+		if cur != "" {
+			groups[cur] = append(groups[cur], fields...)
+		}
+	}
+
+	return groups
+}
+
+func group_has(groups map[string][]string, prefix, word string) bool {
+	for name, words := range groups {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		for _, w := range words {
+			if w == word || strings.Contains(w, word) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func escape_path(path string) string {
+	var b strings.Builder
+	for _, c := range path {
+		if strings.ContainsRune(" \\%#|\"", c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
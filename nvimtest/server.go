@@ -0,0 +1,839 @@
+package nvimtest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"tag_highlight/api"
+	"tag_highlight/mpack"
+)
+
+/*
+ * A Fake is a stand-in for neovim that lives in the same process and speaks
+ * msgpack-rpc over a net.Pipe. It serves the handful of api functions the
+ * highlighter uses from the buffers, variables and options it is given, and
+ * records the commands sent to it so that tests can check them.
+ *
+ * Everything exported may be inspected or changed while the Fake is running as
+ * long as it is done under Lock()/Unlock(); the helper methods take care of
+ * that themselves.
+ */
+type Fake struct {
+	Fd       int // Handle to pass to the api functions
+	Buffers  map[int]*Fake_Buffer
+	Current  int
+	Vars     map[string]interface{}
+	Options  map[string]interface{}
+	Eval     map[string]interface{} // Canned results for nvim_eval
+	Output   map[string]string      // Canned output of commands run through nvim_exec2 and friends
+	Handlers map[string]api.Request_Handler
+
+	Commands []string        // Every nvim_command received, in order
+	Atomic   [][]Atomic_Call // Every nvim_call_atomic received, in order
+	Messages []string        // Everything written with nvim_out_write and friends
+	Methods  []string        // The name of every request received
+
+	mutex       sync.Mutex
+	write_mutex sync.Mutex
+	server      net.Conn
+	client      net.Conn
+	dec         *mpack.Decoder
+	done        chan struct{}
+	old_sockfd  int
+	installed   bool
+}
+
+// A Fake_Buffer is one buffer served by a Fake.
+type Fake_Buffer struct {
+	Name     string
+	Lines    []string
+	Options  map[string]interface{}
+	Vars     map[string]interface{}
+	Ctick    int
+	Attached bool
+}
+
+// One call in an nvim_call_atomic request.
+type Atomic_Call struct {
+	Method string
+	Args   []interface{}
+}
+
+var fake_handlers map[string]func(f *Fake, args *mpack.Object) (interface{}, error)
+
+func init() {
+	fake_handlers = map[string]func(f *Fake, args *mpack.Object) (interface{}, error){
+		"nvim_get_api_info":        (*Fake).get_api_info,
+		"nvim_get_current_buf":     (*Fake).get_current_buf,
+		"nvim_list_bufs":           (*Fake).list_bufs,
+		"nvim_buf_get_lines":       (*Fake).buf_get_lines,
+		"nvim_buf_line_count":      (*Fake).buf_line_count,
+		"nvim_buf_get_changedtick": (*Fake).buf_get_changedtick,
+		"nvim_buf_get_name":        (*Fake).buf_get_name,
+		"nvim_buf_get_option":      (*Fake).buf_get_option,
+		"nvim_buf_get_var":         (*Fake).buf_get_var,
+		"nvim_buf_attach":          (*Fake).buf_attach,
+		"nvim_buf_detach":          (*Fake).buf_detach,
+		"nvim_get_var":             (*Fake).get_var,
+		"nvim_set_var":             (*Fake).set_var,
+		"nvim_del_var":             (*Fake).del_var,
+		"nvim_get_option":          (*Fake).get_option,
+		"nvim_get_option_value":    (*Fake).get_option_value,
+		"nvim_command":             (*Fake).command,
+		"nvim_command_output":      (*Fake).command_output,
+		"nvim_exec":                (*Fake).exec,
+		"nvim_exec2":               (*Fake).exec2,
+		"nvim_eval":                (*Fake).eval,
+		"nvim_call_function":       (*Fake).call_function,
+		"nvim_call_atomic":         (*Fake).call_atomic,
+		"nvim_out_write":           (*Fake).write_message,
+		"nvim_err_write":           (*Fake).write_message,
+		"nvim_err_writeln":         (*Fake).write_message,
+		"nvim_echo":                (*Fake).echo,
+		"nvim_buf_clear_namespace": (*Fake).ignore,
+		"nvim_buf_add_highlight":   (*Fake).ignore,
+		"nvim_create_namespace":    (*Fake).ignore,
+		"nvim_buf_set_extmark":     (*Fake).ignore,
+		"nvim_subscribe":           (*Fake).ignore,
+		"nvim_set_client_info":     (*Fake).ignore,
+	}
+}
+
+//========================================================================================
+
+/*
+ * New_Fake starts a fake neovim with no buffers and connects to it. Code that
+ * uses the default connection (fd 0) only talks to it after Install.
+ */
+func New_Fake() *Fake {
+	server, client := net.Pipe()
+	f := &Fake{
+		Buffers:  make(map[int]*Fake_Buffer),
+		Vars:     make(map[string]interface{}),
+		Options:  make(map[string]interface{}),
+		Eval:     make(map[string]interface{}),
+		Output:   make(map[string]string),
+		Handlers: make(map[string]api.Request_Handler),
+		server:   server,
+		client:   client,
+		dec:      mpack.New_Decoder(server),
+		done:     make(chan struct{}),
+	}
+	f.Fd = api.Connect_Stream("fake nvim", client, client)
+
+	go f.serve()
+	return f
+}
+
+// Install makes the fake the default connection until it is closed.
+func (f *Fake) Install() {
+	if !f.installed {
+		f.old_sockfd = api.Sockfd
+		f.installed = true
+	}
+	api.Sockfd = f.Fd
+}
+
+// Close disconnects and stops the server, restoring the default connection if
+// it was installed.
+func (f *Fake) Close() {
+	if f.installed {
+		api.Sockfd = f.old_sockfd
+		f.installed = false
+	}
+	api.Disconnect(f.Fd)
+	f.client.Close()
+	f.server.Close()
+	<-f.done
+}
+
+func (f *Fake) Lock()   { f.mutex.Lock() }
+func (f *Fake) Unlock() { f.mutex.Unlock() }
+
+/*
+ * Add_Buffer adds a buffer with the given name, filetype and contents and makes
+ * it the current one. The number of the new buffer is returned.
+ */
+func (f *Fake) Add_Buffer(name, filetype string, lines ...string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	num := 1
+	for n := range f.Buffers {
+		if n >= num {
+			num = n + 1
+		}
+	}
+	if len(lines) == 0 {
+		lines = []string{""}
+	}
+	f.Buffers[num] = &Fake_Buffer{
+		Name:    name,
+		Lines:   lines,
+		Options: map[string]interface{}{"filetype": filetype, "ft": filetype},
+		Vars:    make(map[string]interface{}),
+		Ctick:   1,
+	}
+	f.Current = num
+
+	return num
+}
+
+// Buffer returns the buffer with the given number, or nil.
+func (f *Fake) Buffer(num int) *Fake_Buffer {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.Buffers[num]
+}
+
+// Set_Var sets the global variable g:<name>.
+func (f *Fake) Set_Var(name string, value interface{}) {
+	f.mutex.Lock()
+	f.Vars[name] = value
+	f.mutex.Unlock()
+}
+
+// Set_Option sets a global option.
+func (f *Fake) Set_Option(name string, value interface{}) {
+	f.mutex.Lock()
+	f.Options[name] = value
+	f.mutex.Unlock()
+}
+
+// Get_Commands returns a copy of every nvim_command received so far.
+func (f *Fake) Get_Commands() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string(nil), f.Commands...)
+}
+
+// Get_Atomic returns a copy of every nvim_call_atomic received so far.
+func (f *Fake) Get_Atomic() [][]Atomic_Call {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([][]Atomic_Call(nil), f.Atomic...)
+}
+
+// Reset forgets every recorded command, atomic call, message and method.
+func (f *Fake) Reset() {
+	f.mutex.Lock()
+	f.Commands = nil
+	f.Atomic = nil
+	f.Messages = nil
+	f.Methods = nil
+	f.mutex.Unlock()
+}
+
+//========================================================================================
+
+/*
+ * Push_Lines replaces lines [first, last) of the buffer with the given ones,
+ * bumps its changedtick and sends the nvim_buf_lines_event neovim would. The
+ * buffer need not be attached, which makes it possible to test what happens
+ * with an event for a buffer nobody asked about.
+ */
+func (f *Fake) Push_Lines(bufnum, first, last int, lines []string, more bool) error {
+	f.mutex.Lock()
+	ctick := 0
+	if buf := f.Buffers[bufnum]; buf != nil {
+		if last < 0 || last > len(buf.Lines) {
+			last = len(buf.Lines)
+		}
+		if first > last {
+			first = last
+		}
+		tmp := make([]string, 0, len(buf.Lines)-(last-first)+len(lines))
+		tmp = append(tmp, buf.Lines[:first]...)
+		tmp = append(tmp, lines...)
+		buf.Lines = append(tmp, buf.Lines[last:]...)
+		buf.Ctick++
+		ctick = buf.Ctick
+	}
+	f.mutex.Unlock()
+
+	if lines == nil {
+		lines = []string{}
+	}
+	return f.Push("nvim_buf_lines_event", buffer(bufnum), ctick, first, last, lines, more)
+}
+
+// Push_Changedtick sends an nvim_buf_changedtick_event for the buffer.
+func (f *Fake) Push_Changedtick(bufnum int) error {
+	f.mutex.Lock()
+	ctick := 0
+	if buf := f.Buffers[bufnum]; buf != nil {
+		buf.Ctick++
+		ctick = buf.Ctick
+	}
+	f.mutex.Unlock()
+
+	return f.Push("nvim_buf_changedtick_event", buffer(bufnum), ctick)
+}
+
+// Push sends an arbitrary notification.
+func (f *Fake) Push(method string, args ...interface{}) error {
+	if args == nil {
+		args = []interface{}{}
+	}
+	return f.send([]interface{}{api.MES_NOTIFICATION, method, args})
+}
+
+//========================================================================================
+
+func (f *Fake) serve() {
+	defer close(f.done)
+
+	for {
+		obj, err := f.dec.Decode()
+		if err != nil {
+			return
+		}
+		if obj.Mtype != mpack.T_ARRAY || obj.Len() < 3 {
+			continue
+		}
+
+		switch obj.Index(0).Get_Int() {
+		case api.MES_REQUEST:
+			if obj.Len() != 4 {
+				continue
+			}
+			id := obj.Index(1).Get_Int64()
+			result, err := f.handle(obj.Index(2).Get_String(), obj.Index(3), true)
+			f.respond(id, err, result)
+		case api.MES_NOTIFICATION:
+			f.handle(obj.Index(1).Get_String(), obj.Index(2), true)
+		}
+	}
+}
+
+/*
+ * Calls inside nvim_call_atomic go through here too, but are only recorded as
+ * part of the atomic call itself.
+ */
+func (f *Fake) handle(method string, args *mpack.Object, record bool) (interface{}, error) {
+	f.mutex.Lock()
+	if record {
+		f.Methods = append(f.Methods, method)
+	}
+	if record && method == "nvim_command" && args.Mtype == mpack.T_ARRAY && args.Len() > 0 {
+		f.Commands = append(f.Commands, args.Index(0).Get_String())
+	}
+	fn := f.Handlers[method]
+	f.mutex.Unlock()
+
+	if fn != nil {
+		return fn(args)
+	}
+	if args.Mtype != mpack.T_ARRAY {
+		return nil, validation("Arguments must be an array")
+	}
+	if handler := fake_handlers[method]; handler != nil {
+		return handler(f, args)
+	}
+
+	return nil, validation("Invalid method: %s", method)
+}
+
+func (f *Fake) respond(id int64, err error, result interface{}) {
+	var e interface{}
+	if err != nil {
+		etype := int64(api.NVIM_EXCEPTION)
+		var nerr *api.NvimError
+		if errors.As(err, &nerr) {
+			etype = nerr.Type
+			err = errors.New(nerr.Message)
+		}
+		e = []interface{}{etype, err.Error()}
+		result = nil
+	}
+	f.send([]interface{}{api.MES_RESPONSE, id, e, result})
+}
+
+func (f *Fake) send(msg []interface{}) error {
+	data, err := mpack.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	f.write_mutex.Lock()
+	defer f.write_mutex.Unlock()
+	_, err = f.server.Write(data)
+	return err
+}
+
+//========================================================================================
+
+func validation(format string, a ...interface{}) error {
+	return &api.NvimError{Type: api.NVIM_VALIDATION, Message: fmt.Sprintf(format, a...)}
+}
+
+func exception(format string, a ...interface{}) error {
+	return &api.NvimError{Type: api.NVIM_EXCEPTION, Message: fmt.Sprintf(format, a...)}
+}
+
+/* Buffers go over the wire as ext type 0, like neovim sends them. */
+func buffer(num int) mpack.Ext {
+	return mpack.Ext{Etype: 0, Num: uint32(num)}
+}
+
+func arg_int(args *mpack.Object, i int) (int, error) {
+	if i >= args.Len() {
+		return 0, validation("Missing argument %d", i+1)
+	}
+	val, err := args.Index(i).Expect(mpack.T_NUM)
+	if err != nil {
+		return 0, validation("Argument %d: %s", i+1, err)
+	}
+	return int(val.(int64)), nil
+}
+
+func arg_string(args *mpack.Object, i int) (string, error) {
+	if i >= args.Len() {
+		return "", validation("Missing argument %d", i+1)
+	}
+	val, err := args.Index(i).Expect(mpack.E_STRING)
+	if err != nil || val == nil {
+		return "", validation("Argument %d: expected a string", i+1)
+	}
+	return val.(string), nil
+}
+
+func arg_value(args *mpack.Object, i int) (interface{}, error) {
+	if i >= args.Len() {
+		return nil, validation("Missing argument %d", i+1)
+	}
+	var val interface{}
+	err := args.Index(i).Unmarshal(&val)
+	return val, err
+}
+
+/* Buffer 0 means the current buffer, as in neovim. Call with the lock held. */
+func (f *Fake) arg_buffer(args *mpack.Object, i int) (*Fake_Buffer, error) {
+	num, err := arg_int(args, i)
+	if err != nil {
+		return nil, err
+	}
+	if num == 0 {
+		num = f.Current
+	}
+	buf := f.Buffers[num]
+	if buf == nil {
+		return nil, validation("Invalid buffer id: %d", num)
+	}
+	return buf, nil
+}
+
+//========================================================================================
+
+func (f *Fake) get_api_info(args *mpack.Object) (interface{}, error) {
+	return []interface{}{f.Fd, map[string]interface{}{}}, nil
+}
+
+func (f *Fake) get_current_buf(args *mpack.Object) (interface{}, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Buffers[f.Current] == nil {
+		return nil, exception("No current buffer")
+	}
+	return buffer(f.Current), nil
+}
+
+func (f *Fake) list_bufs(args *mpack.Object) (interface{}, error) {
+	f.mutex.Lock()
+	nums := make([]int, 0, len(f.Buffers))
+	for n := range f.Buffers {
+		nums = append(nums, n)
+	}
+	f.mutex.Unlock()
+
+	sort.Ints(nums)
+	ret := make([]mpack.Ext, len(nums))
+	for i, n := range nums {
+		ret[i] = buffer(n)
+	}
+	return ret, nil
+}
+
+/*
+ * Negative indices count from the end, with -1 meaning one past the last line,
+ * the same as neovim.
+ */
+func (f *Fake) buf_get_lines(args *mpack.Object) (interface{}, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	buf, err := f.arg_buffer(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	start, err := arg_int(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	end, err := arg_int(args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(buf.Lines)
+	if start < 0 {
+		start += n + 1
+	}
+	if end < 0 {
+		end += n + 1
+	}
+	if start < 0 || end > n || start > end {
+		return nil, validation("Index out of bounds")
+	}
+
+	return append([]string{}, buf.Lines[start:end]...), nil
+}
+
+func (f *Fake) buf_line_count(args *mpack.Object) (interface{}, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	buf, err := f.arg_buffer(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return len(buf.Lines), nil
+}
+
+func (f *Fake) buf_get_changedtick(args *mpack.Object) (interface{}, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	buf, err := f.arg_buffer(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Ctick, nil
+}
+
+func (f *Fake) buf_get_name(args *mpack.Object) (interface{}, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	buf, err := f.arg_buffer(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Name, nil
+}
+
+func (f *Fake) buf_get_option(args *mpack.Object) (interface{}, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	buf, err := f.arg_buffer(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	name, err := arg_string(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if val, ok := buf.Options[name]; ok {
+		return val, nil
+	}
+	return nil, validation("Invalid option name: '%s'", name)
+}
+
+func (f *Fake) buf_get_var(args *mpack.Object) (interface{}, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	buf, err := f.arg_buffer(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	name, err := arg_string(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if val, ok := buf.Vars[name]; ok {
+		return val, nil
+	}
+	return nil, validation("Key not found: %s", name)
+}
+
+func (f *Fake) buf_attach(args *mpack.Object) (interface{}, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	buf, err := f.arg_buffer(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	buf.Attached = true
+	return true, nil
+}
+
+func (f *Fake) buf_detach(args *mpack.Object) (interface{}, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	buf, err := f.arg_buffer(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	buf.Attached = false
+	return true, nil
+}
+
+func (f *Fake) get_var(args *mpack.Object) (interface{}, error) {
+	name, err := arg_string(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if val, ok := f.Vars[name]; ok {
+		return val, nil
+	}
+	return nil, validation("Key not found: %s", name)
+}
+
+func (f *Fake) set_var(args *mpack.Object) (interface{}, error) {
+	name, err := arg_string(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	val, err := arg_value(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	f.Set_Var(name, val)
+	return nil, nil
+}
+
+func (f *Fake) del_var(args *mpack.Object) (interface{}, error) {
+	name, err := arg_string(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.Vars[name]; !ok {
+		return nil, validation("Key not found: %s", name)
+	}
+	delete(f.Vars, name)
+	return nil, nil
+}
+
+func (f *Fake) get_option(args *mpack.Object) (interface{}, error) {
+	name, err := arg_string(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if val, ok := f.Options[name]; ok {
+		return val, nil
+	}
+	return nil, validation("Invalid option name: '%s'", name)
+}
+
+/* Only the "buf" key of the options dictionary is looked at. */
+func (f *Fake) get_option_value(args *mpack.Object) (interface{}, error) {
+	name, err := arg_string(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	var opts struct {
+		Buf int `msgpack:"buf"`
+	}
+	if args.Len() > 1 {
+		if err = args.Index(1).Unmarshal(&opts); err != nil {
+			return nil, validation("Bad options: %s", err)
+		}
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if buf := f.Buffers[opts.Buf]; opts.Buf != 0 && buf != nil {
+		if val, ok := buf.Options[name]; ok {
+			return val, nil
+		}
+	}
+	if val, ok := f.Options[name]; ok {
+		return val, nil
+	}
+	return nil, validation("Invalid option name: '%s'", name)
+}
+
+func (f *Fake) command(args *mpack.Object) (interface{}, error) {
+	if _, err := arg_string(args, 0); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+/*
+ * Commands whose output is asked for are recorded like any other and answered
+ * from Output, or with nothing.
+ */
+func (f *Fake) run_output(cmd string) string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.Commands = append(f.Commands, cmd)
+	return f.Output[cmd]
+}
+
+func (f *Fake) command_output(args *mpack.Object) (interface{}, error) {
+	cmd, err := arg_string(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return f.run_output(cmd), nil
+}
+
+func (f *Fake) exec(args *mpack.Object) (interface{}, error) {
+	src, err := arg_string(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return f.run_output(src), nil
+}
+
+func (f *Fake) exec2(args *mpack.Object) (interface{}, error) {
+	src, err := arg_string(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"output": f.run_output(src)}, nil
+}
+
+func (f *Fake) eval(args *mpack.Object) (interface{}, error) {
+	expr, err := arg_string(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if val, ok := f.Eval[expr]; ok {
+		return val, nil
+	}
+	return nil, exception("Vim:E121: Undefined variable: %s", expr)
+}
+
+/*
+ * Only tempname() is built in. Anything else has to be given a handler for
+ * "nvim_call_function" or be listed in Eval as "name()".
+ */
+func (f *Fake) call_function(args *mpack.Object) (interface{}, error) {
+	name, err := arg_string(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if name == "tempname" {
+		return filepath.Join(os.TempDir(), fmt.Sprintf("nvimtest_%d", f.Fd)), nil
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if val, ok := f.Eval[name+"()"]; ok {
+		return val, nil
+	}
+	return nil, exception("Vim:E117: Unknown function: %s", name)
+}
+
+/*
+ * Every call is run as if made on its own and the results collected. Like
+ * neovim, the first failure stops the rest and is reported as
+ * [index, type, message] alongside the results so far.
+ */
+func (f *Fake) call_atomic(args *mpack.Object) (interface{}, error) {
+	if args.Len() != 1 || args.Index(0).Mtype != mpack.T_ARRAY {
+		return nil, validation("nvim_call_atomic expects a list of calls")
+	}
+	var (
+		list    = args.Index(0)
+		calls   = make([]Atomic_Call, 0, list.Len())
+		results = make([]interface{}, 0, list.Len())
+		failure interface{}
+	)
+
+	for i := 0; i < list.Len(); i++ {
+		call := list.Index(i)
+		if call.Mtype != mpack.T_ARRAY || call.Len() != 2 {
+			return nil, validation("Items in calls array must be arrays of size 2")
+		}
+		method := call.Index(0).Get_String()
+		var cargs []interface{}
+		if err := call.Index(1).Unmarshal(&cargs); err != nil {
+			return nil, validation("Args must be an array: %s", err)
+		}
+		calls = append(calls, Atomic_Call{method, cargs})
+	}
+
+	f.mutex.Lock()
+	f.Atomic = append(f.Atomic, calls)
+	f.mutex.Unlock()
+
+	for i := 0; i < list.Len(); i++ {
+		res, err := f.handle(calls[i].Method, list.Index(i).Index(1), false)
+		if err != nil {
+			etype := int64(api.NVIM_EXCEPTION)
+			var nerr *api.NvimError
+			msg := err.Error()
+			if errors.As(err, &nerr) {
+				etype, msg = nerr.Type, nerr.Message
+			}
+			failure = []interface{}{i, etype, msg}
+			break
+		}
+		results = append(results, res)
+	}
+
+	return []interface{}{results, failure}, nil
+}
+
+func (f *Fake) write_message(args *mpack.Object) (interface{}, error) {
+	str, err := arg_string(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	f.Messages = append(f.Messages, str)
+	f.mutex.Unlock()
+	return nil, nil
+}
+
+/* The chunks are [text, hl_group] pairs; only the text is kept. */
+func (f *Fake) echo(args *mpack.Object) (interface{}, error) {
+	var chunks [][]interface{}
+	if args.Len() > 0 {
+		if err := args.Index(0).Unmarshal(&chunks); err != nil {
+			return nil, validation("Bad chunks: %s", err)
+		}
+	}
+	var b strings.Builder
+	for _, chunk := range chunks {
+		if len(chunk) > 0 {
+			if s, ok := chunk[0].(string); ok {
+				b.WriteString(s)
+			}
+		}
+	}
+
+	f.mutex.Lock()
+	f.Messages = append(f.Messages, b.String())
+	f.mutex.Unlock()
+	return nil, nil
+}
+
+func (f *Fake) ignore(args *mpack.Object) (interface{}, error) {
+	return nil, nil
+}
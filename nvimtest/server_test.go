@@ -0,0 +1,96 @@
+package nvimtest
+
+import (
+	"reflect"
+	"tag_highlight/api"
+	"testing"
+	"time"
+)
+
+func TestFakeVars(t *testing.T) {
+	f := New_Fake()
+	defer f.Close()
+
+	f.Set_Var("tag_highlight#settings", map[string]interface{}{"enabled": true, "ctags_args": []string{"--fields=+l"}})
+	var settings struct {
+		Enabled    bool     `msgpack:"enabled"`
+		Ctags_args []string `msgpack:"ctags_args"`
+	}
+	if err := api.Nvim_get_var_into(f.Fd, []byte("tag_highlight#settings"), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if !settings.Enabled || !reflect.DeepEqual(settings.Ctags_args, []string{"--fields=+l"}) {
+		t.Errorf("got %+v", settings)
+	}
+
+	if _, err := api.Nvim_get_var(f.Fd, []byte("nonexistent"), 0); err == nil {
+		t.Error("expected an error for a variable that isn't set")
+	}
+}
+
+func TestFakeAtomic(t *testing.T) {
+	f := New_Fake()
+	defer f.Close()
+
+	calls := &api.Atomic_list{}
+	calls.Nvim_command([]byte("syntax clear"))
+	calls.Nvim_command([]byte("hi link Foo Bar"))
+	if err := api.Nvim_call_atomic(f.Fd, calls); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]Atomic_Call{{
+		{"nvim_command", []interface{}{"syntax clear"}},
+		{"nvim_command", []interface{}{"hi link Foo Bar"}},
+	}}
+	if got := f.Get_Atomic(); !reflect.DeepEqual(got, want) {
+		t.Errorf("recorded %#v, want %#v", got, want)
+	}
+}
+
+func TestFakePushLines(t *testing.T) {
+	f := New_Fake()
+	defer f.Close()
+
+	bufnum := f.Add_Buffer("/tmp/foo.c", "c", "int a;", "int b;", "int c;")
+	ctick := f.Buffer(bufnum).Ctick
+	events, err := api.Notifications(f.Fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go f.Push_Lines(bufnum, 1, 2, []string{"int x;", "int y;"}, false)
+
+	select {
+	case ev := <-events:
+		if ev.Len() != 3 || ev.Index(1).Get_String() != "nvim_buf_lines_event" {
+			t.Fatalf("unexpected notification %v", ev)
+		}
+		var args struct {
+			Buf   int64
+			Ctick int
+			First int
+			Last  int
+			Lines []string
+			More  bool
+		}
+		if err := ev.Index(2).Unmarshal(&args); err != nil {
+			t.Fatal(err)
+		}
+		if args.Buf != int64(bufnum) || args.Ctick != ctick+1 || args.First != 1 || args.Last != 2 || args.More ||
+			!reflect.DeepEqual(args.Lines, []string{"int x;", "int y;"}) {
+			t.Errorf("got %+v", args)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification received")
+	}
+
+	want := []string{"int a;", "int x;", "int y;", "int c;"}
+	if got := f.Buffer(bufnum).Lines; !reflect.DeepEqual(got, want) {
+		t.Errorf("buffer is %q, want %q", got, want)
+	}
+	lines, err := api.Nvim_buf_get_lines(f.Fd, bufnum, 0, -1)
+	if err != nil || !reflect.DeepEqual(lines, want) {
+		t.Errorf("nvim_buf_get_lines gave %q (%v), want %q", lines, err, want)
+	}
+}
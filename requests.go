@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"tag_highlight/api"
+	"tag_highlight/mpack"
+)
+
+//========================================================================================
+
+/*
+ * Methods the vimscript side may call synchronously with rpcrequest(). Each one
+ * that concerns a particular buffer takes the buffer number as its first
+ * argument.
+ */
+func register_handlers() {
+	api.Register("tag_highlight_status", with_event_mutex(rpc_status))
+	api.Register("tag_highlight_tags", with_event_mutex(rpc_buffer_tags))
+	api.Register("tag_highlight_update", with_event_mutex(rpc_force_update))
+}
+
+/*
+ * Requests are answered on goroutines of their own, so they take the same lock
+ * as the events and the watcher before going anywhere near the buffers.
+ */
+func with_event_mutex(fn api.Request_Handler) api.Request_Handler {
+	return func(args *mpack.Object) (interface{}, error) {
+		event_mutex.Lock()
+		defer event_mutex.Unlock()
+		return fn(args)
+	}
+}
+
+//========================================================================================
+
+func rpc_status(args *mpack.Object) (interface{}, error) {
+	var (
+		bufs  = make([]int, 0, buffers.mkr)
+		dirs  = make([]string, 0, len(TopDir_List))
+		files = make([]string, 0, buffers.mkr)
+	)
+
+	for _, bdata := range buffers.lst {
+		if bdata != nil {
+			bufs = append(bufs, int(bdata.Num))
+			files = append(files, bdata.Filename)
+		}
+	}
+	for _, topdir := range TopDir_List {
+		if topdir != nil {
+			dirs = append(dirs, topdir.Pathname)
+		}
+	}
+
+	return map[string]interface{}{
+		"enabled": Settings.Enabled,
+		"buffers": bufs,
+		"files":   files,
+		"topdirs": dirs,
+	}, nil
+}
+
+func rpc_buffer_tags(args *mpack.Object) (interface{}, error) {
+	bdata, err := rpc_get_buffer(args)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]interface{}, len(bdata.Ft.Order))
+	for _, tag := range bdata.Highlighted {
+		kind := string(tag.Kind)
+		if lst, ok := ret[kind]; ok {
+			ret[kind] = append(lst.([][]byte), tag.Str)
+		} else {
+			ret[kind] = [][]byte{tag.Str}
+		}
+	}
+
+	return ret, nil
+}
+
+func rpc_force_update(args *mpack.Object) (interface{}, error) {
+	bdata, err := rpc_get_buffer(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bdata.Update_Taglist(1) {
+		return nil, fmt.Errorf("Failed to update the tag list for buffer %d", bdata.Num)
+	}
+	bdata.Update_Highlight()
+
+	return true, nil
+}
+
+//========================================================================================
+
+func rpc_get_buffer(args *mpack.Object) (*Bufdata, error) {
+	if args.Mtype != mpack.T_ARRAY || args.Len() == 0 {
+		return nil, errors.New("Expected a buffer number argument")
+	}
+
+	bufnum := args.Index(0).Get_Int()
+	if bufnum == 0 {
+		var err error
+		if bufnum, err = api.Nvim_get_current_buf(0); err != nil {
+			return nil, err
+		}
+	}
+
+	bdata := Find_Buffer(bufnum)
+	if bdata == nil {
+		return nil, fmt.Errorf("Buffer %d is not attached", bufnum)
+	}
+
+	return bdata, nil
+}
@@ -0,0 +1,190 @@
+package scan
+
+import (
+	"bytes"
+)
+
+/*
+ * The state a line of a C-like language starts in, which depends on the lines
+ * before it. Anything from in_if_zero up is the nesting depth of an `#if 0`
+ * block (C and C++ only) plus in_if_zero - 1.
+ */
+const (
+	in_code = iota
+	in_block_comment
+	in_line_comment // A // comment continued with a backslash
+	in_raw_string   // Go `raw strings`
+	in_if_zero
+)
+
+//========================================================================================
+
+/*
+ * lex_line calls fn with the bounds of every identifier in the line, skipping
+ * whatever Strip_Comments would strip (comments, strings and `#if 0` blocks)
+ * for the languages it strips them for. The returned state is the one the next
+ * line starts in. With a nil fn only the state is tracked.
+ */
+func lex_line(id int, line []byte, state int, check cmp_f, fn func(start, end int)) int {
+	if !is_c_like(id) {
+		lex_plain(line, 0, check, fn)
+		return in_code
+	}
+	if state >= in_if_zero || ((id == FT_C || id == FT_CPP) && state == in_code) {
+		if next, skip := if_zero_state(line, state); skip {
+			return next
+		}
+	}
+	if state == in_line_comment {
+		return line_comment_state(line)
+	}
+
+	for i := 0; i < len(line); {
+		switch state {
+		case in_block_comment:
+			n := bytes.Index(line[i:], []byte("*/"))
+			if n == (-1) {
+				return state
+			}
+			i += n + 2
+			state = in_code
+			continue
+		case in_raw_string:
+			n := bytes.IndexByte(line[i:], '`')
+			if n == (-1) {
+				return state
+			}
+			i += n + 1
+			state = in_code
+			continue
+		}
+
+		ch := line[i]
+		switch {
+		case ch == '/' && i+1 < len(line) && line[i+1] == '/':
+			return line_comment_state(line)
+		case ch == '/' && i+1 < len(line) && line[i+1] == '*':
+			state = in_block_comment
+			i += 2
+		case ch == '`' && id == FT_GO:
+			state = in_raw_string
+			i++
+		case ch == '"' || ch == '\'':
+			i = skip_string(line, i)
+		default:
+			i = lex_token(line, i, check, fn)
+		}
+	}
+
+	return state
+}
+
+func lex_plain(line []byte, i int, check cmp_f, fn func(start, end int)) {
+	for i < len(line) {
+		i = lex_token(line, i, check, fn)
+	}
+}
+
+/*
+ * Consumes one identifier, number or other character starting at i and returns
+ * the index after it. The rest of a number is skipped so that eg. the "x1F" of
+ * 0x1F isn't taken for an identifier.
+ */
+func lex_token(line []byte, i int, check cmp_f, fn func(start, end int)) int {
+	ch := line[i]
+
+	switch {
+	case check(ch, true):
+		start := i
+		for i++; i < len(line) && check(line[i], false); i++ {
+		}
+		if fn != nil {
+			fn(start, i)
+		}
+	case isalnum(ch):
+		for i++; i < len(line) && (line[i] == '_' || isalnum(line[i])); i++ {
+		}
+	default:
+		i++
+	}
+
+	return i
+}
+
+func line_comment_state(line []byte) int {
+	if len(line) > 0 && line[len(line)-1] == '\\' {
+		return in_line_comment
+	}
+	return in_code
+}
+
+/*
+ * Handles `#if 0` blocks the same way strip_c_like does. If the line is part
+ * of one (including the lines that open and close it) it is skipped entirely
+ * and the state after it is returned.
+ */
+func if_zero_state(line []byte, state int) (int, bool) {
+	i := 0
+	for i < len(line) && isblank(line[i]) {
+		i++
+	}
+	directive := []byte(nil)
+	if i < len(line) && line[i] == '#' {
+		for i++; i < len(line) && isblank(line[i]); i++ {
+		}
+		directive = line[i:]
+	}
+
+	if state == in_code {
+		if bytes.HasPrefix(directive, []byte("if 0")) {
+			return in_if_zero, true
+		}
+		return state, false
+	}
+
+	switch {
+	case bytes.HasPrefix(directive, []byte("endif")):
+		state--
+		if state < in_if_zero {
+			state = in_code
+		}
+	case bytes.HasPrefix(directive, []byte("if")):
+		state++
+	}
+	return state, true
+}
+
+/* Returns the index just past the closing quote, or the end of the line. */
+func skip_string(line []byte, i int) int {
+	quote := line[i]
+	esc := false
+
+	for i++; i < len(line); i++ {
+		switch {
+		case esc:
+			esc = false
+		case line[i] == '\\':
+			esc = true
+		case line[i] == quote:
+			return i + 1
+		}
+	}
+
+	return i
+}
+
+func is_c_like(id int) bool {
+	switch id {
+	case FT_C, FT_CPP, FT_GO, FT_JAVA, FT_CSHARP:
+		return true
+	default:
+		return false
+	}
+}
+
+func lex_check(id int) cmp_f {
+	if id == FT_VIM {
+		return vim_func
+	}
+	return c_func
+}
@@ -0,0 +1,110 @@
+package scan
+
+import (
+	"bytes"
+)
+
+// A Position is one occurrence of a tag in the buffer: the (zero based) line
+// and the byte range [Start, End) within it.
+type Position struct {
+	Line  int
+	Start int
+	End   int
+	Kind  byte
+}
+
+//========================================================================================
+
+// A Tag_Set maps the name of every tag to be highlighted to its kind.
+type Tag_Set map[string]byte
+
+/*
+ * Find_Positions locates every occurrence in the buffer of the given (already
+ * matched) tags. It has to work line by line rather than on the joined buffer
+ * that Scan uses, since the positions must refer to the buffer as neovim sees
+ * it, so comments are skipped by tracking them as we go rather than by running
+ * Strip_Comments, which doesn't preserve columns. As with Strip_Comments, only
+ * C-like languages have their comments and strings skipped.
+ */
+func (bdata *Bufdata) Find_Positions(tags []Tag) []Position {
+	if len(tags) == 0 {
+		return nil
+	}
+	return bdata.Find_Positions_In(Make_Tag_Set(tags, bdata.Order), 0, (-1))
+}
+
+/*
+ * Find_Positions_In does the same for lines [first, last) only (a negative last
+ * meaning the end of the buffer). The lines before first still have to be
+ * looked at to know whether the range starts inside a comment, but nothing is
+ * looked up for them.
+ */
+func (bdata *Bufdata) Find_Positions_In(set Tag_Set, first, last int) []Position {
+	if len(set) == 0 || bdata.Vimbuf == nil {
+		return nil
+	}
+	var (
+		check = lex_check(bdata.Id)
+		ret   = make([]Position, 0, 1024)
+		state = in_code
+		start = 0
+	)
+	if !is_c_like(bdata.Id) {
+		start = first
+	}
+
+	bdata.Vimbuf.Range(start, last, func(lnum int, str string) bool {
+		line := []byte(str)
+		switch {
+		case lnum >= first:
+			state = lex_line(bdata.Id, line, state, check, func(start, end int) {
+				add_position(line, lnum, start, end, set, &ret)
+			})
+		default:
+			state = lex_line(bdata.Id, line, state, check, nil)
+		}
+		return true
+	})
+
+	return ret
+}
+
+/*
+ * A name can have more than one kind (a struct and a typedef of the same name,
+ * say). The kind that comes first in the filetype's order wins, which is also
+ * how the syntax backend ends up resolving it.
+ */
+func Make_Tag_Set(tags []Tag, order []byte) Tag_Set {
+	kinds := make(Tag_Set, len(tags))
+
+	for _, t := range tags {
+		name := string(t.Str)
+		if prev, ok := kinds[name]; ok {
+			a, b := bytes.IndexByte(order, prev), bytes.IndexByte(order, t.Kind)
+			if a != (-1) && (b == (-1) || a <= b) {
+				continue
+			}
+		}
+		kinds[name] = t.Kind
+	}
+
+	return kinds
+}
+
+/*
+ * Vim identifiers may carry a scope prefix ("s:Foo"), which ctags leaves off,
+ * so the name without it is tried as well, the same as tokenize_vim does.
+ */
+func add_position(line []byte, lnum, start, end int, kinds Tag_Set, ret *[]Position) {
+	tok := line[start:end]
+
+	if kind, ok := kinds[string(tok)]; ok {
+		*ret = append(*ret, Position{lnum, start, end, kind})
+		return
+	}
+	if n := bytes.IndexByte(tok, ':'); n > 0 && n+1 < len(tok) {
+		if kind, ok := kinds[string(tok[n+1:])]; ok {
+			*ret = append(*ret, Position{lnum, start + n + 1, end, kind})
+		}
+	}
+}
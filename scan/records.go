@@ -0,0 +1,243 @@
+package scan
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime"
+	"strconv"
+	"sync"
+	"tag_highlight/util"
+)
+
+/*
+ * One tag as ctags describes it, from either its JSON output or a line of a
+ * traditional tags file. Kind is the kind letter; Kind_Long is the kind's full
+ * name, which only some output has.
+ */
+type Tag_Record struct {
+	Name       string
+	Path       string
+	Kind_Long  string
+	Language   string
+	Scope      string
+	Scope_Kind string
+	Access     string
+	Line       int
+	Kind       byte
+}
+
+/* The shape of a tag from `ctags --output-format=json`. */
+type json_record struct {
+	Type       string `json:"_type"`
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	Kind       string `json:"kind"`
+	Language   string `json:"language,omitempty"`
+	Scope      string `json:"scope,omitempty"`
+	Scope_Kind string `json:"scopeKind,omitempty"`
+	Access     string `json:"access,omitempty"`
+	Line       int    `json:"line,omitempty"`
+}
+
+/* Extension fields of a tags file that say nothing about the tag's scope. */
+var legacy_other_fields = map[string]bool{
+	"signature": true, "file": true, "typeref": true, "inherits": true,
+	"implementation": true, "end": true, "roles": true, "extras": true,
+	"nth": true, "properties": true, "template": true, "captures": true,
+	"epoch": true, "xpath": true,
+}
+
+//========================================================================================
+
+/*
+ * Parse_Tags turns the output of ctags into records. Each line is either a JSON
+ * object (universal-ctags with --output-format=json) or a line of a tags file
+ * (anything else, including exuberant ctags), so the two can even be mixed.
+ * Pseudo tags, blank lines and lines that can't be made sense of are dropped.
+ */
+func Parse_Tags(raw [][]byte) []Tag_Record {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var (
+		nthreads = runtime.NumCPU()
+		quot     = (len(raw) + nthreads - 1) / nthreads
+		parts    = make([][]Tag_Record, nthreads)
+		wg       sync.WaitGroup
+	)
+
+	for i := 0; i < nthreads && i*quot < len(raw); i++ {
+		wg.Add(1)
+		go func(i int, lines [][]byte) {
+			defer wg.Done()
+			parts[i] = make([]Tag_Record, 0, len(lines))
+			for _, line := range lines {
+				if rec, ok := Parse_Tag(line); ok {
+					parts[i] = append(parts[i], rec)
+				}
+			}
+		}(i, raw[i*quot:util.Min_Int(len(raw), (i+1)*quot)])
+	}
+	wg.Wait()
+
+	ret := make([]Tag_Record, 0, len(raw))
+	for _, part := range parts {
+		ret = append(ret, part...)
+	}
+	return ret
+}
+
+// Parse_Tag parses a single line of ctags output in either format.
+func Parse_Tag(line []byte) (Tag_Record, bool) {
+	line = bytes.TrimRight(line, "\r")
+	if len(line) == 0 {
+		return Tag_Record{}, false
+	}
+	if line[0] == '{' {
+		return parse_json_tag(line)
+	}
+	return parse_legacy_tag(line)
+}
+
+func parse_json_tag(line []byte) (Tag_Record, bool) {
+	var jrec json_record
+	if err := json.Unmarshal(line, &jrec); err != nil || jrec.Type != "tag" {
+		return Tag_Record{}, false
+	}
+
+	rec := Tag_Record{
+		Name:       jrec.Name,
+		Path:       jrec.Path,
+		Language:   jrec.Language,
+		Scope:      jrec.Scope,
+		Scope_Kind: jrec.Scope_Kind,
+		Access:     jrec.Access,
+		Line:       jrec.Line,
+	}
+	set_kind(&rec, jrec.Kind)
+
+	return rec, true
+}
+
+/*
+ * JSON returns the record as a line of `ctags --output-format=json` output, for
+ * tags that come from somewhere other than ctags but are stored alongside its
+ * output. That format has only one kind field, so when the record has both a
+ * kind letter and a long kind name only the letter is kept; the name is written
+ * only for records without a letter.
+ */
+func (rec *Tag_Record) JSON() []byte {
+	jrec := json_record{
+		Type:       "tag",
+		Name:       rec.Name,
+		Path:       rec.Path,
+		Kind:       rec.Kind_Long,
+		Language:   rec.Language,
+		Scope:      rec.Scope,
+		Scope_Kind: rec.Scope_Kind,
+		Access:     rec.Access,
+		Line:       rec.Line,
+	}
+	if rec.Kind != 0 {
+		jrec.Kind = string(rec.Kind)
+	}
+
+	ret, _ := json.Marshal(&jrec)
+	return ret
+}
+
+/*
+ * A tags file line is
+ *     name<TAB>path<TAB>address;"<TAB>field<TAB>field...
+ * where the address is a line number or a search pattern, and a pattern can
+ * contain tabs. Fields are either a lone kind letter or key:value.
+ */
+func parse_legacy_tag(line []byte) (Tag_Record, bool) {
+	if line[0] == '!' {
+		return Tag_Record{}, false
+	}
+	i := bytes.IndexByte(line, '\t')
+	if i <= 0 {
+		return Tag_Record{}, false
+	}
+	j := bytes.IndexByte(line[i+1:], '\t')
+	if j == (-1) {
+		return Tag_Record{}, false
+	}
+	rec := Tag_Record{
+		Name: string(line[:i]),
+		Path: string(line[i+1 : i+1+j]),
+	}
+
+	rest := line[i+j+2:]
+	end := address_end(rest)
+	if !bytes.HasPrefix(rest[end:], []byte(";\"\t")) {
+		/* No extension fields, so no kind either. */
+		return rec, true
+	}
+
+	for _, field := range bytes.Split(rest[end+3:], []byte("\t")) {
+		key, val, found := bytes.Cut(field, []byte(":"))
+		if !found {
+			if len(field) == 1 {
+				rec.Kind = field[0]
+			}
+			continue
+		}
+
+		switch k := string(key); k {
+		case "kind":
+			set_kind(&rec, string(val))
+		case "language":
+			rec.Language = string(val)
+		case "access":
+			rec.Access = string(val)
+		case "line":
+			rec.Line, _ = strconv.Atoi(string(val))
+		case "scope":
+			/* --fields=+Z writes scope:kind:name */
+			if sk, sv, ok := bytes.Cut(val, []byte(":")); ok {
+				rec.Scope_Kind, rec.Scope = string(sk), string(sv)
+			} else {
+				rec.Scope = string(val)
+			}
+		default:
+			/* Otherwise the scope is written as kind:name */
+			if !legacy_other_fields[k] && rec.Scope == "" {
+				rec.Scope_Kind, rec.Scope = k, string(val)
+			}
+		}
+	}
+
+	return rec, true
+}
+
+/* Returns the index just past the address of a tag. */
+func address_end(rest []byte) int {
+	if len(rest) == 0 || (rest[0] != '/' && rest[0] != '?') {
+		if n := bytes.Index(rest, []byte(";\"")); n != (-1) {
+			return n
+		}
+		return len(rest)
+	}
+
+	delim := rest[0]
+	for i := 1; i < len(rest); i++ {
+		switch rest[i] {
+		case '\\':
+			i++
+		case delim:
+			return i + 1
+		}
+	}
+	return len(rest)
+}
+
+func set_kind(rec *Tag_Record, kind string) {
+	if len(kind) == 1 {
+		rec.Kind = kind[0]
+	} else {
+		rec.Kind_Long = kind
+	}
+}
@@ -0,0 +1,120 @@
+package scan
+
+import (
+	"testing"
+)
+
+func TestParseLegacyTag(t *testing.T) {
+	tests := []struct {
+		line string
+		want Tag_Record
+	}{
+		{
+			"foo\tfoo.c\t/^int\tfoo(void)\t{$/;\"\tf\tline:12\tlanguage:C",
+			Tag_Record{Name: "foo", Path: "foo.c", Kind: 'f', Line: 12, Language: "C"},
+		},
+		{
+			"bar\tbar.c\t?^\\?bar\\? x;\"\t$?;\"\tv\tfile:",
+			Tag_Record{Name: "bar", Path: "bar.c", Kind: 'v'},
+		},
+		{
+			"baz\tbaz.h\t42;\"\td",
+			Tag_Record{Name: "baz", Path: "baz.h", Kind: 'd'},
+		},
+		{
+			"qux\tqux.c\t42",
+			Tag_Record{Name: "qux", Path: "qux.c"},
+		},
+		{
+			"x\tfoo.cpp\t/^  int x;$/;\"\tm\tclass:Foo::Bar\taccess:private",
+			Tag_Record{Name: "x", Path: "foo.cpp", Kind: 'm', Scope_Kind: "class", Scope: "Foo::Bar", Access: "private"},
+		},
+		{
+			"x\tfoo.cpp\t/^  int x;$/;\"\tkind:member\tscope:class:Foo::Bar\tsignature:(int)",
+			Tag_Record{Name: "x", Path: "foo.cpp", Kind_Long: "member", Scope_Kind: "class", Scope: "Foo::Bar"},
+		},
+		{
+			"y\tfoo.c\t/^y$/;\"\tkind:m\tstruct:s",
+			Tag_Record{Name: "y", Path: "foo.c", Kind: 'm', Scope_Kind: "struct", Scope: "s"},
+		},
+	}
+
+	for _, tt := range tests {
+		got, ok := Parse_Tag([]byte(tt.line))
+		if !ok {
+			t.Errorf("%q wasn't parsed", tt.line)
+		} else if got != tt.want {
+			t.Errorf("%q gave %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseTagRejects(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"\r",
+		"!_TAG_FILE_FORMAT\t2\t/extended format/",
+		"!_TAG_PROGRAM_NAME\tUniversal Ctags\t//",
+		"no tabs at all",
+		"name\tpath only",
+		"\tfoo.c\t1",
+		`{"_type": "ptag", "name": "JSON_OUTPUT_VERSION", "path": "0.0"}`,
+		`{"_type": "tag", "name": `,
+	} {
+		if rec, ok := Parse_Tag([]byte(line)); ok {
+			t.Errorf("%q gave %+v, want nothing", line, rec)
+		}
+	}
+}
+
+func TestAddressEnd(t *testing.T) {
+	tests := []struct {
+		rest string
+		want int
+	}{
+		{"/^a\tb\tc$/;\"\tf", 9},
+		{"/^a\\/b$/;\"\tf", 8},
+		{"?^a\\?b$?;\"\tf", 8},
+		{"123;\"\tf", 3},
+		{"123", 3},
+		{"/unterminated", 13},
+		{"", 0},
+	}
+
+	for _, tt := range tests {
+		if got := address_end([]byte(tt.rest)); got != tt.want {
+			t.Errorf("address_end(%q) = %d, want %d", tt.rest, got, tt.want)
+		}
+	}
+}
+
+func TestParseJSONTag(t *testing.T) {
+	line := `{"_type": "tag", "name": "Foo", "path": "a/b.go", "pattern": "/^type Foo struct {$/", "language": "Go", "line": 7, "kind": "struct", "scope": "pkg", "scopeKind": "package", "access": "public"}`
+	want := Tag_Record{
+		Name: "Foo", Path: "a/b.go", Kind_Long: "struct", Language: "Go",
+		Scope: "pkg", Scope_Kind: "package", Access: "public", Line: 7,
+	}
+
+	if got, ok := Parse_Tag([]byte(line + "\r")); !ok || got != want {
+		t.Errorf("got %+v (%v), want %+v", got, ok, want)
+	}
+}
+
+func TestTagRecordJSON(t *testing.T) {
+	for _, rec := range []Tag_Record{
+		{Name: "main", Path: "main.go", Kind: 'f', Language: "Go", Line: 3},
+		{Name: "Foo", Path: "foo.go", Kind_Long: "struct", Scope: "pkg", Scope_Kind: "package", Access: "public"},
+		{Name: "x", Path: "x.c"},
+	} {
+		got, ok := Parse_Tag(rec.JSON())
+		if !ok || got != rec {
+			t.Errorf("%+v came back as %+v (%v)", rec, got, ok)
+		}
+	}
+
+	/* Only one kind fits, and the letter wins. */
+	rec := Tag_Record{Name: "f", Path: "f.c", Kind: 'f', Kind_Long: "function"}
+	if got, _ := Parse_Tag(rec.JSON()); got.Kind != 'f' || got.Kind_Long != "" {
+		t.Errorf("%+v came back as %+v", rec, got)
+	}
+}
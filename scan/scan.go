@@ -39,6 +39,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strings"
 	"sync"
 	"tag_highlight/api"
 	"tag_highlight/lists"
@@ -67,9 +68,10 @@ const ( // Filetypes
 )
 
 type Bufdata struct {
-	Vimbuf       *lists.Linked_List
+	Vimbuf       *lists.Line_Tree
+	Tokens       *Token_Cache
 	Ignored_Tags [][]byte
-	RawTags      [][]byte
+	Records      []Tag_Record
 	Filename     []byte
 	Lang         []byte
 	Order        []byte
@@ -153,6 +155,19 @@ func (bdata *Bufdata) Scan(ldir string) []Tag {
 	timer1 := util.NewTimer()
 	tv1 := time.Now()
 
+	if bdata.Tokens != nil {
+		/* Held for the whole search so that the tokens can't change under it. */
+		bdata.Tokens.mutex.RLock()
+		defer bdata.Tokens.mutex.RUnlock()
+
+		if len(bdata.Tokens.counts) == 0 {
+			return nil
+		}
+		tags := bdata.scan_tags(bdata.Tokens.contains)
+		timer1.EchoReport("all scan operations")
+		return tags
+	}
+
 	vimbuf := bdata.Vimbuf.Join([]byte("\n"))
 
 	// result := util.TimeRoutine("stripping comments", func(a ...interface{}) interface{} {
@@ -172,7 +187,10 @@ func (bdata *Bufdata) Scan(ldir string) []Tag {
 	toks := tokenize(result, bdata.Id)
 	timer2.EchoReport("tokenizing")
 
-	tags := bdata.scan_tags(toks)
+	if len(toks) == 0 {
+		return nil
+	}
+	tags := bdata.scan_tags(func(name []byte) bool { return inbuf(toks, name) })
 
 	timer1.EchoReport("all scan operations")
 	api.Echo("or -> %.10f", time.Since(tv1).Seconds())
@@ -190,19 +208,22 @@ func dump(fname string, data []byte) {
 
 //========================================================================================
 
-func (bdata *Bufdata) scan_tags(vimbuf [][]byte) []Tag {
-	if bdata.RawTags == nil || vimbuf == nil || len(bdata.RawTags) == 0 || len(vimbuf) == 0 {
+/*
+ * Picks out the tags that are either from this file or appear somewhere in it,
+ * which is what in_buffer is asked. It must be safe to call concurrently.
+ */
+func (bdata *Bufdata) scan_tags(in_buffer func(name []byte) bool) []Tag {
+	if len(bdata.Records) == 0 {
 		return nil
 	}
 
 	var (
-		tagcpy   = make([][]byte, len(bdata.RawTags))
+		records  = bdata.Records
 		nthreads = runtime.NumCPU() * 3
-		quot     = len(bdata.RawTags) / nthreads
+		quot     = len(records) / nthreads
 		tags     = make([][]Tag, nthreads)
 		wg       sync.WaitGroup
 	)
-	copy(tagcpy, bdata.RawTags)
 
 	// tv1 := time.Now()
 	timer := util.NewTimer()
@@ -210,13 +231,13 @@ func (bdata *Bufdata) scan_tags(vimbuf [][]byte) []Tag {
 	for i := 0; i < nthreads; i++ {
 		var num int
 		if i == (nthreads - 1) {
-			num = len(tagcpy) - ((nthreads - 1) * quot)
+			num = len(records) - ((nthreads - 1) * quot)
 		} else {
 			num = quot
 		}
 
 		wg.Add(1)
-		go do_search(&wg, &tags[i], bdata, vimbuf, tagcpy[i*quot:(i*quot)+num], num, i)
+		go do_search(&wg, &tags[i], bdata, in_buffer, records[i*quot:(i*quot)+num], num, i)
 	}
 
 	wg.Wait()
@@ -245,46 +266,25 @@ func (bdata *Bufdata) scan_tags(vimbuf [][]byte) []Tag {
 	return ret
 }
 
-func do_search(wg *sync.WaitGroup, tags *[]Tag, bdata *Bufdata, vimbuf, rawtags [][]byte, num, threadnum int) {
+func do_search(wg *sync.WaitGroup, tags *[]Tag, bdata *Bufdata, in_buffer func([]byte) bool, records []Tag_Record, num, threadnum int) {
 	defer wg.Done()
-	if len(vimbuf) == 0 {
-		return
-	}
-	*tags = make([]Tag, 0, (len(vimbuf)*2)/3)
+	*tags = make([]Tag, 0, (num*2)/3)
 
 	for i := 0; i < num; i++ {
-		// if rawtags == nil || rawtags[i] == nil || rawtags[i][0] == '!' {
-		if len(rawtags) == 0 || len(rawtags[i]) == 0 || rawtags[i][0] == '!' {
-			continue
-		}
-
 		var (
-			split      [][]byte = bytes.Split(rawtags[i], []byte("\t"))
-			name       []byte   = split[0]
-			match_file []byte   = split[1]
-			match_lang []byte
-			kind       byte
+			rec  = &records[i]
+			kind = rec.Kind
 		)
-		split = split[2:]
-
-		for _, tok := range split {
-			if len(tok) == 1 {
-				kind = tok[0]
-			} else if bytes.HasPrefix(tok, []byte("language:")) {
-				match_lang = tok[9:]
-			}
-		}
-
-		if kind == 0 || len(match_lang) == 0 {
+		if kind == 0 || len(rec.Language) == 0 {
 			continue
 		}
 
 		if in_order(bdata.Equiv, bdata.Order, &kind) &&
-			is_correct_lang(bdata.Lang, &match_lang) &&
-			!skip_tag(bdata.Ignored_Tags, name) &&
-			(bytes.Equal(bdata.Filename, match_file) ||
-				inbuf(vimbuf, name)) {
-			*tags = append(*tags, Tag{name, kind})
+			is_correct_lang(bdata.Lang, rec.Language) &&
+			!skip_tag(bdata.Ignored_Tags, rec.Name) &&
+			(string(bdata.Filename) == rec.Path ||
+				in_buffer([]byte(rec.Name))) {
+			*tags = append(*tags, Tag{[]byte(rec.Name), kind})
 		}
 
 		// rej := func(s string) {
@@ -331,24 +331,20 @@ func in_order(equiv map[rune]rune, order []byte, kind *byte) bool {
 	return (bytes.IndexByte(order, *kind) != (-1))
 }
 
-func is_correct_lang(lang []byte, match *[]byte) bool {
-	if (*match)[len(*match)-1] == '\r' {
-		*match = (*match)[:len(*match)-1]
-	}
-
-	*match = bytes.ToLower(*match)
+func is_correct_lang(lang []byte, match string) bool {
+	match = strings.ToLower(match)
 
-	if bytes.Equal(lang, *match) {
+	if string(lang) == match {
 		return true
 	}
 
-	return (is_c && (bytes.Equal(*match, []byte("c")) || bytes.Equal(*match, []byte("c++"))))
+	return (is_c && (match == "c" || match == "c++"))
 }
 
-func skip_tag(skip [][]byte, find []byte) bool {
+func skip_tag(skip [][]byte, find string) bool {
 	if skip != nil && len(skip) != 0 {
 		for _, s := range skip {
-			if bytes.Equal(s, find) {
+			if string(s) == find {
 				return true
 			}
 		}
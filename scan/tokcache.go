@@ -0,0 +1,196 @@
+package scan
+
+import (
+	"sort"
+	"sync"
+)
+
+type line_tokens struct {
+	text  string
+	toks  []string // Unique
+	entry int      // Lexer state at the start of the line
+	exit  int      // ... and at the end of it
+}
+
+/*
+ * A Token_Cache keeps the identifiers of every line of a buffer, and a count
+ * for the whole buffer of how many lines each one appears in. It is kept up to
+ * date from nvim_buf_lines_event, so that finding out whether a tag appears in
+ * the buffer never needs the whole thing to be joined, stripped and tokenized
+ * again: only the lines that changed are re-lexed (plus any after them whose
+ * starting state changed, as when a `/*` is typed).
+ */
+type Token_Cache struct {
+	mutex  sync.RWMutex
+	id     int
+	check  cmp_f
+	lines  []line_tokens
+	counts map[string]int
+}
+
+//========================================================================================
+
+func New_Token_Cache(id int) *Token_Cache {
+	tc := &Token_Cache{
+		id:     id,
+		check:  lex_check(id),
+		counts: make(map[string]int, 8192),
+	}
+	tc.replace(0, 0, []string{""})
+	return tc
+}
+
+// Reset replaces the whole contents of the cache.
+func (tc *Token_Cache) Reset(lines []string) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+	tc.replace(0, len(tc.lines), lines)
+}
+
+/*
+ * Replace replaces lines [first, last) with the given ones, exactly as an
+ * nvim_buf_lines_event describes a change. A negative last means the end of
+ * the buffer. As with the buffer itself there is always at least one line.
+ */
+func (tc *Token_Cache) Replace(first, last int, lines []string) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+	tc.replace(first, last, lines)
+}
+
+func (tc *Token_Cache) replace(first, last int, lines []string) {
+	if last < 0 || last > len(tc.lines) {
+		last = len(tc.lines)
+	}
+	if first < 0 {
+		first = 0
+	}
+	if first > last {
+		first = last
+	}
+
+	for i := first; i < last; i++ {
+		tc.release(tc.lines[i].toks)
+	}
+
+	repl := make([]line_tokens, len(lines))
+	for i := range lines {
+		repl[i].text = lines[i]
+		repl[i].entry = (-1)
+	}
+	tail := append(repl, tc.lines[last:]...)
+	tc.lines = append(tc.lines[:first], tail...)
+
+	if len(tc.lines) == 0 {
+		tc.lines = append(tc.lines, line_tokens{entry: (-1)})
+		first = 0
+	}
+
+	tc.relex(first, first+len(lines))
+}
+
+/*
+ * New lines have an entry state of -1, so they're always lexed. After them we
+ * carry on only as long as the state a line starts in is different from what
+ * it was when it was last lexed.
+ */
+func (tc *Token_Cache) relex(first, end int) {
+	state := in_code
+	if first > 0 {
+		state = tc.lines[first-1].exit
+	}
+
+	for i := first; i < len(tc.lines); i++ {
+		ent := &tc.lines[i]
+		if i >= end && ent.entry == state {
+			break
+		}
+		if ent.entry != (-1) {
+			tc.release(ent.toks)
+		}
+
+		ent.entry = state
+		ent.toks, ent.exit = tc.lex(ent.text, state)
+		tc.acquire(ent.toks)
+		state = ent.exit
+	}
+}
+
+func (tc *Token_Cache) lex(text string, state int) ([]string, int) {
+	var (
+		line = []byte(text)
+		toks = make([]string, 0, 16)
+	)
+
+	state = lex_line(tc.id, line, state, tc.check, func(start, end int) {
+		toks = append(toks, string(line[start:end]))
+		if tc.id == FT_VIM {
+			/* Same as tokenize_vim. */
+			for i := start; i < end; i++ {
+				if line[i] == ':' && i > start && i+1 < end {
+					toks = append(toks, string(line[i+1:end]))
+					break
+				}
+			}
+		}
+	})
+
+	if len(toks) > 1 {
+		sort.Strings(toks)
+		n := 1
+		for i := 1; i < len(toks); i++ {
+			if toks[i] != toks[n-1] {
+				toks[n] = toks[i]
+				n++
+			}
+		}
+		toks = toks[:n]
+	}
+
+	return toks, state
+}
+
+func (tc *Token_Cache) acquire(toks []string) {
+	for _, tok := range toks {
+		tc.counts[tok]++
+	}
+}
+
+func (tc *Token_Cache) release(toks []string) {
+	for _, tok := range toks {
+		if tc.counts[tok] <= 1 {
+			delete(tc.counts, tok)
+		} else {
+			tc.counts[tok]--
+		}
+	}
+}
+
+//========================================================================================
+
+// Contains reports whether name appears anywhere in the buffer outside of
+// comments.
+func (tc *Token_Cache) Contains(name []byte) bool {
+	tc.mutex.RLock()
+	defer tc.mutex.RUnlock()
+	return tc.contains(name)
+}
+
+func (tc *Token_Cache) contains(name []byte) bool {
+	return tc.counts[string(name)] > 0
+}
+
+// Len returns the number of distinct tokens in the buffer.
+func (tc *Token_Cache) Len() int {
+	tc.mutex.RLock()
+	defer tc.mutex.RUnlock()
+	return len(tc.counts)
+}
+
+// Lines returns the number of lines the cache holds, which should always be
+// the same as the number of lines in the buffer.
+func (tc *Token_Cache) Lines() int {
+	tc.mutex.RLock()
+	defer tc.mutex.RUnlock()
+	return len(tc.lines)
+}
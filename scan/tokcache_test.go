@@ -0,0 +1,136 @@
+package scan
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+/* The cache must always be just as if it had been built from scratch. */
+func check_token_cache(t *testing.T, tc *Token_Cache, lines []string) {
+	t.Helper()
+	fresh := New_Token_Cache(tc.id)
+	fresh.Reset(lines)
+
+	if len(tc.lines) != len(fresh.lines) {
+		t.Fatalf("cache has %d lines, want %d", len(tc.lines), len(fresh.lines))
+	}
+	for i := range tc.lines {
+		got, want := tc.lines[i], fresh.lines[i]
+		if got.text != want.text || got.entry != want.entry || got.exit != want.exit ||
+			!reflect.DeepEqual(got.toks, want.toks) {
+			t.Fatalf("line %d is %+v, want %+v", i, got, want)
+		}
+	}
+	if !reflect.DeepEqual(tc.counts, fresh.counts) {
+		t.Fatalf("counts are %v, want %v", tc.counts, fresh.counts)
+	}
+}
+
+func expect_tokens(t *testing.T, tc *Token_Cache, have, lack []string) {
+	t.Helper()
+	for _, tok := range have {
+		if !tc.Contains([]byte(tok)) {
+			t.Errorf("'%s' should be in the buffer", tok)
+		}
+	}
+	for _, tok := range lack {
+		if tc.Contains([]byte(tok)) {
+			t.Errorf("'%s' shouldn't be in the buffer", tok)
+		}
+	}
+}
+
+func TestTokenCacheComment(t *testing.T) {
+	lines := []string{"int alpha;", "int beta;", "int gamma;", "int delta;"}
+	tc := New_Token_Cache(FT_C)
+	tc.Reset(lines)
+	expect_tokens(t, tc, []string{"alpha", "beta", "gamma", "delta"}, nil)
+
+	/* Opening a comment hides everything after it... */
+	lines[1] = "int beta; /* open"
+	tc.Replace(1, 2, lines[1:2])
+	check_token_cache(t, tc, lines)
+	expect_tokens(t, tc, []string{"alpha", "beta", "int"}, []string{"gamma", "delta", "open"})
+
+	/* ...up to where it's closed... */
+	lines[3] = "int delta; */ int epsilon;"
+	tc.Replace(3, 4, lines[3:4])
+	check_token_cache(t, tc, lines)
+	expect_tokens(t, tc, []string{"epsilon"}, []string{"gamma", "delta"})
+
+	/* ...and closing it on the same line brings the rest back. */
+	lines[1] = "int beta; /* closed */"
+	tc.Replace(1, 2, lines[1:2])
+	check_token_cache(t, tc, lines)
+	expect_tokens(t, tc, []string{"gamma", "delta", "epsilon"}, []string{"closed"})
+
+	/* As does deleting the line that opened it. */
+	lines[1] = "/* again"
+	tc.Replace(1, 2, lines[1:2])
+	expect_tokens(t, tc, []string{"epsilon"}, []string{"gamma", "again"})
+	lines = append(lines[:1], lines[2:]...)
+	tc.Replace(1, 2, nil)
+	check_token_cache(t, tc, lines)
+	expect_tokens(t, tc, []string{"gamma", "delta", "epsilon"}, []string{"again"})
+}
+
+func TestTokenCacheIfZero(t *testing.T) {
+	lines := []string{"int a;", "#if 0", "int hidden;", "#if 1", "int nested;", "#endif", "int still;", "#endif", "int shown;"}
+	tc := New_Token_Cache(FT_C)
+	tc.Reset(lines)
+	expect_tokens(t, tc, []string{"a", "shown"}, []string{"hidden", "nested", "still"})
+
+	lines[1] = "#if 1"
+	tc.Replace(1, 2, lines[1:2])
+	check_token_cache(t, tc, lines)
+	expect_tokens(t, tc, []string{"hidden", "nested", "still", "shown"}, nil)
+}
+
+func TestTokenCacheRawString(t *testing.T) {
+	lines := []string{"var s = `raw", "notcode", "more` + other", "func main() {}"}
+	tc := New_Token_Cache(FT_GO)
+	tc.Reset(lines)
+	expect_tokens(t, tc, []string{"other", "main"}, []string{"raw", "notcode", "more"})
+
+	/* The old closing quote now opens a raw string of its own. */
+	lines[0] = "var s = \"cooked\""
+	tc.Replace(0, 1, lines[0:1])
+	check_token_cache(t, tc, lines)
+	expect_tokens(t, tc, []string{"notcode", "more"}, []string{"cooked", "other", "main"})
+
+	lines[2] = "more + other"
+	tc.Replace(2, 3, lines[2:3])
+	check_token_cache(t, tc, lines)
+	expect_tokens(t, tc, []string{"notcode", "more", "other", "main"}, []string{"cooked"})
+}
+
+/* Random edits with lines that change the state of those after them. */
+func TestTokenCacheRandom(t *testing.T) {
+	pool := []string{
+		"int a;", "int b = c;", "/* x", "y */ z", "/* w */ v", "// u \\", "t // s",
+		"#if 0", "#endif", "#if 1", "char *q = \"/* p\";", "", "r /* o */ /* n",
+	}
+	rng := rand.New(rand.NewSource(1))
+	tc := New_Token_Cache(FT_C)
+	lines := []string{""}
+
+	for i := 0; i < 3000; i++ {
+		first := rng.Intn(len(lines) + 1)
+		last := first + rng.Intn(len(lines)-first+1)
+		if first == len(lines) {
+			last = first
+		}
+		repl := make([]string, rng.Intn(4))
+		for x := range repl {
+			repl[x] = pool[rng.Intn(len(pool))]
+		}
+
+		tc.Replace(first, last, repl)
+		lines = append(append(append([]string{}, lines[:first]...), repl...), lines[last:]...)
+		if len(lines) == 0 {
+			lines = []string{""}
+		}
+		check_token_cache(t, tc, lines)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"tag_highlight/api"
+	"tag_highlight/util"
+)
+
+/*
+ * Headers named with angle brackets are looked for where the compiler would
+ * look for them, so that the types from libc and installed libraries get
+ * highlighted too. The compiler's search path is asked for once per session.
+ *
+ * System headers include a great many others, so they're only followed
+ * Settings.Sys_header_depth levels deep, and no more than
+ * Settings.Sys_header_max_size bytes of them are handed to ctags in total. The
+ * depth is 3 unless it's set, and setting it to 0 turns all this off.
+ */
+
+const (
+	default_sys_header_depth    = 3
+	default_sys_header_max_size = 8 << 20
+)
+
+type include struct {
+	File  string `json:"file"`
+	Angle bool   `json:"angle"` // #include <file>
+}
+
+var (
+	sys_dirs = struct {
+		sync.Mutex
+		lst map[bool][]string // Keyed by whether it's for C++
+	}{lst: make(map[bool][]string)}
+)
+
+//========================================================================================
+
+func sys_header_max_size() int64 {
+	if Settings.Sys_header_max_size > 0 {
+		return int64(Settings.Sys_header_max_size)
+	}
+	return default_sys_header_max_size
+}
+
+/*
+ * Returns the directories `cc -E -v` says it searches for #include <...>. $CC
+ * (or $CXX for C++) is used instead of cc/c++ if set.
+ */
+func system_include_dirs(cxx bool) []string {
+	sys_dirs.Lock()
+	defer sys_dirs.Unlock()
+
+	if dirs, ok := sys_dirs.lst[cxx]; ok {
+		return dirs
+	}
+
+	var (
+		compiler = os.Getenv("CC")
+		lang     = "c"
+	)
+	if cxx {
+		compiler, lang = os.Getenv("CXX"), "c++"
+		if compiler == "" {
+			compiler = "c++"
+		}
+	} else if compiler == "" {
+		compiler = "cc"
+	}
+
+	argv := strings.Fields(compiler)
+	argv = append(argv, "-E", "-v", "-x", lang, "/dev/null")
+	out, err := exec.Command(argv[0], argv[1:]...).CombinedOutput()
+
+	var dirs []string
+	if err != nil {
+		util.Warn("Failed to get the include path from '%s': %s", compiler, err)
+	} else {
+		dirs = parse_search_list(out)
+		api.Echo("System include directories: %v", dirs)
+	}
+
+	sys_dirs.lst[cxx] = dirs
+	return dirs
+}
+
+/*
+ * The search list is printed as
+ *     #include <...> search starts here:
+ *      /usr/include
+ *      /Library/Frameworks (framework directory)
+ *     End of search list.
+ */
+func parse_search_list(out []byte) []string {
+	var (
+		dirs    []string
+		in_list = false
+	)
+
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		str := strings.TrimSpace(string(line))
+		switch {
+		case strings.HasPrefix(str, "#include <...> search starts here"):
+			in_list = true
+		case strings.HasPrefix(str, "End of search list"):
+			in_list = false
+		case in_list && str != "":
+			str = strings.TrimSuffix(str, " (framework directory)")
+			dirs = append(dirs, filepath.Clean(str))
+		}
+	}
+
+	return dirs
+}
+
+//========================================================================================
+
+/*
+ * Resolves angle bracket includes against the system include path, and
+ * follows what those headers include in turn, breadth first so that the cap on
+ * their total size cuts off the most distant ones.
+ */
+func find_system_headers(includes []string, cxx bool) []string {
+	depth := Settings.Sys_header_depth
+	if len(includes) == 0 || depth <= 0 {
+		return nil
+	}
+	dirs := system_include_dirs(cxx)
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	var (
+		ret    = make([]string, 0, 64)
+		seen   = make(map[string]bool, 64)
+		budget = sys_header_max_size()
+		level  = make([]include, len(includes))
+		from   = make([]string, len(includes)) // Directory of the includer
+	)
+	for i := range includes {
+		level[i] = include{includes[i], true}
+	}
+
+	for lvl := 0; lvl < depth && len(level) > 0; lvl++ {
+		var (
+			next      = make([]include, 0, len(level)*4)
+			next_from = make([]string, 0, len(level)*4)
+		)
+
+		for i, inc := range level {
+			path := resolve_system_include(inc, from[i], dirs)
+			if path == "" || seen[path] {
+				continue
+			}
+			seen[path] = true
+
+			hdr := header_includes(path)
+			if hdr == nil || hdr.Size > budget {
+				continue
+			}
+			budget -= hdr.Size
+			ret = append(ret, path)
+
+			for _, sub := range hdr.Includes {
+				next = append(next, sub)
+				next_from = append(next_from, filepath.Dir(path))
+			}
+		}
+
+		level, from = next, next_from
+	}
+
+	return ret
+}
+
+/* A quoted include is looked for next to the header including it first. */
+func resolve_system_include(inc include, from string, dirs []string) string {
+	if !inc.Angle && from != "" {
+		path := filepath.Join(from, inc.File)
+		if st, err := os.Stat(path); err == nil && !st.IsDir() {
+			return path
+		}
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, inc.File)
+		if st, err := os.Stat(path); err == nil && !st.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSearchList(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want []string
+	}{
+		{
+			"gcc",
+			`Using built-in specs.
+COLLECT_GCC=c++
+Target: x86_64-linux-gnu
+ignoring nonexistent directory "/usr/local/include/x86_64-linux-gnu"
+ignoring duplicate directory "/usr/include/x86_64-linux-gnu/c++/12"
+#include "..." search starts here:
+ /home/me/quoted
+#include <...> search starts here:
+ /usr/include/c++/12
+ /usr/lib/gcc/x86_64-linux-gnu/12/../../../../include/c++/12/backward
+ /usr/local/include
+ /usr/include
+End of search list.
+COMPILER_PATH=/usr/lib/gcc/x86_64-linux-gnu/12/
+`,
+			[]string{"/usr/include/c++/12", "/usr/include/c++/12/backward", "/usr/local/include", "/usr/include"},
+		},
+		{
+			"clang",
+			"Apple clang version 14.0.0 (clang-1400.0.29.202)\r\n" +
+				"Target: x86_64-apple-darwin21.6.0\r\n" +
+				"#include \"...\" search starts here:\r\n" +
+				"#include <...> search starts here:\r\n" +
+				" /usr/local/include\r\n" +
+				" /Library/Developer/CommandLineTools/usr/lib/clang/14.0.0/include\r\n" +
+				" /Library/Developer/CommandLineTools/SDKs/MacOSX.sdk/usr/include\r\n" +
+				" /Library/Developer/CommandLineTools/SDKs/MacOSX.sdk/System/Library/Frameworks (framework directory)\r\n" +
+				"End of search list.\r\n",
+			[]string{
+				"/usr/local/include",
+				"/Library/Developer/CommandLineTools/usr/lib/clang/14.0.0/include",
+				"/Library/Developer/CommandLineTools/SDKs/MacOSX.sdk/usr/include",
+				"/Library/Developer/CommandLineTools/SDKs/MacOSX.sdk/System/Library/Frameworks",
+			},
+		},
+		{"no list", "cc: error: unrecognized command-line option '-E'\n", nil},
+	}
+
+	for _, tt := range tests {
+		if got := parse_search_list([]byte(tt.out)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSysHeaderDepthZero(t *testing.T) {
+	old_settings := Settings
+	Settings.Sys_header_depth = 0
+	defer func() { Settings = old_settings }()
+
+	if got := find_system_headers([]string{"stdio.h"}, false); got != nil {
+		t.Errorf("followed system headers with a depth of 0: %q", got)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os/exec"
+	"strings"
+	"sync"
+	"tag_highlight/api"
+	"tag_highlight/archive"
+)
+
+/*
+ * Every tag cache (the Gzfile) records what it was made with: the ctags
+ * version, a hash of every setting that changes which tags come out, and the
+ * mtime and size of each file that was tagged. A cache made some other way is
+ * thrown away and the tags generated afresh. Recursively tagged projects load
+ * their database from it instead, so only the files that changed since are
+ * tagged again.
+ */
+
+var errStaleCache = errors.New("tag cache is out of date")
+
+var ctags_version_info struct {
+	once    sync.Once
+	version string
+}
+
+//========================================================================================
+
+/* The first line of `ctags --version`, or nothing if there is no ctags. */
+func ctags_version() string {
+	ctags_version_info.once.Do(func() {
+		if out, err := exec.Command("ctags", "--version").Output(); err == nil {
+			line, _, _ := strings.Cut(string(out), "\n")
+			ctags_version_info.version = strings.TrimSpace(line)
+		}
+	})
+	return ctags_version_info.version
+}
+
+func (topdir *TopDir) tag_config_hash() string {
+	var (
+		hash    = fnv.New64a()
+		sources = make([]string, 0, 4)
+	)
+	for _, src := range topdir.get_sources(topdir.ft) {
+		sources = append(sources, src.Name())
+	}
+
+	fmt.Fprintf(hash, "%q %q %v %q %q %v %d %d",
+		Settings.Ctags_args, ctags_json_fields, ctags_has_json(), sources,
+		Settings.Static_tags, Settings.Go_deps, Settings.Sys_header_depth,
+		Settings.Sys_header_max_size)
+	return fmt.Sprintf("%016x", hash.Sum64())
+}
+
+/*
+ * The files of a project with a database are all those in it. Otherwise it's
+ * just those that have tags, which is the file and its headers.
+ */
+func (topdir *TopDir) cache_header() *archive.Header {
+	hdr := &archive.Header{
+		Ctags_Version: ctags_version(),
+		Config_Hash:   topdir.tag_config_hash(),
+	}
+
+	if topdir.DB.populated() {
+		hdr.Files = topdir.DB.stamps()
+	} else {
+		tmp_mutex.Lock()
+		records := topdir.Records
+		tmp_mutex.Unlock()
+
+		hdr.Files = make(map[string]archive.File_Stamp, 16)
+		for i := range records {
+			path := records[i].Path
+			if _, ok := hdr.Files[path]; ok {
+				continue
+			}
+			if stamp, ok := stat_stamp(path); ok {
+				hdr.Files[path] = archive.File_Stamp{Mtime: stamp.mtime, Size: stamp.size}
+			}
+		}
+	}
+
+	return hdr
+}
+
+/*
+ * Without a database every file has to be as it was. With one, changed files
+ * are simply tagged again afterwards.
+ */
+func (topdir *TopDir) check_cache_header(hdr *archive.Header, use_db bool) error {
+	if v := ctags_version(); hdr.Ctags_Version != v {
+		return fmt.Errorf("%w: made by '%s', not '%s'", errStaleCache, hdr.Ctags_Version, v)
+	}
+	if h := topdir.tag_config_hash(); hdr.Config_Hash != h {
+		return fmt.Errorf("%w: settings have changed", errStaleCache)
+	}
+	if use_db {
+		return nil
+	}
+
+	for path, want := range hdr.Files {
+		if stamp, ok := stat_stamp(path); !ok || stamp != (file_stamp{want.Mtime, want.Size}) {
+			return fmt.Errorf("%w: '%s' has changed", errStaleCache, path)
+		}
+	}
+	return nil
+}
+
+/* After loading a cache into the database, tag whatever changed since. */
+func (bdata *Bufdata) refresh_stale_tags() {
+	topdir := bdata.Topdir
+	if !topdir.DB.populated() {
+		return
+	}
+	if stale := topdir.DB.changed(project_file_stamps(topdir.Pathname)); len(stale) == 0 {
+		return
+	}
+
+	api.Echo("Files changed since '%s' was written, retagging them", topdir.Gzfile)
+	if bdata.update_tags() && !topdir.Write_Gzfile(int(Settings.Comp_type)) {
+		api.Echo("Error writing gzfile")
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"tag_highlight/archive"
+	"tag_highlight/scan"
+)
+
+/*
+ * The tags of a recursively tagged project, kept per source file along with the
+ * mtime and size each file had when it was tagged. Once it's been filled by a
+ * full run, an update only has to stat the project's files and hand the ones
+ * that changed (plus the one just written) to ctags, rather than running
+ * ctags -R over the whole thing again.
+ *
+ * Only tags from sources that can tag single files (File_Tagger) go in here.
+ * Those from the others (tag files, mostly) are kept in TopDir.extra and
+ * appended when the lot is put together.
+ */
+type tag_db struct {
+	mutex sync.Mutex
+	files map[string]*tag_db_file
+}
+
+type tag_db_file struct {
+	stamp file_stamp
+	tags  []scan.Tag_Record
+}
+
+type file_stamp struct {
+	mtime int64 // ns
+	size  int64
+}
+
+//========================================================================================
+
+func stat_stamp(path string) (file_stamp, bool) {
+	st, err := os.Stat(path)
+	if err != nil || !st.Mode().IsRegular() {
+		return file_stamp{}, false
+	}
+	return file_stamp{st.ModTime().UnixNano(), st.Size()}, true
+}
+
+/* The same directories are skipped as when watching the project. */
+func project_skip_dir(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), ".") || !check_norecurse_directories(path)
+}
+
+/* Stats every file in the project. */
+func project_file_stamps(root string) map[string]file_stamp {
+	stamps := make(map[string]file_stamp, 1024)
+
+	filepath.WalkDir(root, func(path string, ent os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ent.IsDir() {
+			if path != root && project_skip_dir(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !ent.Type().IsRegular() {
+			return nil
+		}
+		if info, err := ent.Info(); err == nil {
+			stamps[path] = file_stamp{info.ModTime().UnixNano(), info.Size()}
+		}
+		return nil
+	})
+
+	return stamps
+}
+
+//========================================================================================
+
+func (db *tag_db) populated() bool {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return db.files != nil
+}
+
+func (db *tag_db) clear() {
+	db.mutex.Lock()
+	db.files = nil
+	db.mutex.Unlock()
+}
+
+/*
+ * Replaces the whole database with the result of a full run. Every file in
+ * stamps gets an entry, tags or not, so that it isn't taken to be new next
+ * time. Tags for files outside the project (dependencies and the like) get
+ * entries of their own.
+ */
+func (db *tag_db) rebuild(stamps map[string]file_stamp, records []scan.Tag_Record) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.files = make(map[string]*tag_db_file, len(stamps))
+	for path, stamp := range stamps {
+		db.files[path] = &tag_db_file{stamp: stamp}
+	}
+	db.add_records(records)
+}
+
+/*
+ * Returns the files that were added, changed or removed since they were last
+ * tagged. Files the database knows of that the walk didn't find are looked at
+ * individually, and stamps is filled in for them.
+ */
+func (db *tag_db) changed(stamps map[string]file_stamp) []string {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	ret := make([]string, 0, 16)
+	for path, file := range db.files {
+		stamp, ok := stamps[path]
+		if !ok {
+			if stamp, ok = stat_stamp(path); ok {
+				stamps[path] = stamp
+			}
+		}
+		if !ok || stamp != file.stamp {
+			ret = append(ret, path)
+		}
+	}
+	for path := range stamps {
+		if db.files[path] == nil {
+			ret = append(ret, path)
+		}
+	}
+
+	return ret
+}
+
+/*
+ * Replaces the tags of the given files with records. Files without a stamp no
+ * longer exist and are dropped.
+ */
+func (db *tag_db) splice(files []string, stamps map[string]file_stamp, records []scan.Tag_Record) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if db.files == nil {
+		return
+	}
+
+	for _, path := range files {
+		if stamp, ok := stamps[path]; ok {
+			db.files[path] = &tag_db_file{stamp: stamp}
+		} else {
+			delete(db.files, path)
+		}
+	}
+	db.add_records(records)
+}
+
+func (db *tag_db) add_records(records []scan.Tag_Record) {
+	for i := range records {
+		file := db.files[records[i].Path]
+		if file == nil {
+			stamp, _ := stat_stamp(records[i].Path)
+			file = &tag_db_file{stamp: stamp}
+			db.files[records[i].Path] = file
+		}
+		file.tags = append(file.tags, records[i])
+	}
+}
+
+/*
+ * Whether a file has changed since it was tagged, or has been removed. Without a
+ * database there's nothing to go by, so everything has.
+ */
+func (db *tag_db) stale(path string) bool {
+	stamp, ok := stat_stamp(path)
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if db.files == nil {
+		return true
+	}
+	file := db.files[path]
+	if !ok {
+		return file != nil
+	}
+	return file == nil || file.stamp != stamp
+}
+
+/* The files known to be in dir, or anywhere below it. */
+func (db *tag_db) files_under(dir string) []string {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	var (
+		ret    = make([]string, 0, 16)
+		prefix = dir + string(filepath.Separator)
+	)
+	for path := range db.files {
+		if strings.HasPrefix(path, prefix) {
+			ret = append(ret, path)
+		}
+	}
+	return ret
+}
+
+/* All the tags, file by file in order of their names. */
+func (db *tag_db) records() []scan.Tag_Record {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	var (
+		paths = make([]string, 0, len(db.files))
+		n     = 0
+	)
+	for path, file := range db.files {
+		paths = append(paths, path)
+		n += len(file.tags)
+	}
+	sort.Strings(paths)
+
+	ret := make([]scan.Tag_Record, 0, n)
+	for _, path := range paths {
+		ret = append(ret, db.files[path].tags...)
+	}
+	return ret
+}
+
+//========================================================================================
+
+/* The stamps of every file, for the tag cache's header. */
+func (db *tag_db) stamps() map[string]archive.File_Stamp {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	ret := make(map[string]archive.File_Stamp, len(db.files))
+	for path, file := range db.files {
+		ret[path] = archive.File_Stamp{Mtime: file.stamp.mtime, Size: file.stamp.size}
+	}
+	return ret
+}
+
+/*
+ * Fills the database from a tag cache. Records of files the cache has no stamp
+ * for came from other sources, and are returned.
+ */
+func (db *tag_db) load(stamps map[string]archive.File_Stamp, records []scan.Tag_Record) []scan.Tag_Record {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.files = make(map[string]*tag_db_file, len(stamps))
+	for path, stamp := range stamps {
+		db.files[path] = &tag_db_file{stamp: file_stamp{stamp.Mtime, stamp.Size}}
+	}
+
+	extra := make([]scan.Tag_Record, 0)
+	for i := range records {
+		if file := db.files[records[i].Path]; file != nil {
+			file.tags = append(file.tags, records[i])
+		} else {
+			extra = append(extra, records[i])
+		}
+	}
+	return extra
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"tag_highlight/api"
+	"tag_highlight/gotags"
+	"tag_highlight/scan"
+	"tag_highlight/util"
+	"time"
+)
+
+/*
+ * A TagSource is somewhere tags come from. Each project (TopDir) has its own
+ * instance of every source configured for its filetype, and the tags from all
+ * of them are merged. Which sources a filetype uses is set with
+ * g:tag_highlight#settings.tag_sources, a map from filetype to a list of
+ * names; without it a filetype uses its generator (ctags, or the Go tagger for
+ * Go, along with go_deps if that's set), plus the static tag files if there are
+ * any.
+ */
+type TagSource interface {
+	Name() string
+	// Generate returns the tags for the request. It's called after every write.
+	Generate(req *Tag_Request) ([]scan.Tag_Record, error)
+	// Invalidate drops anything cached, for when an update is forced.
+	Invalidate()
+}
+
+/*
+ * Sources that can tag a handful of files on their own, without redoing the
+ * whole project, implement this too. Files that no longer exist should simply
+ * have no tags.
+ */
+type File_Tagger interface {
+	Tag_Files(project *TopDir, ft *Ftdata, files []string) ([]scan.Tag_Record, error)
+}
+
+type Tag_Request struct {
+	Project *TopDir
+	Bdata   *Bufdata
+	Files   []string // The file being edited
+	Force   int
+}
+
+type tag_source_ctor func(ft *Ftdata) TagSource
+
+var tag_source_registry = map[string]tag_source_ctor{
+	"ctags":    func(*Ftdata) TagSource { return &ctags_source{} },
+	"go":       func(*Ftdata) TagSource { return &go_source{} },
+	"go_deps":  func(*Ftdata) TagSource { return &go_deps_source{} },
+	"tagfiles": func(*Ftdata) TagSource { return &tagfiles_source{} },
+	"static":   func(*Ftdata) TagSource { return &static_source{} },
+}
+
+//========================================================================================
+
+// Register_Tag_Source makes a source available under name for tag_sources.
+func Register_Tag_Source(name string, ctor func(ft *Ftdata) TagSource) {
+	tag_source_registry[name] = ctor
+}
+
+func default_tag_sources(ft *Ftdata) []string {
+	ret := []string{"ctags"}
+	if ft.Id == FT_GO && !Settings.Go_use_ctags {
+		ret[0] = "go"
+		if Settings.Go_deps {
+			ret = append(ret, "go_deps")
+		}
+	}
+	if len(Settings.Static_tags) > 0 {
+		ret = append(ret, "static")
+	}
+	return ret
+}
+
+func (topdir *TopDir) get_sources(ft *Ftdata) []TagSource {
+	if topdir.Sources != nil {
+		return topdir.Sources
+	}
+	names, ok := Settings.Tag_sources[ft.Vim_Name]
+	if !ok {
+		names = default_tag_sources(ft)
+	}
+
+	for _, name := range names {
+		if ctor := tag_source_registry[name]; ctor != nil {
+			topdir.Sources = append(topdir.Sources, ctor(ft))
+		} else {
+			util.Warn("Unknown tag source '%s' for filetype %s", name, ft.Vim_Name)
+		}
+	}
+	return topdir.Sources
+}
+
+/*
+ * Generate_Tags asks every source for tags and stores the lot in the TopDir.
+ * It only fails if every source does. Projects tagged recursively keep their
+ * tags in a database by file, and once that's filled only what changed is
+ * tagged again, unless the update is forced.
+ */
+func (bdata *Bufdata) Generate_Tags(force int) bool {
+	var (
+		topdir  = bdata.Topdir
+		recurse = ctags_recurse(bdata, force)
+		stamps  map[string]file_stamp
+	)
+	if recurse && force == 0 && topdir.DB.populated() {
+		return bdata.update_tags()
+	}
+	if recurse {
+		/* Stat'd first, so anything changed during the run is redone next time. */
+		stamps = project_file_stamps(topdir.Pathname)
+	}
+
+	var (
+		sources = topdir.get_sources(bdata.Ft)
+		records = make([]scan.Tag_Record, 0, 4096)
+		extra   = make([]scan.Tag_Record, 0)
+		nfailed = 0
+	)
+	for _, src := range sources {
+		if force != 0 {
+			src.Invalidate()
+		}
+		tags, err := bdata.run_source(src, force)
+		if err != nil {
+			nfailed++
+		} else if _, ok := src.(File_Tagger); ok {
+			records = append(records, tags...)
+		} else {
+			extra = append(extra, tags...)
+		}
+	}
+
+	if stamps != nil {
+		topdir.DB.rebuild(stamps, records)
+	} else {
+		topdir.DB.clear()
+	}
+	topdir.extra = extra
+	topdir.set_records(append(records, extra...))
+	return len(sources) == 0 || nfailed < len(sources)
+}
+
+func (bdata *Bufdata) run_source(src TagSource, force int) ([]scan.Tag_Record, error) {
+	req := &Tag_Request{bdata.Topdir, bdata, []string{bdata.Filename}, force}
+	timer := util.NewTimer()
+	tags, err := src.Generate(req)
+	timer.EchoReport("tag source " + src.Name())
+
+	if err != nil {
+		util.Warn("Tag source '%s' failed: %s", src.Name(), err)
+	}
+	return tags, err
+}
+
+/*
+ * Tags only the files that changed since they were last tagged, along with the
+ * buffer's own, which has just been written. Sources that can't do single files
+ * are run in full, which for the tag file sources costs only a stat.
+ */
+func (bdata *Bufdata) update_tags() bool {
+	var (
+		topdir  = bdata.Topdir
+		stamps  = project_file_stamps(topdir.Pathname)
+		changed = topdir.DB.changed(stamps)
+		extra   = make([]scan.Tag_Record, 0)
+	)
+	/* Unless the watcher already got to it. */
+	if !util.Contains_Str(changed, bdata.Filename) && topdir.DB.stale(bdata.Filename) {
+		changed = append(changed, bdata.Filename)
+	}
+	api.Echo("Retagging %d changed files", len(changed))
+
+	if !topdir.retag(bdata.Ft, changed, stamps) {
+		return false
+	}
+	for _, src := range topdir.get_sources(bdata.Ft) {
+		if _, ok := src.(File_Tagger); !ok {
+			if tags, err := bdata.run_source(src, 0); err == nil {
+				extra = append(extra, tags...)
+			}
+		}
+	}
+
+	topdir.extra = extra
+	topdir.set_records(append(topdir.DB.records(), extra...))
+	return true
+}
+
+/* Tags is kept too, in JSON, as that's what's written to the archive. */
+func (topdir *TopDir) set_records(records []scan.Tag_Record) {
+	tags := make([][]byte, len(records))
+	for i := range records {
+		tags[i] = records[i].JSON()
+	}
+
+	tmp_mutex.Lock()
+	topdir.Records = records
+	topdir.Tags = tags
+	tmp_mutex.Unlock()
+}
+
+/*
+ * Retag_Files replaces the tags of the given files with fresh ones from every
+ * source that can tag single files. Returns false if nothing could.
+ */
+func (topdir *TopDir) Retag_Files(ft *Ftdata, files []string) bool {
+	if topdir.DB.populated() {
+		stamps := make(map[string]file_stamp, len(files))
+		for _, file := range files {
+			if stamp, ok := stat_stamp(file); ok {
+				stamps[file] = stamp
+			}
+		}
+		if !topdir.retag(ft, files, stamps) {
+			return false
+		}
+		topdir.set_records(append(topdir.DB.records(), topdir.extra...))
+		return true
+	}
+
+	records, ok := topdir.tag_files(ft, files)
+	if !ok {
+		return false
+	}
+
+	changed := make(map[string]bool, len(files))
+	for _, file := range files {
+		changed[file] = true
+	}
+
+	tmp_mutex.Lock()
+	old := topdir.Records
+	tmp_mutex.Unlock()
+
+	for i := range old {
+		if !changed[old[i].Path] {
+			records = append(records, old[i])
+		}
+	}
+	topdir.set_records(records)
+	return true
+}
+
+/* Tags the files and puts them in the database. */
+func (topdir *TopDir) retag(ft *Ftdata, files []string, stamps map[string]file_stamp) bool {
+	records, ok := topdir.tag_files(ft, files)
+	if ok {
+		topdir.DB.splice(files, stamps, records)
+	}
+	return ok
+}
+
+func (topdir *TopDir) tag_files(ft *Ftdata, files []string) ([]scan.Tag_Record, bool) {
+	var (
+		records = make([]scan.Tag_Record, 0, 256)
+		ntagged = 0
+	)
+
+	for _, src := range topdir.get_sources(ft) {
+		tagger, ok := src.(File_Tagger)
+		if !ok {
+			continue
+		}
+		tags, err := tagger.Tag_Files(topdir, ft, files)
+		if err != nil {
+			util.Warn("Tag source '%s' failed: %s", src.Name(), err)
+			continue
+		}
+		records = append(records, tags...)
+		ntagged++
+	}
+
+	return records, ntagged > 0
+}
+
+//========================================================================================
+
+/* Runs ctags, interactively if possible, as Run_Ctags always has. */
+type ctags_source struct{}
+
+func (*ctags_source) Name() string { return "ctags" }
+func (*ctags_source) Invalidate()  {}
+
+func (*ctags_source) Generate(req *Tag_Request) ([]scan.Tag_Record, error) {
+	if !req.Bdata.Run_Ctags(req.Force) {
+		return nil, errors.New("ctags failed")
+	}
+	lines, err := req.Project.Read_Tmpfile()
+	if err != nil {
+		return nil, err
+	}
+	return scan.Parse_Tags(lines), nil
+}
+
+func (*ctags_source) Tag_Files(project *TopDir, ft *Ftdata, files []string) ([]scan.Tag_Record, error) {
+	lines, err := ctags_files(project, ft, files)
+	if err != nil {
+		return nil, err
+	}
+	return scan.Parse_Tags(lines), nil
+}
+
+//========================================================================================
+
+/* The Go tagger, for Go. */
+type go_source struct{}
+
+func (*go_source) Name() string { return "go" }
+func (*go_source) Invalidate()  {}
+
+func (*go_source) Generate(req *Tag_Request) ([]scan.Tag_Record, error) {
+	dir, recurse, contents := go_request_files(req)
+	tags := gotags.Tag_Dir(dir, recurse, go_skip_dir, contents)
+	api.Echo("Go tagger found %d tags", len(tags))
+
+	return tags, nil
+}
+
+func (*go_source) Tag_Files(project *TopDir, ft *Ftdata, files []string) ([]scan.Tag_Record, error) {
+	return gotags.Tag_Files(files), nil
+}
+
+/*
+ * The exported identifiers of whatever a Go project imports (with go_deps).
+ * They aren't the tags of any file in the project, so this is deliberately not
+ * a File_Tagger: it's run on every update, and an import added anywhere shows
+ * up straight away. The packages themselves are cached by gotags, and `go
+ * list` is only run again when the set of imports changes.
+ */
+type go_deps_source struct {
+	imports []string
+	tags    []scan.Tag_Record
+}
+
+func (*go_deps_source) Name() string { return "go_deps" }
+
+func (src *go_deps_source) Invalidate() {
+	src.imports = nil
+	src.tags = nil
+}
+
+func (src *go_deps_source) Generate(req *Tag_Request) ([]scan.Tag_Record, error) {
+	dir, recurse, contents := go_request_files(req)
+	imports := gotags.Imports(dir, recurse, go_skip_dir, contents)
+	if src.tags != nil && util.Equal_Str(imports, src.imports) {
+		return src.tags, nil
+	}
+
+	tags, err := gotags.Tag_Imports(filepath.Dir(req.Bdata.Filename), imports)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to tag imported packages: %s", err)
+	}
+	if tags == nil {
+		tags = []scan.Tag_Record{}
+	}
+	api.Echo("Found %d tags in %d imported packages", len(tags), len(imports))
+
+	src.imports, src.tags = imports, tags
+	return tags, nil
+}
+
+/* The directory to tag, and the unsaved contents of the buffer. */
+func go_request_files(req *Tag_Request) (string, bool, map[string][]byte) {
+	var (
+		bdata    = req.Bdata
+		dir      = filepath.Dir(bdata.Filename)
+		recurse  = ctags_recurse(bdata, req.Force)
+		contents = map[string][]byte{bdata.Filename: bdata.Lines.Join([]byte("\n"))}
+	)
+	if recurse {
+		dir = req.Project.Pathname
+	}
+	return dir, recurse, contents
+}
+
+func go_skip_dir(dir string) bool {
+	return !check_norecurse_directories(dir)
+}
+
+//========================================================================================
+
+/*
+ * Tag files that already exist are read as they are, and only read again when
+ * they change. Paths in them are relative to the file's own directory.
+ */
+type tag_file_cache struct {
+	mutex sync.Mutex
+	files map[string]*tag_file
+}
+
+type tag_file struct {
+	mtime time.Time
+	size  int64
+	tags  []scan.Tag_Record
+}
+
+func (cache *tag_file_cache) read(paths []string) ([]scan.Tag_Record, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.files == nil {
+		cache.files = make(map[string]*tag_file)
+	}
+
+	var (
+		ret  = make([]scan.Tag_Record, 0, 4096)
+		errs = make([]error, 0)
+	)
+
+	for _, path := range paths {
+		st, err := os.Stat(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if file := cache.files[path]; file != nil && file.mtime.Equal(st.ModTime()) && file.size == st.Size() {
+			ret = append(ret, file.tags...)
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		tags := scan.Parse_Tags(bytes.Split(data, []byte("\n")))
+		dir := filepath.Dir(path)
+		for i := range tags {
+			if !filepath.IsAbs(tags[i].Path) {
+				tags[i].Path = filepath.Join(dir, tags[i].Path)
+			}
+		}
+
+		cache.files[path] = &tag_file{st.ModTime(), st.Size(), tags}
+		ret = append(ret, tags...)
+	}
+
+	if len(errs) > 0 && len(errs) == len(paths) {
+		return nil, errors.Join(errs...)
+	}
+	return ret, nil
+}
+
+func (cache *tag_file_cache) invalidate() {
+	cache.mutex.Lock()
+	cache.files = nil
+	cache.mutex.Unlock()
+}
+
+//========================================================================================
+
+/* Whatever tag files the buffer's 'tags' option finds, as tagfiles() does. */
+type tagfiles_source struct {
+	cache tag_file_cache
+}
+
+func (*tagfiles_source) Name() string    { return "tagfiles" }
+func (src *tagfiles_source) Invalidate() { src.cache.invalidate() }
+
+func (src *tagfiles_source) Generate(req *Tag_Request) ([]scan.Tag_Record, error) {
+	/* tagfiles() only knows about the current buffer. */
+	expr := fmt.Sprintf("bufnr('%%') == %d ? map(tagfiles(), {_, f -> fnamemodify(f, ':p')}) : []", req.Bdata.Num)
+	obj, err := api.New_Client(0).Nvim_eval(expr)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	if err = obj.Unmarshal(&files); err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		if file != req.Project.Tmpfname {
+			paths = append(paths, file)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	return src.cache.read(paths)
+}
+
+//========================================================================================
+
+/* The tag files listed in g:tag_highlight#settings.static_tags. */
+type static_source struct {
+	cache tag_file_cache
+}
+
+func (*static_source) Name() string    { return "static" }
+func (src *static_source) Invalidate() { src.cache.invalidate() }
+
+func (src *static_source) Generate(req *Tag_Request) ([]scan.Tag_Record, error) {
+	if len(Settings.Static_tags) == 0 {
+		return nil, nil
+	}
+	return src.cache.read(Settings.Static_tags)
+}
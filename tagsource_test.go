@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"tag_highlight/lists"
+	"testing"
+	"time"
+)
+
+func has_tag(topdir *TopDir, name string) bool {
+	for _, rec := range topdir.Records {
+		if rec.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * Tags of imported packages aren't kept in the database, so an import added
+ * after the first full run must show up without forcing an update.
+ */
+func TestGoDepsFollowImports(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go isn't installed")
+	}
+	new_test_fake(t)
+
+	old_settings := Settings
+	Settings.Go_deps = true
+	Settings.Go_use_ctags = false
+	Settings.Tag_sources = nil
+	defer func() { Settings = old_settings }()
+
+	var (
+		dir   = t.TempDir()
+		fname = filepath.Join(dir, "main.go")
+		ft    = *id_filetype("go")
+	)
+	write := func(src string, mtime time.Time) {
+		if err := os.WriteFile(fname, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(fname, mtime, mtime)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/deps\n\ngo 1.18\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := "package main\n\nimport \"strings\"\n\nvar _ strings.Builder\n\nfunc main() {}\n"
+	write(src, time.Now().Add(-time.Hour))
+
+	bdata := &Bufdata{
+		Filename: fname,
+		Ft:       &ft,
+		Lines:    lists.New_Line_Tree(""),
+		Topdir:   &TopDir{Id: ft.Id, ft: &ft, Pathname: dir, Recurse: true},
+	}
+	bdata.Lines.Replace(0, -1, strings.Split(src, "\n"))
+
+	if !bdata.Generate_Tags(0) {
+		t.Fatal("Generate_Tags failed")
+	}
+	if !bdata.Topdir.DB.populated() {
+		t.Fatal("the database wasn't filled by the full run")
+	}
+	if !has_tag(bdata.Topdir, "main") || !has_tag(bdata.Topdir, "Builder") {
+		t.Fatal("missing the project's or strings' tags after the full run")
+	}
+	if has_tag(bdata.Topdir, "NewBuffer") {
+		t.Fatal("bytes was tagged before it was imported")
+	}
+
+	src = strings.Replace(src, "import \"strings\"", "import (\n\t\"bytes\"\n\t\"strings\"\n)\n\nvar _ bytes.Buffer", 1)
+	write(src, time.Now())
+	bdata.Lines.Replace(0, -1, strings.Split(src, "\n"))
+
+	if !bdata.Generate_Tags(0) {
+		t.Fatal("Generate_Tags failed")
+	}
+	if !has_tag(bdata.Topdir, "NewBuffer") {
+		t.Error("the newly imported package wasn't tagged by an update")
+	}
+	if !has_tag(bdata.Topdir, "Builder") || !has_tag(bdata.Topdir, "main") {
+		t.Error("tags were lost in the update")
+	}
+}
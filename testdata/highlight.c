@@ -0,0 +1,20 @@
+#include <stdlib.h>
+
+struct point {
+	int x, y;
+};
+
+static struct point *make_point(int x, int y)
+{
+	struct point *p = malloc(sizeof *p);
+	p->x = x;
+	p->y = y;
+	return p;
+}
+
+int main(void)
+{
+	struct point *p = make_point(1, 2);
+	free(p);
+	return 0;
+}
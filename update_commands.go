@@ -24,9 +24,12 @@ func (bdata *Bufdata) Update_Highlight() {
 	api.Echo("Updating highlight commands for bufnum %d", bdata.Num)
 	timer := util.NewTimer()
 
-	if !bdata.Ft.Restore_Cmds_Init {
-		tmp := api.Nvim_get_var(0, []byte("tag_highlight#restored_groups"), mpack.E_MAP_STR_BYTELIST).(map[string][][]byte)
-		restored_groups := tmp[bdata.Ft.Vim_Name]
+	if !bdata.Ft.Restore_Cmds_Init && !use_extmarks() {
+		var groups map[string][][]byte
+		if err := api.Nvim_get_var_into(0, []byte("tag_highlight#restored_groups"), &groups); err != nil {
+			util.Eprintf("Failed to get restored groups: %s\n", err)
+		}
+		restored_groups := groups[bdata.Ft.Vim_Name]
 		if restored_groups != nil {
 			bdata.Ft.Restore_Cmds = get_restore_cmds(restored_groups)
 			// api.Echo("%s", bdata.Ft.Restore_Cmds)
@@ -41,6 +44,7 @@ func (bdata *Bufdata) Update_Highlight() {
 
 	scanner := bdata.Make_Scan_Struct()
 	tags := scanner.Scan(logdir)
+	bdata.Highlighted = tags
 
 	if util.Logfiles["taglst"] != nil {
 		for i, t := range tags {
@@ -52,12 +56,27 @@ func (bdata *Bufdata) Update_Highlight() {
 	if tags != nil {
 		api.Echo("Found %d total tags", len(tags))
 		// bdata.update_commands(tags)
-		bdata.update_commands(tags)
-		api.Nvim_call_atomic(0, bdata.Calls)
+		if use_extmarks() {
+			if err := bdata.update_extmarks(tags); err != nil {
+				util.Eprintf("Failed to place highlights: %s\n", err)
+				return
+			}
+		} else {
+			bdata.update_commands(tags)
+		}
+		if err := api.Nvim_call_atomic(0, bdata.Calls); err != nil {
+			util.Eprintf("Failed to apply highlight commands: %s\n", err)
+		}
 
-		if bdata.Ft.Restore_Cmds != nil {
+		if bdata.Ft.Restore_Cmds != nil && !use_extmarks() {
 			util.Logfiles["cmds"].Write(bdata.Ft.Restore_Cmds)
-			api.Nvim_command(0, bdata.Ft.Restore_Cmds)
+			if err := api.Nvim_command(0, bdata.Ft.Restore_Cmds); err != nil {
+				util.Eprintf("%s\n", err)
+			}
+		}
+	} else if use_extmarks() {
+		if err := bdata.Clear_Highlight(); err != nil {
+			util.Eprintf("%s\n", err)
 		}
 	}
 	timer.EchoReport("update highlight")
@@ -68,7 +87,8 @@ func (bdata *Bufdata) Update_Highlight() {
 func (bdata *Bufdata) Make_Scan_Struct() *scan.Bufdata {
 	return &scan.Bufdata{
 		Vimbuf:       bdata.Lines,
-		RawTags:      bdata.Topdir.Tags,
+		Tokens:       bdata.Tokens,
+		Records:      bdata.Topdir.Records,
 		Ignored_Tags: bdata.Ft.Ignored_Tags,
 		Equiv:        bdata.Ft.Equiv,
 		Order:        bdata.Ft.Order,
@@ -88,8 +108,11 @@ func get_restore_cmds(restored_groups [][]byte) []byte {
 		cmd := make([]byte, 0, 128)
 		append_all(&cmd, []byte("syntax list "), group)
 
-		output := api.Nvim_command_output(0, cmd, mpack.E_BYTES)
-
+		output, err := api.Nvim_command_output(0, cmd, mpack.E_BYTES)
+		if err != nil {
+			util.Eprintf("%s\n", err)
+			continue
+		}
 		if output == nil {
 			continue
 		}
@@ -167,18 +190,34 @@ type cmd_info struct {
 	kind   byte
 }
 
-func (bdata *Bufdata) update_commands(tags []scan.Tag) {
+/*
+ * The highlight group (and for the syntax backend, optional match prefix and
+ * suffix) for each kind is kept in g:tag_highlight#<ft>#<kind>.
+ */
+func (bdata *Bufdata) get_cmd_info() []cmd_info {
 	ngroups := len(bdata.Ft.Order)
 	info := make([]cmd_info, ngroups)
 
 	for i := 0; i < ngroups; i++ {
 		ch := bdata.Ft.Order[i]
-		tmp := api.Nvim_get_var_fmt(0, mpack.E_MAP_STR_BYTES, "%s#%s#%c",
-			"tag_highlight", bdata.Ft.Vim_Name, ch).(map[string][]byte)
+		var (
+			tmp     map[string][]byte
+			varname = fmt.Sprintf("tag_highlight#%s#%c", bdata.Ft.Vim_Name, ch)
+		)
+		if err := api.Nvim_get_var_into(0, []byte(varname), &tmp); err != nil {
+			util.Eprintf("Failed to get highlight info for kind '%c': %s\n", ch, err)
+		}
 
 		info[i] = cmd_info{tmp["group"], tmp["prefix"], tmp["suffix"], ch}
 	}
 
+	return info
+}
+
+func (bdata *Bufdata) update_commands(tags []scan.Tag) {
+	ngroups := len(bdata.Ft.Order)
+	info := bdata.get_cmd_info()
+
 	// bdata.Calls = new(api.Atomic_list)
 	bdata.Calls = &api.Atomic_list{Calls: make([]api.Atomic_call, 0, 2048)}
 	bdata.Calls.Nvim_command([]byte("ownsyntax"))
@@ -244,9 +283,21 @@ func handle_kind(i int, ft *Ftdata, info *cmd_info, tags []scan.Tag) []byte {
 
 func (bdata *Bufdata) update_from_cache() {
 	api.Echo("Updating from cache")
-	api.Nvim_call_atomic(0, bdata.Calls)
-	if bdata.Ft.Restore_Cmds != nil {
-		api.Nvim_command(0, bdata.Ft.Restore_Cmds)
+	if use_extmarks() {
+		/* Unlike syntax commands, positions go stale as soon as the buffer
+		 * changes, so only the list of tags can be reused. */
+		if err := bdata.update_extmarks(bdata.Highlighted); err != nil {
+			util.Eprintf("Failed to place highlights: %s\n", err)
+			return
+		}
+	}
+	if err := api.Nvim_call_atomic(0, bdata.Calls); err != nil {
+		util.Eprintf("Failed to apply highlight commands: %s\n", err)
+	}
+	if bdata.Ft.Restore_Cmds != nil && !use_extmarks() {
+		if err := api.Nvim_command(0, bdata.Ft.Restore_Cmds); err != nil {
+			util.Eprintf("%s\n", err)
+		}
 		// api.Echo("%s", bdata.Ft.Restore_Cmds)
 	}
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"tag_highlight/scan"
+	"testing"
+)
+
+func TestUpdateHighlight(t *testing.T) {
+	f := new_test_fake(t)
+	old_backend := Settings.Backend
+	Settings.Backend = ""
+	defer func() { Settings.Backend = old_backend }()
+
+	bdata := new_test_buffer(t, f, "/tmp/thl_update.c", "c",
+		"struct foo *make_foo(void);",
+		"int main(void) { return make_foo() != NULL; }")
+	bdata.Ft.Order = []byte("fs")
+	bdata.Topdir.Records = []scan.Tag_Record{
+		{Name: "make_foo", Path: "/tmp/foo.h", Language: "C", Kind: 'f'},
+		{Name: "foo", Path: "/tmp/foo.h", Language: "C", Kind: 's'},
+		{Name: "unused", Path: "/tmp/foo.h", Language: "C", Kind: 'f'},
+	}
+	f.Set_Var("tag_highlight#restored_groups", map[string]interface{}{})
+	f.Set_Var("tag_highlight#c#f", map[string]interface{}{"group": "CFuncTag"})
+	f.Set_Var("tag_highlight#c#s", map[string]interface{}{"group": "CStructTag"})
+
+	bdata.Update_Highlight()
+
+	atomic := f.Get_Atomic()
+	if len(atomic) != 1 {
+		t.Fatalf("got %d atomic calls, want 1", len(atomic))
+	}
+	var cmds []string
+	for _, call := range atomic[0] {
+		if call.Method != "nvim_command" || len(call.Args) != 1 {
+			t.Fatalf("unexpected call %+v", call)
+		}
+		cmds = append(cmds, arg_str(call.Args[0]))
+	}
+
+	want := []string{
+		"ownsyntax",
+		"silent! syntax clear _tag_highlight_c_f_CFuncTag |  syntax keyword _tag_highlight_c_f_CFuncTag make_foo display | hi def link _tag_highlight_c_f_CFuncTag CFuncTag",
+		"silent! syntax clear _tag_highlight_c_s_CStructTag |  syntax keyword _tag_highlight_c_s_CStructTag foo display | hi def link _tag_highlight_c_s_CStructTag CStructTag",
+	}
+	if strings.Join(cmds, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got commands\n%s\nwant\n%s", strings.Join(cmds, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestGetRestoreCmds(t *testing.T) {
+	f := new_test_fake(t)
+	f.Output["syntax list cConstant"] = "--- Syntax items ---\n" +
+		"cConstant      xxx NULL EOF\n" +
+		"                   stdin stdout\n" +
+		"                   links to Constant"
+	f.Output["syntax list cPattern"] = "--- Syntax items ---\n" +
+		"cPattern       xxx match /foo/ \n" +
+		"                   links to Special"
+
+	got := string(get_restore_cmds([][]byte{[]byte("cConstant"), []byte("cMissing"), []byte("cPattern")}))
+	for _, want := range []string{
+		"syntax clear cConstant | syntax keyword cConstant ",
+		"NULL EOF",
+		"stdin stdout",
+		"hi! link cConstant Constant",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q is missing %q", got, want)
+		}
+	}
+	if strings.Contains(got, "cMissing") {
+		t.Errorf("%q restores a group with no output", got)
+	}
+	if strings.Contains(got, "syntax keyword cPattern") {
+		t.Errorf("%q turned a match into keywords", got)
+	}
+
+	cmds := f.Get_Commands()
+	if len(cmds) != 3 || cmds[0] != "syntax list cConstant" || cmds[2] != "syntax list cPattern" {
+		t.Errorf("ran %q", cmds)
+	}
+}
+
+func arg_str(arg interface{}) string {
+	switch arg := arg.(type) {
+	case string:
+		return arg
+	case []byte:
+		return string(arg)
+	}
+	return ""
+}
@@ -133,3 +133,24 @@ func Unique_Str(strlist []string) []string {
 
 	return ret
 }
+
+func Contains_Str(strlist []string, str string) bool {
+	for _, entry := range strlist {
+		if entry == str {
+			return true
+		}
+	}
+	return false
+}
+
+func Equal_Str(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"tag_highlight/api"
+	"tag_highlight/scan"
+	"tag_highlight/util"
+)
+
+const default_viewport_margin = 200
+
+// Lines [first, last) of a buffer, zero based.
+type line_range struct {
+	first int
+	last  int
+}
+
+/*
+ * State of the viewport backend for one buffer. The set of tags is worked out
+ * once per change of the tag list. After that only the lines that are visible
+ * (plus a margin) are ever looked at, and each bit of the buffer only once: the
+ * highlights are extmarks, so neovim moves them along with the text and all
+ * that's needed afterwards is to redo the lines that actually change.
+ */
+type viewport struct {
+	mutex   sync.Mutex
+	set     scan.Tag_Set
+	groups  map[byte][]byte
+	covered []line_range // Sorted and disjoint
+}
+
+//========================================================================================
+
+func use_viewport() bool {
+	return Settings.Backend == BACKEND_VIEWPORT
+}
+
+func viewport_margin() int {
+	if Settings.Viewport_margin > 0 {
+		return Settings.Viewport_margin
+	}
+	return default_viewport_margin
+}
+
+/*
+ * Starts over with a new list of tags: everything already highlighted is
+ * cleared and only what's currently visible is done again.
+ */
+func (bdata *Bufdata) reset_viewport(tags []scan.Tag, groups map[byte][]byte) (*api.Atomic_list, error) {
+	view := &bdata.View
+	view.mutex.Lock()
+	defer view.mutex.Unlock()
+
+	view.set = scan.Make_Tag_Set(tags, bdata.Ft.Order)
+	view.groups = groups
+	view.covered = nil
+
+	return bdata.update_viewport(true)
+}
+
+// Update_Viewport highlights whatever part of the buffer has come into view
+// since last time. It does nothing unless the viewport backend is in use.
+func (bdata *Bufdata) Update_Viewport() {
+	view := &bdata.View
+	view.mutex.Lock()
+	defer view.mutex.Unlock()
+
+	if !use_viewport() || view.set == nil {
+		return
+	}
+	calls, err := bdata.update_viewport(false)
+	if err == nil && len(calls.Calls) > 0 {
+		err = api.Nvim_call_atomic(0, calls)
+	}
+	if err != nil {
+		util.Eprintf("Failed to update the viewport of buffer %d: %s\n", bdata.Num, err)
+	}
+}
+
+/* Call with the viewport's lock held. */
+func (bdata *Bufdata) update_viewport(clear_all bool) (*api.Atomic_list, error) {
+	ns, err := get_namespace()
+	if err != nil {
+		return nil, err
+	}
+	visible, err := get_visible_lines(int(bdata.Num))
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		view   = &bdata.View
+		margin = viewport_margin()
+		calls  = &api.Atomic_list{Calls: make([]api.Atomic_call, 0, 1024)}
+	)
+	if clear_all {
+		calls.Nvim_buf_clear_namespace(int(bdata.Num), ns, 0, (-1))
+	}
+
+	for _, rng := range visible {
+		rng.first = util.Max_Int(rng.first-margin, 0)
+		rng.last = util.Min_Int(rng.last+margin, bdata.Lines.Len())
+
+		for _, gap := range view.uncovered(rng) {
+			bdata.highlight_range(calls, ns, gap)
+			view.cover(gap)
+		}
+	}
+
+	return calls, nil
+}
+
+func (bdata *Bufdata) highlight_range(calls *api.Atomic_list, ns int64, rng line_range) {
+	view := &bdata.View
+	calls.Nvim_buf_clear_namespace(int(bdata.Num), ns, rng.first, rng.last)
+
+	for _, pos := range bdata.Make_Scan_Struct().Find_Positions_In(view.set, rng.first, rng.last) {
+		if group := view.groups[pos.Kind]; group != nil {
+			calls.Nvim_buf_add_highlight(int(bdata.Num), ns, group, pos.Line, pos.Start, pos.End)
+		}
+	}
+}
+
+/*
+ * Ask neovim which lines are on screen in every window showing the buffer.
+ * line('w0') and line('w$') are one based and inclusive.
+ */
+func get_visible_lines(bufnum int) ([]line_range, error) {
+	expr := fmt.Sprintf("map(win_findbuf(%d), {_, w -> [line('w0', w), line('w$', w)]})", bufnum)
+	obj, err := api.New_Client(0).Nvim_eval(expr)
+	if err != nil {
+		return nil, err
+	}
+	var wins [][2]int
+	if err = obj.Unmarshal(&wins); err != nil {
+		return nil, err
+	}
+
+	ret := make([]line_range, 0, len(wins))
+	for _, w := range wins {
+		if w[0] > 0 && w[1] >= w[0] {
+			ret = append(ret, line_range{w[0] - 1, w[1]})
+		}
+	}
+	return ret, nil
+}
+
+//========================================================================================
+
+/*
+ * Called for every nvim_buf_lines_event: lines [first, last) were replaced by
+ * nlines new ones. Whatever was covered below the change moves with it, and
+ * the new lines are redone straight away if they were in a covered part of the
+ * buffer (which, since someone just typed there, they almost always are).
+ */
+func (bdata *Bufdata) viewport_lines_changed(first, last, nlines int) {
+	view := &bdata.View
+	view.mutex.Lock()
+	defer view.mutex.Unlock()
+
+	if !use_viewport() || view.set == nil {
+		return
+	}
+	var (
+		delta   = nlines - (last - first)
+		touched = false
+		covered = make([]line_range, 0, len(view.covered)+1)
+	)
+
+	for _, rng := range view.covered {
+		switch {
+		case rng.last < first:
+			covered = append(covered, rng)
+		case rng.first > last:
+			covered = append(covered, line_range{rng.first + delta, rng.last + delta})
+		default:
+			touched = true
+			if rng.first < first {
+				covered = append(covered, line_range{rng.first, first})
+			}
+			if rng.last > last {
+				covered = append(covered, line_range{last + delta, rng.last + delta})
+			}
+		}
+	}
+	view.covered = covered
+
+	if !touched || nlines == 0 {
+		return
+	}
+	ns, err := get_namespace()
+	if err != nil {
+		util.Eprintf("%s\n", err)
+		return
+	}
+
+	rng := line_range{first, util.Min_Int(first+nlines, bdata.Lines.Len())}
+	calls := &api.Atomic_list{Calls: make([]api.Atomic_call, 0, 64)}
+	bdata.highlight_range(calls, ns, rng)
+	view.cover(rng)
+
+	if err = api.Nvim_call_atomic(0, calls); err != nil {
+		util.Eprintf("Failed to update changed lines: %s\n", err)
+	}
+}
+
+/* After the highlight is cleared nothing is done until the next full update. */
+func (bdata *Bufdata) forget_viewport() {
+	bdata.View.mutex.Lock()
+	bdata.View.set = nil
+	bdata.View.covered = nil
+	bdata.View.mutex.Unlock()
+}
+
+//========================================================================================
+
+/* The parts of rng not yet covered. */
+func (view *viewport) uncovered(rng line_range) []line_range {
+	var ret []line_range
+	cur := rng.first
+
+	for _, c := range view.covered {
+		if c.last <= cur {
+			continue
+		}
+		if c.first >= rng.last {
+			break
+		}
+		if c.first > cur {
+			ret = append(ret, line_range{cur, c.first})
+		}
+		cur = c.last
+	}
+	if cur < rng.last {
+		ret = append(ret, line_range{cur, rng.last})
+	}
+
+	return ret
+}
+
+/* Adds rng to the covered ranges, merging it with any it overlaps or touches. */
+func (view *viewport) cover(rng line_range) {
+	if rng.first >= rng.last {
+		return
+	}
+	ret := make([]line_range, 0, len(view.covered)+1)
+	i := 0
+
+	for ; i < len(view.covered) && view.covered[i].last < rng.first; i++ {
+		ret = append(ret, view.covered[i])
+	}
+	for ; i < len(view.covered) && view.covered[i].first <= rng.last; i++ {
+		rng.first = util.Min_Int(rng.first, view.covered[i].first)
+		rng.last = util.Max_Int(rng.last, view.covered[i].last)
+	}
+	ret = append(ret, rng)
+	view.covered = append(ret, view.covered[i:]...)
+}
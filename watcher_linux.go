@@ -0,0 +1,326 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	sys "syscall"
+	"tag_highlight/api"
+	"tag_highlight/util"
+	"time"
+	"unsafe"
+)
+
+/*
+ * Tags are normally only updated when a buffer is written, so anything that
+ * changes files behind the editor's back (git checkout, code generators, a
+ * build) leaves them stale. Each project that is tagged recursively therefore
+ * has an inotify instance watching every directory in it that would be tagged,
+ * i.e. all but hidden ones and those in Settings.Norecurse_dirs. Changes are
+ * gathered until things have been quiet for watch_debounce, then just the
+ * changed files are retagged and the project's visible buffers highlighted
+ * again. That's done holding event_mutex, like everything else that touches
+ * buffers, and files a write from the editor has already had retagged are
+ * left alone.
+ */
+type project_watcher struct {
+	mutex   sync.Mutex
+	topdir  *TopDir
+	ft      *Ftdata
+	file    *os.File // The inotify instance
+	fd      int      // ... as Fd() would make it blocking
+	wds     map[int32]string
+	pending map[string]bool
+	dirs    map[string]bool // Directories removed, with whatever was in them
+	rescan  bool            // Events were lost, so check everything
+	timer   *time.Timer
+	full    bool // Ran out of watches
+}
+
+const (
+	watch_debounce = 300 * time.Millisecond
+	watch_dir_mask = sys.IN_CLOSE_WRITE | sys.IN_CREATE | sys.IN_DELETE | sys.IN_MOVED_FROM |
+		sys.IN_MOVED_TO | sys.IN_DELETE_SELF | sys.IN_ONLYDIR
+)
+
+//========================================================================================
+
+/* Start watching the project, if it's one that is tagged recursively. */
+func (topdir *TopDir) start_watching(ft *Ftdata) {
+	if Settings.No_watch || !topdir.Recurse || topdir.Is_C || topdir.watcher != nil {
+		return
+	}
+
+	fd, err := sys.InotifyInit1(sys.IN_NONBLOCK | sys.IN_CLOEXEC)
+	if err != nil {
+		util.Warn("Failed to start watching '%s': %s", topdir.Pathname, err)
+		return
+	}
+	watcher := &project_watcher{
+		topdir:  topdir,
+		ft:      ft,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		fd:      fd,
+		wds:     make(map[int32]string, 64),
+		pending: make(map[string]bool, 16),
+		dirs:    make(map[string]bool, 4),
+	}
+
+	watcher.add_tree(topdir.Pathname)
+	api.Echo("Watching %d directories under '%s'", len(watcher.wds), topdir.Pathname)
+
+	topdir.watcher = watcher
+	go watcher.read_events()
+}
+
+func (topdir *TopDir) stop_watching() {
+	if watcher := topdir.watcher; watcher != nil {
+		topdir.watcher = nil
+		watcher.mutex.Lock()
+		if watcher.timer != nil {
+			watcher.timer.Stop()
+		}
+		watcher.mutex.Unlock()
+		/* The descriptor is non-blocking, so this also wakes up the reader. */
+		watcher.file.Close()
+	}
+}
+
+//========================================================================================
+
+func (watcher *project_watcher) add_tree(root string) {
+	filepath.WalkDir(root, func(path string, ent os.DirEntry, err error) error {
+		if err != nil || !ent.IsDir() {
+			return nil
+		}
+		if path != root && project_skip_dir(path) {
+			return filepath.SkipDir
+		}
+		if !watcher.add_watch(path) {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+}
+
+func (watcher *project_watcher) add_watch(path string) bool {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+	if watcher.full {
+		return false
+	}
+
+	wd, err := sys.InotifyAddWatch(watcher.fd, path, watch_dir_mask)
+	if err != nil {
+		if errors.Is(err, sys.ENOSPC) {
+			util.Warn("Out of inotify watches (see fs.inotify.max_user_watches); "+
+				"'%s' will only be partly watched", watcher.topdir.Pathname)
+			watcher.full = true
+			return false
+		}
+		return true
+	}
+	watcher.wds[int32(wd)] = path
+	return true
+}
+
+//========================================================================================
+
+func (watcher *project_watcher) read_events() {
+	buf := make([]byte, 64*(sys.SizeofInotifyEvent+sys.NAME_MAX+1))
+
+	for {
+		n, err := watcher.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				util.Warn("Stopped watching '%s': %s", watcher.topdir.Pathname, err)
+			}
+			return
+		}
+
+		for off := 0; off+sys.SizeofInotifyEvent <= n; {
+			var (
+				ev   = (*sys.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				name = buf[off+sys.SizeofInotifyEvent : off+sys.SizeofInotifyEvent+int(ev.Len)]
+			)
+			watcher.handle_event(ev, strings.TrimRight(string(name), "\x00"))
+			off += sys.SizeofInotifyEvent + int(ev.Len)
+		}
+	}
+}
+
+func (watcher *project_watcher) handle_event(ev *sys.InotifyEvent, name string) {
+	if ev.Mask&sys.IN_Q_OVERFLOW != 0 {
+		util.Warn("Lost inotify events for '%s'; checking every file", watcher.topdir.Pathname)
+		watcher.queue(func() { watcher.rescan = true })
+		return
+	}
+
+	watcher.mutex.Lock()
+	dir, ok := watcher.wds[ev.Wd]
+	if ev.Mask&sys.IN_IGNORED != 0 {
+		delete(watcher.wds, ev.Wd)
+	}
+	watcher.mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	if ev.Mask&sys.IN_DELETE_SELF != 0 {
+		watcher.queue(func() { watcher.dirs[dir] = true })
+		return
+	}
+	if name == "" || strings.HasPrefix(name, ".") {
+		return
+	}
+	path := filepath.Join(dir, name)
+
+	if ev.Mask&sys.IN_ISDIR != 0 {
+		switch {
+		case ev.Mask&(sys.IN_CREATE|sys.IN_MOVED_TO) != 0 && !project_skip_dir(path):
+			/* A new directory may well arrive with files already in it (from
+			 * a mv or a checkout), which no event will be seen for. */
+			watcher.add_tree(path)
+			filepath.WalkDir(path, func(p string, ent os.DirEntry, err error) error {
+				switch {
+				case err != nil:
+				case ent.IsDir():
+					if p != path && project_skip_dir(p) {
+						return filepath.SkipDir
+					}
+				default:
+					watcher.queue(func() { watcher.pending[p] = true })
+				}
+				return nil
+			})
+		case ev.Mask&sys.IN_MOVED_FROM != 0:
+			/* The watches follow it to wherever it went, under the wrong name. */
+			watcher.remove_tree(path)
+			watcher.queue(func() { watcher.dirs[path] = true })
+		}
+		return
+	}
+
+	/* A bare IN_CREATE is followed by an IN_CLOSE_WRITE once it's written. */
+	if ev.Mask&(sys.IN_CLOSE_WRITE|sys.IN_DELETE|sys.IN_MOVED_FROM|sys.IN_MOVED_TO) != 0 {
+		watcher.queue(func() { watcher.pending[path] = true })
+	}
+}
+
+func (watcher *project_watcher) remove_tree(root string) {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	prefix := root + string(filepath.Separator)
+	for wd, path := range watcher.wds {
+		if path == root || strings.HasPrefix(path, prefix) {
+			sys.InotifyRmWatch(watcher.fd, uint32(wd))
+			delete(watcher.wds, wd)
+		}
+	}
+}
+
+/* Records a change (with the mutex held) and (re)starts the timer. */
+func (watcher *project_watcher) queue(add func()) {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	add()
+	if watcher.timer == nil {
+		watcher.timer = time.AfterFunc(watch_debounce, watcher.flush)
+	} else {
+		watcher.timer.Reset(watch_debounce)
+	}
+}
+
+//========================================================================================
+
+func (watcher *project_watcher) flush() {
+	watcher.mutex.Lock()
+	var (
+		files  = make([]string, 0, len(watcher.pending))
+		dirs   = make([]string, 0, len(watcher.dirs))
+		rescan = watcher.rescan
+	)
+	for path := range watcher.pending {
+		files = append(files, path)
+	}
+	for path := range watcher.dirs {
+		dirs = append(dirs, path)
+	}
+	watcher.pending = make(map[string]bool, 16)
+	watcher.dirs = make(map[string]bool, 4)
+	watcher.rescan = false
+	watcher.mutex.Unlock()
+
+	/* This is on the timer's goroutine. */
+	event_mutex.Lock()
+	defer event_mutex.Unlock()
+	if watcher.topdir.watcher != watcher {
+		return
+	}
+	watcher.topdir.retag_changed(watcher.ft, watcher.changed_files(files, dirs, rescan))
+}
+
+/*
+ * Works out which files actually need retagging: those in removed directories
+ * are looked up in the database, and after an overflow everything in the
+ * project is checked. Files whose stamps haven't changed since they were tagged
+ * (because the editor wrote them and they were retagged then) are dropped.
+ */
+func (watcher *project_watcher) changed_files(files, dirs []string, rescan bool) []string {
+	db := &watcher.topdir.DB
+
+	if rescan {
+		stamps := project_file_stamps(watcher.topdir.Pathname)
+		if db.populated() {
+			return db.changed(stamps)
+		}
+		for path := range stamps {
+			files = append(files, path)
+		}
+		return files
+	}
+
+	for _, dir := range dirs {
+		files = append(files, db.files_under(dir)...)
+	}
+	ret := make([]string, 0, len(files))
+	for _, path := range util.Unique_Str(files) {
+		if db.stale(path) {
+			ret = append(ret, path)
+		}
+	}
+	return ret
+}
+
+/*
+ * Retags the files and rehighlights the project's buffers, at least those that
+ * are on screen. The rest will be redone when they're next entered.
+ */
+func (topdir *TopDir) retag_changed(ft *Ftdata, files []string) {
+	if len(files) == 0 {
+		return
+	}
+	api.Echo("Retagging %d changed files under '%s'", len(files), topdir.Pathname)
+	timer := util.NewTimer()
+	if !topdir.Retag_Files(ft, files) {
+		return
+	}
+	if !topdir.Write_Gzfile(int(Settings.Comp_type)) {
+		api.Echo("Error writing gzfile")
+	}
+
+	for _, bdata := range buffers.lst {
+		if bdata == nil || bdata.Topdir != topdir {
+			continue
+		}
+		bdata.Calls = nil
+		if wins, err := api.New_Client(0).Nvim_call_function("win_findbuf", []interface{}{int(bdata.Num)}); err == nil && wins.Len() > 0 {
+			bdata.Update_Highlight()
+		}
+	}
+	timer.EchoReport("retagging changed files")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"tag_highlight/lists"
+	"testing"
+	"time"
+)
+
+func write_go_file(t *testing.T, path, src string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/* Waits for the watcher to retag the project until cond holds. */
+func wait_for_tags(t *testing.T, topdir *TopDir, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+		event_mutex.Lock()
+		ok := cond()
+		event_mutex.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestWatcher(t *testing.T) {
+	new_test_fake(t)
+
+	var (
+		dir     = t.TempDir()
+		outside = t.TempDir()
+		ft      = *id_filetype("go")
+	)
+	old_settings := Settings
+	Settings.Go_deps = false
+	Settings.Go_use_ctags = false
+	Settings.Tag_sources = nil
+	Settings.No_watch = false
+	Settings.Norecurse_dirs = []string{filepath.Join(dir, "moved", "skipped")}
+	defer func() { Settings = old_settings }()
+
+	fname := filepath.Join(dir, "main.go")
+	src := "package main\n\nfunc main() {}\n"
+	write_go_file(t, fname, src)
+
+	bdata := &Bufdata{
+		Filename: fname,
+		Ft:       &ft,
+		Lines:    lists.New_Line_Tree(""),
+		Topdir: &TopDir{
+			Id: ft.Id, ft: &ft, Pathname: dir, Recurse: true,
+			Gzfile: filepath.Join(outside, "tags.gz"),
+		},
+	}
+	bdata.Lines.Replace(0, -1, strings.Split(src, "\n"))
+	topdir := bdata.Topdir
+
+	if !bdata.Generate_Tags(0) {
+		t.Fatal("Generate_Tags failed")
+	}
+	if !has_tag(topdir, "main") {
+		t.Fatal("the project wasn't tagged")
+	}
+	topdir.start_watching(&ft)
+	defer topdir.stop_watching()
+	if topdir.watcher == nil {
+		t.Fatal("the project isn't being watched")
+	}
+
+	/* A file written behind the editor's back. */
+	write_go_file(t, filepath.Join(dir, "new.go"), "package main\n\nfunc Written() {}\n")
+	wait_for_tags(t, topdir, "a new file to be tagged", func() bool {
+		return has_tag(topdir, "Written")
+	})
+
+	/* A directory moved in with files already in it. Its hidden and norecurse
+	 * subdirectories must be left alone, just as when the project was tagged. */
+	staged := filepath.Join(outside, "moved")
+	write_go_file(t, filepath.Join(staged, "a.go"), "package moved\n\nfunc Moved() {}\n")
+	write_go_file(t, filepath.Join(staged, "sub", "b.go"), "package sub\n\nfunc Nested() {}\n")
+	write_go_file(t, filepath.Join(staged, ".hidden", "c.go"), "package hidden\n\nfunc Hidden() {}\n")
+	write_go_file(t, filepath.Join(staged, "skipped", "d.go"), "package skipped\n\nfunc Skipped() {}\n")
+	if err := os.Rename(staged, filepath.Join(dir, "moved")); err != nil {
+		t.Fatal(err)
+	}
+	wait_for_tags(t, topdir, "a moved in directory to be tagged", func() bool {
+		return has_tag(topdir, "Moved") && has_tag(topdir, "Nested")
+	})
+	if has_tag(topdir, "Hidden") || has_tag(topdir, "Skipped") {
+		t.Error("files in a hidden or norecurse directory were tagged")
+	}
+
+	/* A directory moved out again. No event is seen for the files inside, so
+	 * they can only be found in the database. */
+	event_mutex.Lock()
+	under := topdir.DB.files_under(filepath.Join(dir, "moved"))
+	event_mutex.Unlock()
+	if len(under) != 2 {
+		t.Errorf("database has %q under the moved directory", under)
+	}
+	if err := os.Rename(filepath.Join(dir, "moved"), filepath.Join(outside, "gone")); err != nil {
+		t.Fatal(err)
+	}
+	wait_for_tags(t, topdir, "a removed directory's tags to be dropped", func() bool {
+		return !has_tag(topdir, "Moved") && !has_tag(topdir, "Nested")
+	})
+	if !has_tag(topdir, "main") || !has_tag(topdir, "Written") {
+		t.Error("tags were lost when a directory was removed")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+/* Only Linux has inotify; elsewhere tags are updated on write alone. */
+type project_watcher struct{}
+
+func (topdir *TopDir) start_watching(ft *Ftdata) {}
+func (topdir *TopDir) stop_watching()            {}